@@ -14,6 +14,7 @@ import (
 	"github.com/ihxw/termiscope/internal/handlers"
 	"github.com/ihxw/termiscope/internal/middleware"
 	"github.com/ihxw/termiscope/internal/monitor"
+	"github.com/ihxw/termiscope/internal/storage"
 	"github.com/ihxw/termiscope/internal/utils"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
@@ -31,6 +32,12 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	// Recordings and backups go to local disk or an object store
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
 	// Run migrations
 	if err := database.RunMigrations(db); err != nil {
 		log.Fatalf("Failed to run migrations: %v", err)
@@ -59,6 +66,25 @@ func main() {
 	// Initialize separate Error Logger
 	utils.InitErrorLogger("logs/error.log")
 
+	// Bring recordings from older versions up to asciicast v2 and into storage, then make them searchable
+	go func() {
+		handlers.UpgradeRecordings(db)
+		handlers.ImportRecordings(db, store)
+		handlers.IndexRecordings(db, store)
+	}()
+
+	// Recordings are signed with a key of their own; earlier keys stay trusted
+	recordingKeys, err := handlers.LoadRecordingKeys(db, cfg)
+	if err != nil {
+		log.Fatalf("Failed to load recording signing key: %v", err)
+	}
+
+	// Finish recordings of sessions cut off by a restart and upload spooled ones
+	handlers.StartRecordingRecovery(db, store, recordingKeys)
+
+	// Remove recordings that break the retention rules
+	handlers.StartRecordingRetention(db, cfg, store)
+
 	// Start Monitor Background Checker
 	monitor.StartMonitorChecker(db)
 
@@ -78,6 +104,10 @@ func main() {
 	// Global Middlewares
 	router.Use(middleware.SecurityMiddleware())
 
+	// Web proxy to services on a host (HTTP and WebSocket over SSH), served from its own origin
+	webProxyHandler := handlers.NewWebProxyHandler(db, cfg)
+	router.Use(webProxyHandler.SeparateOrigin())
+
 	// Auth rate limiter (20 attempts per minute per IP)
 	loginRateLimiter := middleware.NewRateLimiter(20, 1*time.Minute)
 
@@ -91,13 +121,18 @@ func main() {
 	router.GET("/api/system/info", authHandler.GetSystemInfo)
 
 	// WebSocket SSH route (authenticated via one-time ticket in handler)
-	sshWSHandler := handlers.NewSSHWebSocketHandler(db, cfg)
+	sshWSHandler := handlers.NewSSHWebSocketHandler(db, cfg, store, recordingKeys)
 	router.GET("/api/ws/ssh/:hostId", sshWSHandler.HandleWebSocket)
+	router.GET("/api/ws/share/:token", sshWSHandler.HandleShareWebSocket)
+	router.GET("/api/ws/shadow/:id", sshWSHandler.HandleShadowWebSocket)
 
 	// Monitor routes
 	monitorHandler := handlers.NewMonitorHandler(db, cfg)
 	router.POST("/api/monitor/pulse", monitorHandler.Pulse) // Agent reports here using Secret Header
 
+	// Proxied services authenticate with proxy sessions, not access tokens
+	router.Any("/proxy/:hostId/:port/*path", webProxyHandler.Proxy)
+
 	// Protected routes
 	protected := router.Group("/api")
 	protected.Use(middleware.AuthMiddleware(cfg.Security.JWTSecret))
@@ -117,6 +152,7 @@ func main() {
 		protected.POST("/ssh-hosts/:id/test", sshHostHandler.TestConnection)
 		protected.PUT("/ssh-hosts/:id/fingerprint", sshHostHandler.UpdateFingerprint)
 		protected.PUT("/ssh-hosts/reorder", sshHostHandler.Reorder)
+		protected.POST("/ssh-hosts/:id/web-proxy/:port", webProxyHandler.Launch)
 
 		// Monitor Management
 		protected.GET("/monitor/stream", monitorHandler.Stream)
@@ -135,10 +171,53 @@ func main() {
 		protected.POST("/sftp/mkdir/:hostId", sftpHandler.Mkdir)
 		protected.POST("/sftp/create/:hostId", sftpHandler.CreateFile)
 
+		// Terminal session routes (detach/reattach)
+		termSessionHandler := handlers.NewTerminalSessionHandler(db)
+		protected.GET("/terminal/sessions", termSessionHandler.List)
+		protected.GET("/terminal/sessions/:id", termSessionHandler.Get)
+		protected.DELETE("/terminal/sessions/:id", termSessionHandler.Terminate)
+		protected.POST("/terminal/sessions/:id/shares", termSessionHandler.CreateShare)
+		protected.DELETE("/terminal/sessions/:id/shares/:token", termSessionHandler.RevokeShare)
+		protected.DELETE("/terminal/sessions/:id/viewers/:viewerId", termSessionHandler.KickViewer)
+
+		// Broadcast groups: the same input to several sessions
+		broadcastHandler := handlers.NewBroadcastHandler(db)
+		protected.GET("/terminal/broadcasts", broadcastHandler.List)
+		protected.POST("/terminal/broadcasts", broadcastHandler.Create)
+		protected.DELETE("/terminal/broadcasts/:id", broadcastHandler.Delete)
+		protected.POST("/terminal/broadcasts/:id/members", broadcastHandler.AddMember)
+		protected.PUT("/terminal/broadcasts/:id/members/:sessionId", broadcastHandler.UpdateMember)
+		protected.DELETE("/terminal/broadcasts/:id/members/:sessionId", broadcastHandler.RemoveMember)
+		protected.POST("/terminal/broadcasts/:id/input", broadcastHandler.Input)
+
+		// Concurrent connection counts and limits
+		connCountHandler := handlers.NewConnectionCountHandler(db, cfg)
+		protected.GET("/connections/counts", connCountHandler.Mine)
+
 		// Connection log routes
 		logHandler := handlers.NewConnectionLogHandler(db)
 		protected.GET("/connection-logs", logHandler.List)
 
+		// Audit log routes
+		auditHandler := handlers.NewAuditLogHandler(db)
+		protected.GET("/audit-logs", auditHandler.List)
+
+		// Key agent routes (agent forwarding)
+		agentHandler := handlers.NewAgentHandler(db, cfg)
+		protected.GET("/agent/keys", agentHandler.ListKeys)
+		protected.POST("/agent/keys", agentHandler.AddKey)
+		protected.DELETE("/agent/keys", agentHandler.RemoveKey)
+		protected.POST("/agent/clear", agentHandler.ClearKeys)
+
+		// Port forwarding routes
+		tunnelHandler := handlers.NewTunnelHandler(db, cfg)
+		tunnelHandler.RestoreAutoStart()
+		protected.GET("/tunnels", tunnelHandler.List)
+		protected.POST("/tunnels", tunnelHandler.Create)
+		protected.DELETE("/tunnels/:id", tunnelHandler.Delete)
+		protected.POST("/tunnels/:id/start", tunnelHandler.Start)
+		protected.POST("/tunnels/:id/stop", tunnelHandler.Stop)
+
 		// Command template routes
 		cmdHandler := handlers.NewCommandTemplateHandler(db)
 		protected.GET("/command-templates", cmdHandler.List)
@@ -147,9 +226,13 @@ func main() {
 		protected.DELETE("/command-templates/:id", cmdHandler.Delete)
 
 		// Recording routes
-		recHandler := handlers.NewRecordingHandler(db)
+		recHandler := handlers.NewRecordingHandler(db, cfg, store, recordingKeys)
 		protected.GET("/recordings", recHandler.List)
+		protected.GET("/recordings/search", recHandler.Search)
+		protected.GET("/recordings/signing-key", recHandler.SigningKey)
 		protected.GET("/recordings/:id/stream", recHandler.GetStream)
+		protected.GET("/recordings/:id/verify", recHandler.Verify)
+		protected.GET("/recordings/:id/export", recHandler.Export)
 		protected.DELETE("/recordings/:id", recHandler.Delete)
 
 		// 2FA routes
@@ -174,11 +257,44 @@ func main() {
 				users.DELETE("/:id", userHandler.DeleteUser)
 			}
 
+			// Live session monitor
+			sessionMonitorHandler := handlers.NewSessionMonitorHandler(db)
+			adminGroup.GET("/admin/sessions", sessionMonitorHandler.List)
+			adminGroup.POST("/admin/sessions/:id/kill", sessionMonitorHandler.Kill)
+			adminGroup.GET("/admin/connections/counts", connCountHandler.All)
+
+			// Command audit trail
+			commandAuditHandler := handlers.NewCommandAuditHandler(db)
+			adminGroup.GET("/admin/commands", commandAuditHandler.List)
+
+			// Dangerous-command guardrails
+			commandPolicyHandler := handlers.NewCommandPolicyHandler(db)
+			adminGroup.GET("/admin/command-policies", commandPolicyHandler.List)
+			adminGroup.POST("/admin/command-policies", commandPolicyHandler.Create)
+			adminGroup.PUT("/admin/command-policies/:id", commandPolicyHandler.Update)
+			adminGroup.DELETE("/admin/command-policies/:id", commandPolicyHandler.Delete)
+
+			// Server-side recording policies
+			recordingPolicyHandler := handlers.NewRecordingPolicyHandler(db)
+			adminGroup.GET("/admin/recording-policies", recordingPolicyHandler.List)
+			adminGroup.POST("/admin/recording-policies", recordingPolicyHandler.Create)
+			adminGroup.PUT("/admin/recording-policies/:id", recordingPolicyHandler.Update)
+			adminGroup.DELETE("/admin/recording-policies/:id", recordingPolicyHandler.Delete)
+
+			// Recordings across users, legal hold and storage usage
+			adminGroup.GET("/admin/recordings", recHandler.List)
+			adminGroup.GET("/admin/recordings/usage", recHandler.Usage)
+			adminGroup.DELETE("/admin/recordings/:id", recHandler.AdminDelete)
+			adminGroup.PUT("/admin/recordings/:id/hold", recHandler.SetLegalHold)
+
 			// System management
-			systemHandler := handlers.NewSystemHandler(db, cfg)
+			systemHandler := handlers.NewSystemHandler(db, cfg, store)
 			system := adminGroup.Group("/system")
 			{
 				system.GET("/backup", systemHandler.Backup)
+				system.GET("/backups", systemHandler.ListBackups)
+				system.GET("/backups/:name", systemHandler.DownloadBackup)
+				system.DELETE("/backups/:name", systemHandler.DeleteBackup)
 				system.POST("/restore", systemHandler.Restore)
 				system.GET("/settings", systemHandler.GetSettings)
 				system.PUT("/settings", systemHandler.UpdateSettings)
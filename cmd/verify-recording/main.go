@@ -0,0 +1,84 @@
+// Command verify-recording checks a terminal recording against its signature without the
+// server: the file, the manifest from GET /api/recordings/:id/verify and the server's
+// public key from GET /api/recordings/signing-key are all it needs.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ihxw/termiscope/internal/recsign"
+)
+
+func main() {
+	manifestPath := flag.String("manifest", "", "Manifest JSON, or the saved response of the verify endpoint")
+	key := flag.String("key", "", "Base64 public key of the server (default: the key named in the manifest)")
+	flag.Parse()
+	if *manifestPath == "" || flag.NArg() != 1 {
+		log.Fatal("Usage: verify-recording -manifest <manifest.json> [-key <public_key>] <recording.cast.gz>")
+	}
+
+	m, err := readManifest(*manifestPath)
+	if err != nil {
+		log.Fatalf("Failed to read manifest: %v", err)
+	}
+
+	keyText := *key
+	if keyText == "" {
+		// Only proves the file matches the manifest, not who signed it
+		fmt.Fprintln(os.Stderr, "warning: no -key given, trusting the key in the manifest")
+		keyText = m.PublicKey
+	}
+	pub, err := recsign.ParseKey(keyText)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := recsign.Verify(pub, m, f); err != nil {
+		f.Close()
+		fmt.Printf("FAILED: recording %d: %v\n", m.RecordingID, err)
+		os.Exit(1)
+	}
+	fmt.Printf("OK: recording %d (user %d, host %d) has %d events matching digest %s\n",
+		m.RecordingID, m.UserID, m.HostID, m.Events, m.Digest)
+}
+
+// readManifest accepts a bare manifest, the verify endpoint's data, or its full response
+func readManifest(path string) (recsign.Manifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return recsign.Manifest{}, err
+	}
+
+	var wrapped struct {
+		Manifest *recsign.Manifest `json:"manifest"`
+		Data     *struct {
+			Manifest *recsign.Manifest `json:"manifest"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(b, &wrapped); err != nil {
+		return recsign.Manifest{}, err
+	}
+	if wrapped.Data != nil && wrapped.Data.Manifest != nil {
+		return *wrapped.Data.Manifest, nil
+	}
+	if wrapped.Manifest != nil {
+		return *wrapped.Manifest, nil
+	}
+
+	var m recsign.Manifest
+	if err := json.Unmarshal(b, &m); err == nil && m.Scheme != "" {
+		return m, nil
+	}
+	return recsign.Manifest{}, errors.New("no manifest found; is the recording signed?")
+}
@@ -0,0 +1,270 @@
+// Package asciicast reads and writes terminal recordings in the asciicast v2 format:
+// a JSON header line followed by one [time, code, data] event per line.
+// See https://docs.asciinema.org/manual/asciicast/v2/
+package asciicast
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Version is the asciicast format version written
+const Version = 2
+
+// Event codes
+const (
+	EventOutput = "o" // Data written to the terminal
+	EventInput  = "i" // Data typed by the user
+	EventResize = "r" // Terminal resized, data is "COLSxROWS"
+	EventMarker = "m" // Marker, data is a label
+)
+
+// MaxLineSize bounds a single line when reading recordings
+const MaxLineSize = 4 << 20
+
+// Header is the first line of a recording
+type Header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Duration  float64           `json:"duration,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Event is one recorded event, Time in seconds since the start of the recording
+type Event struct {
+	Time float64
+	Code string
+	Data string
+}
+
+// MarshalJSON encodes the event as [time, code, data]
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{roundTime(e.Time), e.Code, e.Data})
+}
+
+// UnmarshalJSON decodes a [time, code, data] array
+func (e *Event) UnmarshalJSON(b []byte) error {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if len(raw) != 3 {
+		return fmt.Errorf("event has %d fields, want 3", len(raw))
+	}
+	if err := json.Unmarshal(raw[0], &e.Time); err != nil {
+		return fmt.Errorf("event time: %w", err)
+	}
+	if err := json.Unmarshal(raw[1], &e.Code); err != nil {
+		return fmt.Errorf("event code: %w", err)
+	}
+	if err := json.Unmarshal(raw[2], &e.Data); err != nil {
+		return fmt.Errorf("event data: %w", err)
+	}
+	return nil
+}
+
+// roundTime keeps event times to microseconds, as asciinema does
+func roundTime(t float64) float64 {
+	return float64(int64(t*1e6+0.5)) / 1e6
+}
+
+// ErrNoHeader is returned for a recording that starts with an event instead of a header,
+// as written by older versions
+var ErrNoHeader = errors.New("recording has no asciicast header")
+
+// ParseHeader decodes a header line
+func ParseHeader(line []byte) (Header, error) {
+	var h Header
+	trimmed := strings.TrimSpace(string(line))
+	if strings.HasPrefix(trimmed, "[") {
+		return h, ErrNoHeader
+	}
+	if err := json.Unmarshal([]byte(trimmed), &h); err != nil {
+		return h, fmt.Errorf("invalid header: %w", err)
+	}
+	if h.Version != Version {
+		return h, fmt.Errorf("unsupported asciicast version %d", h.Version)
+	}
+	if h.Width <= 0 || h.Height <= 0 {
+		return h, fmt.Errorf("invalid terminal size %dx%d", h.Width, h.Height)
+	}
+	return h, nil
+}
+
+// ParseEvent decodes an event line
+func ParseEvent(line []byte) (Event, error) {
+	var e Event
+	if err := json.Unmarshal(line, &e); err != nil {
+		return e, err
+	}
+	if e.Code == "" {
+		return e, errors.New("event has no code")
+	}
+	return e, nil
+}
+
+// ParseSize decodes the data of a resize event
+func ParseSize(data string) (cols, rows int, err error) {
+	c, r, ok := strings.Cut(data, "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid size %q", data)
+	}
+	if cols, err = strconv.Atoi(c); err != nil {
+		return 0, 0, err
+	}
+	if rows, err = strconv.Atoi(r); err != nil {
+		return 0, 0, err
+	}
+	return cols, rows, nil
+}
+
+// Writer records a live session. It is safe for concurrent use; write errors are kept
+// and reported by Err, later events are dropped.
+type Writer struct {
+	mu     sync.Mutex
+	dst    lineSink
+	start  time.Time
+	width  int
+	height int
+	out    []byte // Incomplete UTF-8 sequence held back from the last output
+	in     []byte // Same for input
+	err    error
+}
+
+// NewWriter writes the header and returns a writer for the events that follow.
+// Version and a missing Timestamp are filled in.
+func NewWriter(w io.Writer, h Header) (*Writer, error) {
+	return newWriter(plainSink{w}, h)
+}
+
+func newWriter(dst lineSink, h Header) (*Writer, error) {
+	h.Version = Version
+	start := time.Now()
+	if h.Timestamp == 0 {
+		h.Timestamp = start.Unix()
+	}
+	w := &Writer{dst: dst, start: start, width: h.Width, height: h.Height}
+
+	line, err := json.Marshal(h)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.writeLine(0, append(line, '\n')); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Output records terminal output
+func (w *Writer) Output(data []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var text string
+	text, w.out = completeUTF8(w.out, data)
+	w.write(EventOutput, text)
+}
+
+// Input records user input
+func (w *Writer) Input(data []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var text string
+	text, w.in = completeUTF8(w.in, data)
+	w.write(EventInput, text)
+}
+
+// Resize records a terminal size change
+func (w *Writer) Resize(cols, rows int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.width, w.height = cols, rows
+	w.write(EventResize, fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// Elapsed returns the time since the recording started
+func (w *Writer) Elapsed() time.Duration {
+	return time.Since(w.start)
+}
+
+// Err returns the first write error
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Close finishes the recording. The underlying files are not closed.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.dst.close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+func (w *Writer) write(code, data string) {
+	if w.err != nil || data == "" {
+		return
+	}
+	t := time.Since(w.start).Seconds()
+	line, err := json.Marshal(Event{Time: t, Code: code, Data: data})
+	if err != nil {
+		w.err = err
+		return
+	}
+	w.err = w.writeLine(t, append(line, '\n'))
+}
+
+func (w *Writer) writeLine(t float64, line []byte) error {
+	return w.dst.writeLine(t, line, w.width, w.height)
+}
+
+// lineSink stores encoded lines; t and the terminal size are for indexing
+type lineSink interface {
+	writeLine(t float64, line []byte, width, height int) error
+	close() error
+}
+
+// plainSink writes lines as they are
+type plainSink struct {
+	w io.Writer
+}
+
+func (p plainSink) writeLine(t float64, line []byte, width, height int) error {
+	_, err := p.w.Write(line)
+	return err
+}
+
+func (p plainSink) close() error {
+	return nil
+}
+
+// completeUTF8 joins carry and data and splits off a trailing incomplete UTF-8 sequence,
+// so a character split across reads is not recorded as two replacement characters
+func completeUTF8(carry, data []byte) (string, []byte) {
+	buf := append(carry, data...)
+	cut := len(buf)
+	// A UTF-8 sequence is at most 4 bytes: look back for its start
+	for i := len(buf) - 1; i >= 0 && i >= len(buf)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(buf[i]) {
+			continue
+		}
+		if !utf8.FullRune(buf[i:]) {
+			cut = i
+		}
+		break
+	}
+	rest := append([]byte(nil), buf[cut:]...)
+	return string(buf[:cut]), rest
+}
@@ -0,0 +1,63 @@
+package asciicast
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+)
+
+// Chain is the result of hashing a recording line by line
+type Chain struct {
+	Digest []byte // Hash after the last line
+	Events int    // Lines after the header
+}
+
+// HashChain hashes every line of a plain or compressed recording, header included, into a
+// chain seeded with seed: h0 = SHA-256(seed), hN = SHA-256(hN-1 || lineN), where a line
+// keeps its newline. Changing, removing, reordering or appending a line changes the
+// digest. Unlike Reader, nothing is skipped and a recording cut short is an error.
+func HashChain(src io.ReadSeeker, seed string) (Chain, error) {
+	compressed, err := isGzip(src)
+	if err != nil {
+		return Chain{}, err
+	}
+	lines, err := openLines(src, compressed)
+	if err != nil {
+		return Chain{}, err
+	}
+	lines.Split(scanRawLines)
+
+	sum := sha256.Sum256([]byte(seed))
+	digest := sum[:]
+	count := 0
+	for lines.Scan() {
+		h := sha256.New()
+		h.Write(digest)
+		h.Write(lines.Bytes())
+		digest = h.Sum(nil)
+		count++
+	}
+	if err := lines.Err(); err != nil {
+		return Chain{}, err
+	}
+
+	chain := Chain{Digest: digest}
+	if count > 0 {
+		chain.Events = count - 1
+	}
+	return chain, nil
+}
+
+// scanRawLines splits like bufio.ScanLines but keeps the line endings, so every byte is hashed
+func scanRawLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i+1], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
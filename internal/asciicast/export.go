@@ -0,0 +1,107 @@
+package asciicast
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ihxw/termiscope/internal/vt"
+)
+
+// WriteTranscript renders the output of a recording as plain text, one line per line of
+// the terminal. Output goes through a virtual terminal, so what was overwritten, erased
+// or redrawn appears as the user last saw it. The recording is read from the start,
+// since every earlier byte can affect the screen; with From set, lines that left the
+// screen before From are left out, and with To set, output after To is ignored.
+func WriteTranscript(dst io.Writer, src io.ReadSeeker, opts StreamOptions) error {
+	r, err := NewReader(src)
+	if err != nil {
+		return err
+	}
+	term := vt.New(r.Header.Width, r.Header.Height)
+
+	skip := -1 // Transcript lines before From, once From is reached
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if opts.To > 0 && e.Time > opts.To {
+			break
+		}
+		if skip < 0 && e.Time >= opts.From {
+			skip = term.Lines()
+		}
+		switch e.Code {
+		case EventOutput:
+			term.Write(e.Data)
+		case EventResize:
+			if cols, rows, err := ParseSize(e.Data); err == nil {
+				term.Resize(cols, rows)
+			}
+		}
+	}
+
+	lines := term.Transcript()
+	if skip < 0 {
+		skip = len(lines) // Nothing at or after From
+	}
+	out := bufio.NewWriter(dst)
+	for _, line := range lines[min(skip, len(lines)):] {
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Flush()
+}
+
+// typescriptTime is how script(1) writes times in its header and footer
+const typescriptTime = "2006-01-02 15:04:05-07:00"
+
+// WriteTypescript writes the output between From and To in the format of script(1): the
+// terminal output with a header and footer line in typescript, and for every write the
+// delay since the previous one and its size in bytes in timing. scriptreplay(1) plays
+// them back with: scriptreplay --timing=timing typescript
+func WriteTypescript(typescript, timing io.Writer, src io.ReadSeeker, index Index, opts StreamOptions) error {
+	ts := bufio.NewWriter(typescript)
+	tm := bufio.NewWriter(timing)
+
+	var started time.Time
+	last, end := 0.0, 0.0
+	err := eachInRange(src, index, opts, func(h Header) error {
+		started = time.Unix(h.Timestamp, 0)
+		term := "xterm-256color"
+		if h.Env["TERM"] != "" {
+			term = h.Env["TERM"]
+		}
+		_, err := fmt.Fprintf(ts, "Script started on %s [TERM=%q COLUMNS=\"%d\" LINES=\"%d\"]\n",
+			started.Format(typescriptTime), term, h.Width, h.Height)
+		return err
+	}, func(e Event) error {
+		end = e.Time
+		if e.Code != EventOutput {
+			return nil
+		}
+		if _, err := fmt.Fprintf(tm, "%.6f %d\n", e.Time-last, len(e.Data)); err != nil {
+			return err
+		}
+		last = e.Time
+		_, err := ts.WriteString(e.Data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	finished := started.Add(time.Duration(end * float64(time.Second)))
+	if _, err := fmt.Fprintf(ts, "\nScript done on %s\n", finished.Format(typescriptTime)); err != nil {
+		return err
+	}
+	if err := ts.Flush(); err != nil {
+		return err
+	}
+	return tm.Flush()
+}
@@ -0,0 +1,182 @@
+package asciicast
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+// Compressed recordings are a series of gzip members, each holding whole lines. Together
+// they are a valid gzip file of the plain recording, so gunzip reads them as usual. A
+// sidecar index lists where each member starts, so a player can seek without
+// decompressing what comes before.
+const (
+	CompressedExt = ".cast.gz"
+	IndexExt      = ".cast.idx"
+
+	SegmentDuration = 30 * time.Second // Start a new segment after this long
+	SegmentSize     = 1 << 20          // or after this many uncompressed bytes
+	flushInterval   = 2 * time.Second  // Bounds what a crash can lose
+)
+
+// Segment is an index entry: where a segment starts and the terminal size at that point
+type Segment struct {
+	Time   float64 `json:"time"`
+	Offset int64   `json:"offset"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+}
+
+// Index lists the segments of a compressed recording in order
+type Index []Segment
+
+// IndexPath returns the index file of a compressed recording
+func IndexPath(path string) string {
+	return strings.TrimSuffix(path, CompressedExt) + IndexExt
+}
+
+// ReadIndex decodes an index. A line cut short at the end is ignored.
+func ReadIndex(r io.Reader) (Index, error) {
+	var index Index
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var s Segment
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			continue
+		}
+		index = append(index, s)
+	}
+	return index, scanner.Err()
+}
+
+// Find returns the last segment starting at or before t
+func (ix Index) Find(t float64) (Segment, bool) {
+	found := false
+	var seg Segment
+	for _, s := range ix {
+		if s.Time > t && found {
+			break
+		}
+		seg, found = s, true
+	}
+	return seg, found
+}
+
+// NewCompressedWriter is like NewWriter, but writes gzip segments to data and their
+// index entries to index. Close must be called to finish the last segment.
+func NewCompressedWriter(data, index io.Writer, h Header) (*Writer, error) {
+	seg := &segmenter{out: &countingWriter{w: data}, index: index}
+	return newWriter(seg, h)
+}
+
+// Salvage copies what can be read of a recording, e.g. one cut short by a crash, into a
+// new compressed recording and index made of whole lines only. It returns the time of
+// the last event.
+func Salvage(data, index io.Writer, src io.ReadSeeker) (float64, error) {
+	r, err := NewReader(src)
+	if err != nil {
+		return 0, err
+	}
+	seg := &segmenter{out: &countingWriter{w: data}, index: index}
+	width, height := r.Header.Width, r.Header.Height
+
+	line, err := json.Marshal(r.Header)
+	if err != nil {
+		return 0, err
+	}
+	if err := seg.writeLine(0, append(line, '\n'), width, height); err != nil {
+		return 0, err
+	}
+
+	last := 0.0
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		if e.Code == EventResize {
+			if cols, rows, err := ParseSize(e.Data); err == nil {
+				width, height = cols, rows
+			}
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			return 0, err
+		}
+		if err := seg.writeLine(e.Time, append(line, '\n'), width, height); err != nil {
+			return 0, err
+		}
+		last = e.Time
+	}
+	return last, seg.close()
+}
+
+// segmenter splits the lines it is given into gzip members
+type segmenter struct {
+	out   *countingWriter
+	index io.Writer
+	gz    *gzip.Writer
+	open  bool // A member is being written
+
+	start   float64 // Time of the first line in the member
+	size    int     // Uncompressed bytes in the member
+	flushed time.Time
+}
+
+func (s *segmenter) writeLine(t float64, line []byte, width, height int) error {
+	if !s.open {
+		entry, _ := json.Marshal(Segment{Time: roundTime(t), Offset: s.out.n, Width: width, Height: height})
+		if _, err := s.index.Write(append(entry, '\n')); err != nil {
+			return err
+		}
+		if s.gz == nil {
+			s.gz = gzip.NewWriter(s.out)
+		} else {
+			s.gz.Reset(s.out)
+		}
+		s.open = true
+		s.start = t
+		s.size = 0
+		s.flushed = time.Now()
+	}
+
+	if _, err := s.gz.Write(line); err != nil {
+		return err
+	}
+	s.size += len(line)
+
+	if s.size >= SegmentSize || t-s.start >= SegmentDuration.Seconds() {
+		return s.close()
+	}
+	if time.Since(s.flushed) >= flushInterval {
+		s.flushed = time.Now()
+		return s.gz.Flush()
+	}
+	return nil
+}
+
+// close ends the current member
+func (s *segmenter) close() error {
+	if !s.open {
+		return nil
+	}
+	s.open = false
+	return s.gz.Close()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
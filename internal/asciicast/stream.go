@@ -0,0 +1,219 @@
+package asciicast
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Reader reads the events of a plain or compressed recording
+type Reader struct {
+	Header Header // The terminal size follows Seek
+
+	src        io.ReadSeeker
+	compressed bool
+	lines      *bufio.Scanner
+	pending    []byte // First event of a recording without header
+}
+
+// NewReader reads the header of a recording. A recording from before headers were
+// written gets an 80x24 header.
+func NewReader(src io.ReadSeeker) (*Reader, error) {
+	compressed, err := isGzip(src)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reader{
+		Header:     Header{Version: Version, Width: 80, Height: 24},
+		src:        src,
+		compressed: compressed,
+	}
+	if r.lines, err = openLines(src, compressed); err != nil {
+		return nil, err
+	}
+
+	if r.lines.Scan() {
+		h, err := ParseHeader(r.lines.Bytes())
+		switch {
+		case err == nil:
+			r.Header = h
+		case errors.Is(err, ErrNoHeader):
+			r.pending = append([]byte(nil), r.lines.Bytes()...)
+		default:
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Seek moves to the segment of a compressed recording that contains t. Events before t
+// may still follow; plain recordings and those without index stay where they are.
+func (r *Reader) Seek(index Index, t float64) error {
+	seg, ok := index.Find(t)
+	if !r.compressed || !ok || seg.Offset == 0 {
+		return nil
+	}
+	if _, err := r.src.Seek(seg.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	lines, err := openLines(r.src, true)
+	if err != nil {
+		return err
+	}
+	r.lines = lines
+	r.pending = nil
+	r.Header.Width, r.Header.Height = seg.Width, seg.Height
+	return nil
+}
+
+// Next returns the next event, io.EOF at the end. Lines that cannot be decoded are skipped
+// and a compressed recording cut short by a crash simply ends.
+func (r *Reader) Next() (Event, error) {
+	for {
+		var line []byte
+		if r.pending != nil {
+			line, r.pending = r.pending, nil
+		} else if r.lines.Scan() {
+			line = r.lines.Bytes()
+		} else {
+			if err := r.lines.Err(); err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return Event{}, err
+			}
+			return Event{}, io.EOF
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if e, err := ParseEvent(line); err == nil {
+			return e, nil
+		}
+	}
+}
+
+// StreamOptions selects part of a recording
+type StreamOptions struct {
+	From  float64 // Seconds; earlier events are skipped
+	To    float64 // Seconds; 0 for the end of the recording
+	Speed float64 // Event times are divided by it; 0 means 1
+}
+
+// Stream writes the events of a recording between From and To as a standalone asciicast:
+// the header carries the terminal size at From and event times start at zero. Output
+// before From is not replayed, so the screen fills in as the program redraws it.
+// src is a plain or compressed recording; with an index, a compressed recording is
+// decompressed from the segment containing From instead of from the start.
+func Stream(dst io.Writer, src io.ReadSeeker, index Index, opts StreamOptions) error {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+
+	out := bufio.NewWriter(dst)
+	err := eachInRange(src, index, opts, func(h Header) error {
+		h.Duration = 0
+		line, err := json.Marshal(h)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(line, '\n'))
+		return err
+	}, func(e Event) error {
+		e.Time /= opts.Speed
+		encoded, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(encoded, '\n'))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// eachInRange reads the events of src between From and To. It calls start once with the
+// header as of From, its terminal size and timestamp moved there, then fn for each event
+// with its time relative to From. Speed is left to the caller.
+func eachInRange(src io.ReadSeeker, index Index, opts StreamOptions, start func(Header) error, fn func(Event) error) error {
+	r, err := NewReader(src)
+	if err != nil {
+		return err
+	}
+	if err := r.Seek(index, opts.From); err != nil {
+		return err
+	}
+	header := r.Header
+
+	started := false
+	begin := func() error {
+		started = true
+		h := header
+		h.Timestamp += int64(opts.From)
+		return start(h)
+	}
+
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if opts.To > 0 && e.Time > opts.To {
+			break
+		}
+		if e.Time < opts.From {
+			// Skipped, but the terminal size still counts
+			if e.Code == EventResize {
+				if cols, rows, err := ParseSize(e.Data); err == nil {
+					header.Width, header.Height = cols, rows
+				}
+			}
+			continue
+		}
+
+		if !started {
+			if err := begin(); err != nil {
+				return err
+			}
+		}
+		e.Time -= opts.From
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	if !started {
+		return begin()
+	}
+	return nil
+}
+
+// isGzip reports whether src starts with the gzip magic number, leaving it at the start
+func isGzip(src io.ReadSeeker) (bool, error) {
+	magic := make([]byte, 2)
+	n, err := io.ReadFull(src, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	return n == 2 && magic[0] == 0x1f && magic[1] == 0x8b, nil
+}
+
+// openLines returns a line scanner over r from its current position
+func openLines(r io.Reader, compressed bool) (*bufio.Scanner, error) {
+	if compressed {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		r = gz
+	}
+	return newScanner(r), nil
+}
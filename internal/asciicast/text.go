@@ -0,0 +1,140 @@
+package asciicast
+
+import (
+	"io"
+	"strings"
+)
+
+// maxTextLine splits runaway lines, e.g. output without newlines
+const maxTextLine = 4096
+
+// TextLine is a line of output or input text with the terminal control sequences removed.
+// Time is when the line started.
+type TextLine struct {
+	Time float64
+	Code string
+	Text string
+}
+
+// ExtractText reads the remaining events of r and calls fn for each non-blank line of
+// output and input text
+func ExtractText(r *Reader, fn func(TextLine) error) error {
+	streams := map[string]*textStream{
+		EventOutput: {code: EventOutput, fn: fn},
+		EventInput:  {code: EventInput, fn: fn},
+	}
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if s, ok := streams[e.Code]; ok {
+			if err := s.feed(e.Time, e.Data); err != nil {
+				return err
+			}
+		}
+	}
+	for _, code := range []string{EventOutput, EventInput} {
+		if err := streams[code].flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Escape sequence states for stripping
+const (
+	stripGround = iota
+	stripEscape
+	stripCSI
+	stripString // OSC, DCS, APC, PM and SOS run until BEL or ST
+	stripStringEnd
+	stripCharset
+)
+
+// textStream strips one direction of the terminal traffic down to lines of text
+type textStream struct {
+	code  string
+	fn    func(TextLine) error
+	state int
+	line  []rune
+	start float64
+}
+
+func (s *textStream) feed(t float64, data string) error {
+	for _, r := range data {
+		switch s.state {
+		case stripGround:
+			switch {
+			case r == 0x1b:
+				s.state = stripEscape
+			case r == '\n' || (r == '\r' && s.code == EventInput):
+				// Input ends lines with Enter; output's carriage returns are part of CRLF
+				if err := s.flush(); err != nil {
+					return err
+				}
+			case r == '\b' || r == 0x7f:
+				if len(s.line) > 0 {
+					s.line = s.line[:len(s.line)-1]
+				}
+			case r == '\t':
+				s.add(t, ' ')
+			case r < 0x20 || (r >= 0x80 && r < 0xa0):
+			default:
+				s.add(t, r)
+				if len(s.line) >= maxTextLine {
+					if err := s.flush(); err != nil {
+						return err
+					}
+				}
+			}
+		case stripEscape:
+			switch r {
+			case '[':
+				s.state = stripCSI
+			case ']', 'P', '_', '^', 'X':
+				s.state = stripString
+			case '(', ')', '*', '+', '#', '%':
+				s.state = stripCharset
+			default:
+				s.state = stripGround
+			}
+		case stripCSI:
+			if r >= 0x40 && r <= 0x7e {
+				s.state = stripGround
+			}
+		case stripString:
+			switch r {
+			case 0x07:
+				s.state = stripGround
+			case 0x1b:
+				s.state = stripStringEnd
+			}
+		case stripStringEnd:
+			// ESC \ terminates the string; anything else aborts it
+			s.state = stripGround
+		case stripCharset:
+			s.state = stripGround
+		}
+	}
+	return nil
+}
+
+func (s *textStream) add(t float64, r rune) {
+	if len(s.line) == 0 {
+		s.start = t
+	}
+	s.line = append(s.line, r)
+}
+
+func (s *textStream) flush() error {
+	text := strings.TrimSpace(string(s.line))
+	s.line = s.line[:0]
+	if text == "" {
+		return nil
+	}
+	return s.fn(TextLine{Time: s.start, Code: s.code, Text: text})
+}
@@ -0,0 +1,178 @@
+package asciicast
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Check results
+const (
+	StatusValid    = "valid"
+	StatusUpgraded = "upgraded"
+	StatusInvalid  = "invalid"
+)
+
+// Report describes the check of one recording file
+type Report struct {
+	Path    string `json:"path"`
+	Status  string `json:"status"`
+	Events  int    `json:"events"`
+	Dropped int    `json:"dropped"` // Lines that could not be decoded
+	Error   string `json:"error,omitempty"`
+}
+
+// Validate checks that r is an asciicast v2 recording: a valid header followed by
+// decodable events. It returns the header and the number of events.
+func Validate(r io.Reader) (Header, int, error) {
+	scanner := newScanner(r)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return Header{}, 0, err
+		}
+		return Header{}, 0, fmt.Errorf("recording is empty")
+	}
+	h, err := ParseHeader(scanner.Bytes())
+	if err != nil {
+		return h, 0, err
+	}
+
+	events := 0
+	for n := 2; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if _, err := ParseEvent(line); err != nil {
+			return h, events, fmt.Errorf("line %d: %w", n, err)
+		}
+		events++
+	}
+	return h, events, scanner.Err()
+}
+
+// Upgrade validates the recording at path and rewrites it if needed: recordings without a
+// header get h as their header, and lines that cannot be decoded (typically the last line
+// of a recording cut short by a crash) are dropped. The file is replaced atomically.
+func Upgrade(path string, h Header) Report {
+	report := Report{Path: path}
+
+	f, err := os.Open(path)
+	if err != nil {
+		report.Status = StatusInvalid
+		report.Error = err.Error()
+		return report
+	}
+	if _, events, err := Validate(f); err == nil {
+		f.Close()
+		report.Status = StatusValid
+		report.Events = events
+		return report
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		report.Status = StatusInvalid
+		report.Error = err.Error()
+		return report
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upgrade-*.cast")
+	if err != nil {
+		f.Close()
+		report.Status = StatusInvalid
+		report.Error = err.Error()
+		return report
+	}
+	err = rewrite(f, tmp, h, &report)
+	f.Close()
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		report.Status = StatusInvalid
+		report.Error = err.Error()
+		return report
+	}
+	report.Status = StatusUpgraded
+	return report
+}
+
+// rewrite copies the events of src to dst behind a header, keeping src's header if it has a valid one
+func rewrite(src io.Reader, dst io.Writer, h Header, report *Report) error {
+	scanner := newScanner(src)
+	w := bufio.NewWriter(dst)
+
+	wroteHeader := false
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if !wroteHeader {
+			wroteHeader = true
+			existing, err := ParseHeader(line)
+			switch {
+			case err == nil:
+				h = existing
+			case err != ErrNoHeader:
+				// An unreadable header is replaced
+				report.Dropped++
+			}
+			h.Version = Version
+			header, marshalErr := json.Marshal(h)
+			if marshalErr != nil {
+				return marshalErr
+			}
+			w.Write(append(header, '\n'))
+			if err != ErrNoHeader {
+				continue
+			}
+		}
+		e, err := ParseEvent(line)
+		if err != nil {
+			report.Dropped++
+			continue
+		}
+		encoded, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		w.Write(append(encoded, '\n'))
+		report.Events++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if !wroteHeader {
+		return fmt.Errorf("recording is empty")
+	}
+	return w.Flush()
+}
+
+// UpgradeDir checks every .cast file in dir. header returns the header to give a
+// recording that has none.
+func UpgradeDir(dir string, header func(path string) Header) ([]Report, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.cast"))
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]Report, 0, len(paths))
+	for _, path := range paths {
+		reports = append(reports, Upgrade(path, header(path)))
+	}
+	return reports, nil
+}
+
+func newScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), MaxLineSize)
+	return scanner
+}
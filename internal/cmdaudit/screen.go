@@ -0,0 +1,210 @@
+package cmdaudit
+
+import (
+	"strconv"
+	"strings"
+)
+
+// lineBuffer models the line the cursor is on, enough to follow a line editor redrawing it
+type lineBuffer struct {
+	cells  []rune
+	cursor int
+}
+
+func (l *lineBuffer) reset() {
+	l.cells = l.cells[:0]
+	l.cursor = 0
+}
+
+func (l *lineBuffer) put(r rune) {
+	if l.cursor < len(l.cells) {
+		l.cells[l.cursor] = r
+	} else {
+		for len(l.cells) < l.cursor {
+			l.cells = append(l.cells, ' ')
+		}
+		if len(l.cells) >= maxCommandLength*2 {
+			return
+		}
+		l.cells = append(l.cells, r)
+	}
+	l.cursor++
+}
+
+func (l *lineBuffer) move(n int) {
+	l.cursor += n
+	if l.cursor < 0 {
+		l.cursor = 0
+	}
+	if l.cursor > maxCommandLength*2 {
+		l.cursor = maxCommandLength * 2
+	}
+}
+
+func (l *lineBuffer) eraseToEnd() {
+	if l.cursor < len(l.cells) {
+		l.cells = l.cells[:l.cursor]
+	}
+}
+
+func (l *lineBuffer) eraseToCursor() {
+	for i := 0; i < l.cursor && i < len(l.cells); i++ {
+		l.cells[i] = ' '
+	}
+}
+
+func (l *lineBuffer) deleteChars(n int) {
+	if l.cursor >= len(l.cells) {
+		return
+	}
+	end := l.cursor + n
+	if end > len(l.cells) {
+		end = len(l.cells)
+	}
+	l.cells = append(l.cells[:l.cursor], l.cells[end:]...)
+}
+
+func (l *lineBuffer) insertBlanks(n int) {
+	if l.cursor >= len(l.cells) {
+		return
+	}
+	blanks := []rune(strings.Repeat(" ", n))
+	l.cells = append(l.cells[:l.cursor], append(blanks, l.cells[l.cursor:]...)...)
+}
+
+// from returns the line from column col on
+func (l *lineBuffer) from(col int) string {
+	if col >= len(l.cells) {
+		return ""
+	}
+	return strings.TrimRight(string(l.cells[col:]), " ")
+}
+
+func (l *lineBuffer) String() string {
+	return strings.TrimRight(string(l.cells), " ")
+}
+
+// Escape parser states
+const (
+	escGround = iota
+	escEscape
+	escCharset
+	escCSI
+	escOSC
+	escOSCEnd
+)
+
+// maxSequenceLength bounds CSI parameters and OSC payloads
+const maxSequenceLength = 4096
+
+// escParser splits terminal output into text and control sequences
+type escParser struct {
+	state  int
+	params strings.Builder
+}
+
+func (p *escParser) feed(r rune, t *Tracker) {
+	switch p.state {
+	case escGround:
+		switch {
+		case r == 0x1b:
+			p.state = escEscape
+		case r == '\n':
+			t.newline()
+		case r == '\r':
+			t.line.cursor = 0
+		case r == '\b':
+			t.line.move(-1)
+		case r == '\t':
+			t.line.put(' ')
+		case r < 0x20 || r == 0x7f:
+		default:
+			t.line.put(r)
+		}
+
+	case escEscape:
+		p.params.Reset()
+		switch r {
+		case '[':
+			p.state = escCSI
+		case ']':
+			p.state = escOSC
+		case '(', ')', '*', '+':
+			p.state = escCharset
+		default:
+			p.state = escGround
+		}
+
+	case escCharset:
+		p.state = escGround
+
+	case escCSI:
+		if r >= 0x40 && r <= 0x7e {
+			p.state = escGround
+			p.csi(r, p.params.String(), t)
+			return
+		}
+		if p.params.Len() < maxSequenceLength {
+			p.params.WriteRune(r)
+		}
+
+	case escOSC:
+		switch r {
+		case 0x07:
+			p.state = escGround
+			t.osc(p.params.String())
+		case 0x1b:
+			p.state = escOSCEnd
+		default:
+			if p.params.Len() < maxSequenceLength {
+				p.params.WriteRune(r)
+			}
+		}
+
+	case escOSCEnd:
+		// ESC \ terminates the OSC; anything else aborts it
+		p.state = escGround
+		if r == '\\' {
+			t.osc(p.params.String())
+		}
+	}
+}
+
+// csi applies the control sequences that change the current line
+func (p *escParser) csi(final rune, params string, t *Tracker) {
+	n := 1
+	if v, err := strconv.Atoi(params); err == nil && v > 0 {
+		n = v
+	}
+
+	switch final {
+	case 'K':
+		switch params {
+		case "", "0":
+			t.line.eraseToEnd()
+		case "1":
+			t.line.eraseToCursor()
+		case "2":
+			t.line.reset()
+		}
+	case 'C':
+		t.line.move(n)
+	case 'D':
+		t.line.move(-n)
+	case 'G':
+		t.line.cursor = 0
+		t.line.move(n - 1)
+	case 'P':
+		t.line.deleteChars(n)
+	case '@':
+		t.line.insertBlanks(n)
+	case 'J':
+		if params == "2" || params == "3" {
+			t.line.reset()
+		}
+	case 'h':
+		t.privateMode(params, true)
+	case 'l':
+		t.privateMode(params, false)
+	}
+}
@@ -0,0 +1,269 @@
+// Package cmdaudit extracts the commands typed in a terminal session.
+// Shells that emit OSC 133 shell-integration marks give exact command boundaries and
+// exit codes; for other shells the command is read from the echoed line when Enter is
+// pressed, with the prompt stripped off.
+package cmdaudit
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Command sources
+const (
+	SourceShellIntegration = "osc133" // Boundaries and exit code from OSC 133 marks
+	SourcePrompt           = "prompt" // Echoed line at Enter, prompt removed heuristically
+)
+
+// maxCommandLength caps what is kept of a single command
+const maxCommandLength = 4096
+
+// Command is one command line run in the shell
+type Command struct {
+	Command    string
+	WorkingDir string
+	ExitCode   *int
+	Source     string
+	StartedAt  time.Time
+	FinishedAt *time.Time
+}
+
+// Tracker follows the input and output of one session. Feed it all terminal
+// output with Output and all user input with Input; fn is called for every command.
+type Tracker struct {
+	mu sync.Mutex
+	fn func(Command)
+
+	line       lineBuffer
+	esc        escParser
+	carry      []byte // Incomplete UTF-8 sequence at the end of the last output
+	prompt     string // Line contents when the shell last waited for input
+	typed      bool   // Input since the last command
+	altScreen  bool   // A full-screen program is running
+	integrated bool   // The shell emits OSC 133
+	cwd        string // From OSC 7, or guessed from the prompt
+	cmdStart   int    // Column where the command begins, from OSC 133 B
+	inCommand  bool   // Between OSC 133 B and C: the user is entering a command
+	cmdLines   []string
+
+	pending *Command // Started (OSC 133 C), waiting for its exit code (D)
+}
+
+// NewTracker returns a tracker reporting commands to fn
+func NewTracker(fn func(Command)) *Tracker {
+	return &Tracker{fn: fn}
+}
+
+// Output feeds terminal output
+func (t *Tracker) Output(data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Keep a character split across reads for the next call instead of decoding its
+	// halves as replacement characters
+	buf := append(t.carry, data...)
+	cut := len(buf)
+	for i := len(buf) - 1; i >= 0 && i >= len(buf)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(buf[i]) {
+			continue
+		}
+		if !utf8.FullRune(buf[i:]) {
+			cut = i
+		}
+		break
+	}
+	t.carry = append(t.carry[:0:0], buf[cut:]...)
+	for _, r := range string(buf[:cut]) {
+		t.esc.feed(r, t)
+	}
+	// Output that ends without the user having typed is most likely the prompt
+	if !t.typed && !t.altScreen {
+		t.prompt = t.line.String()
+	}
+}
+
+// Input feeds user keystrokes
+func (t *Tracker) Input(data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, b := range data {
+		switch b {
+		case '\r', '\n':
+			if !t.integrated && !t.altScreen && t.typed {
+				t.promptCommand()
+			}
+			t.typed = false
+		case 0x03, 0x15: // Ctrl-C, Ctrl-U abandon the line
+			t.typed = false
+		default:
+			t.typed = true
+		}
+	}
+}
+
+// Current returns the command line as the shell has echoed it so far
+func (t *Tracker) Current() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.integrated {
+		if !t.inCommand {
+			return ""
+		}
+		return strings.TrimSpace(strings.Join(append(append([]string(nil), t.cmdLines...), t.line.from(t.cmdStart)), "\n"))
+	}
+	text := t.line.String()
+	if t.prompt != "" && strings.HasPrefix(text, t.prompt) {
+		return strings.TrimSpace(text[len(t.prompt):])
+	}
+	return strings.TrimSpace(stripPrompt(text))
+}
+
+// Close reports a command still waiting for its exit code
+func (t *Tracker) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.flushPending()
+}
+
+// promptCommand records the echoed line when Enter is pressed without shell integration
+func (t *Tracker) promptCommand() {
+	text := t.line.String()
+	if t.prompt != "" && strings.HasPrefix(text, t.prompt) {
+		text = text[len(t.prompt):]
+	} else {
+		text = stripPrompt(text)
+	}
+	if cwd := promptDir(t.prompt); cwd != "" {
+		t.cwd = cwd
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+	t.emit(&Command{
+		Command:    text,
+		WorkingDir: t.cwd,
+		Source:     SourcePrompt,
+		StartedAt:  time.Now(),
+	})
+}
+
+// osc handles an operating system command from the output
+func (t *Tracker) osc(payload string) {
+	switch {
+	case strings.HasPrefix(payload, "133;"):
+		t.integrated = true
+		t.shellMark(payload[4:])
+	case strings.HasPrefix(payload, "7;"):
+		// OSC 7: file://host/path
+		if u, err := url.Parse(payload[2:]); err == nil && u.Path != "" {
+			t.cwd = u.Path
+		}
+	}
+}
+
+// shellMark handles OSC 133: A prompt start, B command start, C executed, D finished
+func (t *Tracker) shellMark(mark string) {
+	kind, args, _ := strings.Cut(mark, ";")
+	switch kind {
+	case "A":
+		t.flushPending()
+	case "B":
+		t.cmdStart = t.line.cursor
+		t.inCommand = true
+		t.cmdLines = t.cmdLines[:0]
+	case "C":
+		t.flushPending()
+		if t.inCommand {
+			t.cmdLines = append(t.cmdLines, t.line.from(t.cmdStart))
+			t.inCommand = false
+		}
+		text := strings.TrimSpace(strings.Join(t.cmdLines, "\n"))
+		t.cmdLines = t.cmdLines[:0]
+		if text != "" {
+			t.pending = &Command{
+				Command:    text,
+				WorkingDir: t.cwd,
+				Source:     SourceShellIntegration,
+				StartedAt:  time.Now(),
+			}
+		}
+	case "D":
+		if t.pending == nil {
+			return
+		}
+		if code, err := strconv.Atoi(strings.SplitN(args, ";", 2)[0]); err == nil {
+			t.pending.ExitCode = &code
+		}
+		now := time.Now()
+		t.pending.FinishedAt = &now
+		t.emit(t.pending)
+		t.pending = nil
+	}
+}
+
+func (t *Tracker) flushPending() {
+	if t.pending != nil {
+		t.emit(t.pending)
+		t.pending = nil
+	}
+}
+
+func (t *Tracker) emit(cmd *Command) {
+	if len(cmd.Command) > maxCommandLength {
+		// Cut before the rune that crosses the limit, not through it
+		n := maxCommandLength
+		for n > 0 && !utf8.RuneStart(cmd.Command[n]) {
+			n--
+		}
+		cmd.Command = cmd.Command[:n]
+	}
+	t.fn(*cmd)
+}
+
+// newline is called when the output moves to a new line
+func (t *Tracker) newline() {
+	// The shell echoes the Enter before it marks the command as executed
+	if t.inCommand {
+		t.cmdLines = append(t.cmdLines, t.line.from(t.cmdStart))
+		t.cmdStart = 0
+	}
+	t.line.reset()
+}
+
+// privateMode tracks the alternate screen used by full-screen programs
+func (t *Tracker) privateMode(params string, set bool) {
+	switch params {
+	case "?1049", "?47", "?1047":
+		t.altScreen = set
+	}
+}
+
+var (
+	// promptEnd matches a typical prompt: anything ending in $, #, % or > and a space
+	promptEnd = regexp.MustCompile(`^.*?[$#%>]\s`)
+	// promptPath finds the directory in prompts like "user@host:~/src$ "
+	promptPath = regexp.MustCompile(`[:\s\[]((?:~|/)[^\s$#%>\]]*)\]?\s*[$#%>]\s*$`)
+)
+
+func stripPrompt(line string) string {
+	if loc := promptEnd.FindStringIndex(line); loc != nil {
+		return line[loc[1]:]
+	}
+	return line
+}
+
+func promptDir(prompt string) string {
+	if m := promptPath.FindStringSubmatch(prompt); m != nil {
+		return m[1]
+	}
+	return ""
+}
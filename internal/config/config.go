@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/spf13/viper"
 )
@@ -11,16 +13,37 @@ import (
 var Version = "dev"
 
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	Database DatabaseConfig `mapstructure:"database"`
-	Security SecurityConfig `mapstructure:"security"`
-	SSH      SSHConfig      `mapstructure:"ssh"`
-	Log      LogConfig      `mapstructure:"log"`
+	Server    ServerConfig    `mapstructure:"server"`
+	Database  DatabaseConfig  `mapstructure:"database"`
+	Security  SecurityConfig  `mapstructure:"security"`
+	SSH       SSHConfig       `mapstructure:"ssh"`
+	Recording RecordingConfig `mapstructure:"recording"`
+	Storage   StorageConfig   `mapstructure:"storage"`
+	Log       LogConfig       `mapstructure:"log"`
+
+	recordingMu sync.RWMutex // Guards Recording, which settings change while retention runs
+}
+
+// RecordingRules returns the recording retention rules
+func (c *Config) RecordingRules() RecordingConfig {
+	c.recordingMu.RLock()
+	defer c.recordingMu.RUnlock()
+	return c.Recording
+}
+
+// SetRecordingRules replaces the recording retention rules
+func (c *Config) SetRecordingRules(rules RecordingConfig) {
+	c.recordingMu.Lock()
+	defer c.recordingMu.Unlock()
+	c.Recording = rules
 }
 
 type ServerConfig struct {
 	Port int    `mapstructure:"port"`
 	Mode string `mapstructure:"mode"` // debug or release
+	// Origin the web proxy is served from, e.g. https://proxy.example.com. It must differ from
+	// the UI's origin, so proxied pages cannot read its tokens; empty disables the web proxy.
+	WebProxyOrigin string `mapstructure:"web_proxy_origin"`
 }
 
 type DatabaseConfig struct {
@@ -33,12 +56,42 @@ type SecurityConfig struct {
 	LoginRateLimit    int    `mapstructure:"login_rate_limit"`
 	AccessExpiration  string `mapstructure:"access_expiration"`
 	RefreshExpiration string `mapstructure:"refresh_expiration"`
+	RecordingKeyFile  string `mapstructure:"recording_key_file"` // Ed25519 key recordings are signed with, created if missing
 }
 
 type SSHConfig struct {
 	Timeout               string `mapstructure:"timeout"`
 	IdleTimeout           string `mapstructure:"idle_timeout"`
+	DetachGracePeriod     string `mapstructure:"detach_grace_period"` // How long a detached session is kept, 0 disables
 	MaxConnectionsPerUser int    `mapstructure:"max_connections_per_user"`
+	// Global outbound proxy, used by hosts that do not override it
+	ProxyType              string `mapstructure:"proxy_type"` // none, socks5, http
+	ProxyHost              string `mapstructure:"proxy_host"`
+	ProxyPort              int    `mapstructure:"proxy_port"`
+	ProxyUsername          string `mapstructure:"proxy_username"`
+	ProxyPasswordEncrypted string `mapstructure:"proxy_password"` // AES encrypted with the encryption key
+}
+
+// RecordingConfig holds the retention rules for terminal recordings; 0 disables a rule.
+// Recordings under legal hold are never removed.
+type RecordingConfig struct {
+	MaxAgeDays     int `mapstructure:"max_age_days"`      // Remove recordings older than this
+	MaxTotalSizeMB int `mapstructure:"max_total_size_mb"` // Remove the oldest recordings above this total
+	UserQuotaMB    int `mapstructure:"user_quota_mb"`     // Remove a user's oldest recordings above this
+}
+
+// StorageConfig selects where recordings and backups are kept: a local directory or an
+// S3-compatible object store such as AWS S3 or MinIO
+type StorageConfig struct {
+	Type      string `mapstructure:"type"`       // local or s3
+	Path      string `mapstructure:"path"`       // Local root; with s3, recordings are spooled here until uploaded
+	Endpoint  string `mapstructure:"endpoint"`   // e.g. https://s3.us-east-1.amazonaws.com or http://localhost:9000
+	Region    string `mapstructure:"region"`     // Signing region
+	Bucket    string `mapstructure:"bucket"`     // Must exist
+	Prefix    string `mapstructure:"prefix"`     // Prepended to every key, e.g. termiscope/
+	AccessKey string `mapstructure:"access_key"` // Also TERMISCOPE_STORAGE_ACCESS_KEY
+	SecretKey string `mapstructure:"secret_key"` // Also TERMISCOPE_STORAGE_SECRET_KEY
+	PathStyle bool   `mapstructure:"path_style"` // Bucket in the path instead of the host name, as MinIO needs
 }
 
 type LogConfig struct {
@@ -47,6 +100,18 @@ type LogConfig struct {
 }
 
 // LoadConfig loads configuration from file and environment variables
+// envBindings maps settings to the environment variables that override them
+var envBindings = map[string]string{
+	"server.port":                 "TERMISCOPE_PORT",
+	"server.web_proxy_origin":     "TERMISCOPE_WEB_PROXY_ORIGIN",
+	"database.path":               "TERMISCOPE_DB_PATH",
+	"security.jwt_secret":         "TERMISCOPE_JWT_SECRET",
+	"security.encryption_key":     "TERMISCOPE_ENCRYPTION_KEY",
+	"security.recording_key_file": "TERMISCOPE_RECORDING_KEY_FILE",
+	"storage.access_key":          "TERMISCOPE_STORAGE_ACCESS_KEY",
+	"storage.secret_key":          "TERMISCOPE_STORAGE_SECRET_KEY",
+}
+
 func LoadConfig() (*Config, error) {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
@@ -59,10 +124,19 @@ func LoadConfig() (*Config, error) {
 	viper.SetDefault("database.path", "./data/termiscope.db")
 	viper.SetDefault("ssh.timeout", "30s")
 	viper.SetDefault("ssh.idle_timeout", "30m")
+	viper.SetDefault("ssh.detach_grace_period", "15m")
 	viper.SetDefault("ssh.max_connections_per_user", 10)
+	viper.SetDefault("ssh.proxy_type", "none")
+	viper.SetDefault("recording.max_age_days", 0)
+	viper.SetDefault("recording.max_total_size_mb", 0)
+	viper.SetDefault("recording.user_quota_mb", 0)
+	viper.SetDefault("storage.type", "local")
+	viper.SetDefault("storage.path", "./data")
+	viper.SetDefault("storage.region", "us-east-1")
 	viper.SetDefault("security.login_rate_limit", 20)
 	viper.SetDefault("security.access_expiration", "60m")
 	viper.SetDefault("security.refresh_expiration", "168h") // 7 days
+	viper.SetDefault("security.recording_key_file", "./data/recording_signing.key")
 	viper.SetDefault("log.level", "info")
 	viper.SetDefault("log.file", "./logs/app.log")
 
@@ -71,10 +145,9 @@ func LoadConfig() (*Config, error) {
 	viper.AutomaticEnv()
 
 	// Bind specific environment variables
-	viper.BindEnv("server.port", "TERMISCOPE_PORT")
-	viper.BindEnv("database.path", "TERMISCOPE_DB_PATH")
-	viper.BindEnv("security.jwt_secret", "TERMISCOPE_JWT_SECRET")
-	viper.BindEnv("security.encryption_key", "TERMISCOPE_ENCRYPTION_KEY")
+	for key, env := range envBindings {
+		viper.BindEnv(key, env)
+	}
 
 	// Read config file (optional, will use defaults if not found)
 	if err := viper.ReadInConfig(); err != nil {
@@ -112,26 +185,78 @@ func LoadConfig() (*Config, error) {
 	return &config, nil
 }
 
-// SaveConfig writes the current configuration back to the config file
+// SaveConfig writes the current configuration back to the config file. Settings taken from
+// the environment, such as secrets, are not written: the file keeps what it had for them.
 func (c *Config) SaveConfig() error {
 	viper.Set("server.port", c.Server.Port)
 	viper.Set("server.mode", c.Server.Mode)
+	viper.Set("server.web_proxy_origin", c.Server.WebProxyOrigin)
 	viper.Set("database.path", c.Database.Path)
 	viper.Set("security.jwt_secret", c.Security.JWTSecret)
 	viper.Set("security.encryption_key", c.Security.EncryptionKey)
 	viper.Set("security.login_rate_limit", c.Security.LoginRateLimit)
 	viper.Set("security.access_expiration", c.Security.AccessExpiration)
 	viper.Set("security.refresh_expiration", c.Security.RefreshExpiration)
+	viper.Set("security.recording_key_file", c.Security.RecordingKeyFile)
 	viper.Set("ssh.timeout", c.SSH.Timeout)
 	viper.Set("ssh.idle_timeout", c.SSH.IdleTimeout)
+	viper.Set("ssh.detach_grace_period", c.SSH.DetachGracePeriod)
 	viper.Set("ssh.max_connections_per_user", c.SSH.MaxConnectionsPerUser)
+	viper.Set("ssh.proxy_type", c.SSH.ProxyType)
+	viper.Set("ssh.proxy_host", c.SSH.ProxyHost)
+	viper.Set("ssh.proxy_port", c.SSH.ProxyPort)
+	viper.Set("ssh.proxy_username", c.SSH.ProxyUsername)
+	viper.Set("ssh.proxy_password", c.SSH.ProxyPasswordEncrypted)
+	rules := c.RecordingRules()
+	viper.Set("recording.max_age_days", rules.MaxAgeDays)
+	viper.Set("recording.max_total_size_mb", rules.MaxTotalSizeMB)
+	viper.Set("recording.user_quota_mb", rules.UserQuotaMB)
+	viper.Set("storage.type", c.Storage.Type)
+	viper.Set("storage.path", c.Storage.Path)
+	viper.Set("storage.endpoint", c.Storage.Endpoint)
+	viper.Set("storage.region", c.Storage.Region)
+	viper.Set("storage.bucket", c.Storage.Bucket)
+	viper.Set("storage.prefix", c.Storage.Prefix)
+	viper.Set("storage.access_key", c.Storage.AccessKey)
+	viper.Set("storage.secret_key", c.Storage.SecretKey)
+	viper.Set("storage.path_style", c.Storage.PathStyle)
 	viper.Set("log.level", c.Log.Level)
 	viper.Set("log.file", c.Log.File)
 
 	// Ensure we have a config file path set
-	if viper.ConfigFileUsed() == "" {
-		viper.SetConfigFile("./configs/config.yaml")
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		path = "./configs/config.yaml"
+	}
+
+	// The file as it is now, for the settings the environment overrides
+	file := viper.New()
+	file.SetConfigFile(path)
+	if err := file.ReadInConfig(); err != nil {
+		file = viper.New()
+	}
+
+	settings := viper.AllSettings()
+	for key, env := range envBindings {
+		if os.Getenv(env) == "" {
+			continue
+		}
+		section, name, _ := strings.Cut(key, ".")
+		values, ok := settings[section].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if file.InConfig(key) {
+			values[name] = file.Get(key)
+		} else {
+			delete(values, name)
+		}
 	}
 
-	return viper.WriteConfig()
+	out := viper.New()
+	out.SetConfigFile(path)
+	if err := out.MergeConfigMap(settings); err != nil {
+		return err
+	}
+	return out.WriteConfig()
 }
@@ -12,7 +12,16 @@ import (
 var defaultSettings = map[string]string{
 	"ssh.timeout":                  "30s",
 	"ssh.idle_timeout":             "30m",
+	"ssh.detach_grace_period":      "15m",
 	"ssh.max_connections_per_user": "10",
+	"ssh.proxy_type":               "none",
+	"ssh.proxy_host":               "",
+	"ssh.proxy_port":               "0",
+	"ssh.proxy_username":           "",
+	"ssh.proxy_password":           "",
+	"recording.max_age_days":       "0",
+	"recording.max_total_size_mb":  "0",
+	"recording.user_quota_mb":      "0",
 	"security.login_rate_limit":    "20",
 	"security.access_expiration":   "60m",
 	"security.refresh_expiration":  "168h",
@@ -60,8 +69,26 @@ func updateConfigValue(cfg *Config, key, value string) error {
 		cfg.SSH.Timeout = value
 	case "ssh.idle_timeout":
 		cfg.SSH.IdleTimeout = value
+	case "ssh.detach_grace_period":
+		cfg.SSH.DetachGracePeriod = value
 	case "ssh.max_connections_per_user":
 		cfg.SSH.MaxConnectionsPerUser, err = strconv.Atoi(value)
+	case "ssh.proxy_type":
+		cfg.SSH.ProxyType = value
+	case "ssh.proxy_host":
+		cfg.SSH.ProxyHost = value
+	case "ssh.proxy_port":
+		cfg.SSH.ProxyPort, err = strconv.Atoi(value)
+	case "ssh.proxy_username":
+		cfg.SSH.ProxyUsername = value
+	case "ssh.proxy_password":
+		cfg.SSH.ProxyPasswordEncrypted = value
+	case "recording.max_age_days":
+		cfg.Recording.MaxAgeDays, err = strconv.Atoi(value)
+	case "recording.max_total_size_mb":
+		cfg.Recording.MaxTotalSizeMB, err = strconv.Atoi(value)
+	case "recording.user_quota_mb":
+		cfg.Recording.UserQuotaMB, err = strconv.Atoi(value)
 	case "security.login_rate_limit":
 		cfg.Security.LoginRateLimit, err = strconv.Atoi(value)
 	case "security.access_expiration":
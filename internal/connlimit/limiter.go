@@ -0,0 +1,143 @@
+package connlimit
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Connection kinds
+const (
+	KindTerminal = "terminal"
+	KindSFTP     = "sftp"
+)
+
+// Limit scopes
+const (
+	ScopeUser = "user"
+	ScopeHost = "host"
+)
+
+// LimitError is returned when a new connection would exceed a limit
+type LimitError struct {
+	Scope   string `json:"scope"` // user or host
+	Limit   int    `json:"limit"`
+	Current int    `json:"current"`
+}
+
+func (e *LimitError) Error() string {
+	if e.Scope == ScopeHost {
+		return fmt.Sprintf("connection limit reached for this host (%d of %d in use)", e.Current, e.Limit)
+	}
+	return fmt.Sprintf("connection limit reached for your account (%d of %d in use)", e.Current, e.Limit)
+}
+
+// Counts are the concurrent connections of a user or host
+type Counts struct {
+	Terminal int `json:"terminal"`
+	SFTP     int `json:"sftp"`
+}
+
+// Total returns the number of connections of all kinds
+func (c Counts) Total() int {
+	return c.Terminal + c.SFTP
+}
+
+func (c *Counts) add(kind string, delta int) {
+	switch kind {
+	case KindTerminal:
+		c.Terminal += delta
+	case KindSFTP:
+		c.SFTP += delta
+	}
+}
+
+// Limiter counts concurrent connections per user and per host
+type Limiter struct {
+	mu    sync.Mutex
+	users map[uint]*Counts
+	hosts map[uint]*Counts
+}
+
+// Default is the process-wide limiter shared by terminal and SFTP connections
+var Default = New()
+
+func New() *Limiter {
+	return &Limiter{
+		users: make(map[uint]*Counts),
+		hosts: make(map[uint]*Counts),
+	}
+}
+
+// Acquire reserves a connection slot. A limit <= 0 is unlimited.
+// The returned release function gives the slot back and is safe to call more than once.
+func (l *Limiter) Acquire(kind string, userID uint, userLimit int, hostID uint, hostLimit int) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	user := l.users[userID]
+	if user == nil {
+		user = &Counts{}
+		l.users[userID] = user
+	}
+	host := l.hosts[hostID]
+	if host == nil {
+		host = &Counts{}
+		l.hosts[hostID] = host
+	}
+
+	if userLimit > 0 && user.Total() >= userLimit {
+		return nil, &LimitError{Scope: ScopeUser, Limit: userLimit, Current: user.Total()}
+	}
+	if hostLimit > 0 && host.Total() >= hostLimit {
+		return nil, &LimitError{Scope: ScopeHost, Limit: hostLimit, Current: host.Total()}
+	}
+
+	user.add(kind, 1)
+	host.add(kind, 1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			user.add(kind, -1)
+			host.add(kind, -1)
+		})
+	}, nil
+}
+
+// UserCounts returns the current connections of a user
+func (l *Limiter) UserCounts(userID uint) Counts {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if c := l.users[userID]; c != nil {
+		return *c
+	}
+	return Counts{}
+}
+
+// HostCounts returns the current connections to a host
+func (l *Limiter) HostCounts(hostID uint) Counts {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if c := l.hosts[hostID]; c != nil {
+		return *c
+	}
+	return Counts{}
+}
+
+// AllUsers returns the connection counts of every user that has connections
+func (l *Limiter) AllUsers() map[uint]Counts {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := make(map[uint]Counts)
+	for id, c := range l.users {
+		if c.Total() > 0 {
+			result[id] = *c
+		}
+	}
+	return result
+}
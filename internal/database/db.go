@@ -71,7 +71,7 @@ func GetDB() *gorm.DB {
 func CleanupStaleLogs(db *gorm.DB) error {
 	now := time.Now()
 	return db.Model(&models.ConnectionLog{}).
-		Where("status IN ?", []string{"connecting", "success"}).
+		Where("status IN ?", []string{"connecting", "success", "detached"}).
 		Updates(map[string]interface{}{
 			"status":          "disconnected",
 			"disconnected_at": &now,
@@ -17,11 +17,19 @@ func RunMigrations(db *gorm.DB) error {
 		&models.User{},
 		&models.SSHHost{},
 		&models.ConnectionLog{},
+		&models.ConnectionLogEvent{},
 		&models.SystemConfig{},
 		&models.CommandTemplate{},
 		&models.TerminalRecording{},
+		&models.RecordingText{},
+		&models.RecordingSigningKey{},
 		&models.MonitorRecord{},
 		&models.MonitorStatusLog{},
+		&models.AuditLog{},
+		&models.Tunnel{},
+		&models.CommandAudit{},
+		&models.CommandPolicy{},
+		&models.RecordingPolicy{},
 	)
 	if err != nil {
 		return fmt.Errorf("failed to run migrations: %w", err)
@@ -31,6 +39,10 @@ func RunMigrations(db *gorm.DB) error {
 	db.Exec("CREATE INDEX IF NOT EXISTS idx_connection_logs_user_id ON connection_logs(user_id)")
 	db.Exec("CREATE INDEX IF NOT EXISTS idx_connection_logs_created_at ON connection_logs(created_at)")
 
+	// Recording file paths became storage keys, relative to the data directory they were written to
+	db.Exec(`UPDATE terminal_recordings SET file_path = REPLACE(SUBSTR(file_path, 6), '\', '/')
+		WHERE file_path LIKE 'data/recordings/%' OR file_path LIKE 'data\recordings\%'`)
+
 	// Create default admin user if no users exist
 	var count int64
 	db.Model(&models.User{}).Count(&count)
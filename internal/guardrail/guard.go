@@ -0,0 +1,300 @@
+// Package guardrail holds back dangerous command lines before the Enter key reaches the shell.
+package guardrail
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ihxw/termiscope/internal/models"
+)
+
+// Policy actions, from least to most severe
+const (
+	ActionNotify  = "notify"  // Let the command through and notify
+	ActionConfirm = "confirm" // Ask the user before the command runs
+	ActionBlock   = "block"   // Never let the command run
+)
+
+// Match types
+const (
+	MatchPrefix = "prefix"
+	MatchRegex  = "regex"
+)
+
+// ConfirmTimeout declines a confirmation nobody answered
+const ConfirmTimeout = 2 * time.Minute
+
+// cancelLine makes the shell drop the line typed so far (Ctrl-C)
+var cancelLine = []byte{0x03}
+
+var severity = map[string]int{ActionNotify: 1, ActionConfirm: 2, ActionBlock: 3}
+
+// Rule is a compiled policy
+type Rule struct {
+	Policy models.CommandPolicy
+	re     *regexp.Regexp
+}
+
+// Compile prepares a policy for matching
+func Compile(p models.CommandPolicy) (*Rule, error) {
+	r := &Rule{Policy: p}
+	if p.MatchType == MatchRegex {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		r.re = re
+	}
+	return r, nil
+}
+
+// Match reports whether the rule matches a command line
+func (r *Rule) Match(command string) bool {
+	if r.re != nil {
+		return r.re.MatchString(command)
+	}
+	return strings.HasPrefix(command, r.Policy.Pattern)
+}
+
+// Match is a command line that matched one or more rules
+type Match struct {
+	ID       string                 `json:"id"`
+	Command  string                 `json:"command"`
+	Action   string                 `json:"action"` // The most severe action of the matched rules
+	Policies []models.CommandPolicy `json:"policies"`
+}
+
+// Outcomes reported for a match
+const (
+	OutcomeBlocked   = "blocked"
+	OutcomeNotified  = "notified"
+	OutcomePending   = "pending" // Waiting for the user to confirm
+	OutcomeConfirmed = "confirmed"
+	OutcomeDeclined  = "declined"
+	OutcomeTimedOut  = "timed_out"
+)
+
+// Guard filters one session's input. It follows the line being typed and, when Enter
+// is pressed, checks it against the rules.
+type Guard struct {
+	mu    sync.Mutex
+	rules []*Rule
+	echo  func() string // The line as echoed by the shell, covers history recall and completion
+
+	line   []byte // Typed since the last Enter
+	edited bool   // Cursor keys or completion were used, the typed line is unreliable
+	esc    int    // Bytes of an escape sequence still to skip: 1 = after ESC, 2 = in CSI
+
+	pending *pendingConfirm
+
+	// OnMatch is called for every match and again when a confirmation is answered,
+	// never with the guard locked
+	OnMatch func(m Match, outcome string)
+	// OnTimeout is called when a confirmation expires; the session should call Resolve
+	OnTimeout func(id string)
+}
+
+type pendingConfirm struct {
+	match Match
+	held  []byte // The Enter and everything typed after it
+	timer *time.Timer
+}
+
+// New returns a guard for rules. echo returns the current command line as echoed by the shell.
+func New(rules []*Rule, echo func() string) *Guard {
+	return &Guard{rules: rules, echo: echo}
+}
+
+// report is a match to pass to OnMatch once the guard is unlocked
+type report struct {
+	match   Match
+	outcome string
+}
+
+// Filter returns the part of data that may be written to the shell now
+func (g *Guard) Filter(data []byte) []byte {
+	g.mu.Lock()
+	out, reports := g.filter(data)
+	g.mu.Unlock()
+
+	if g.OnMatch != nil {
+		for _, r := range reports {
+			g.OnMatch(r.match, r.outcome)
+		}
+	}
+	return out
+}
+
+// filter does the work of Filter and returns the matches to report. Called with g.mu held.
+func (g *Guard) filter(data []byte) (out []byte, reports []report) {
+	if g.pending != nil {
+		// Nothing gets through while the user is asked to confirm
+		g.pending.held = append(g.pending.held, data...)
+		return nil, nil
+	}
+
+	out = make([]byte, 0, len(data))
+	for i, b := range data {
+		if b != '\r' && b != '\n' {
+			g.track(b)
+			out = append(out, b)
+			continue
+		}
+
+		match, ok := g.check()
+		g.line = g.line[:0]
+		g.edited = false
+		if !ok {
+			out = append(out, b)
+			continue
+		}
+
+		switch match.Action {
+		case ActionBlock:
+			reports = append(reports, report{match, OutcomeBlocked})
+			out = append(out, cancelLine...)
+		case ActionConfirm:
+			reports = append(reports, report{match, OutcomePending})
+			g.pending = &pendingConfirm{
+				match: match,
+				held:  append([]byte(nil), data[i:]...),
+			}
+			id := match.ID
+			g.pending.timer = time.AfterFunc(ConfirmTimeout, func() {
+				if g.OnTimeout != nil {
+					g.OnTimeout(id)
+				}
+			})
+			return out, reports
+		default:
+			reports = append(reports, report{match, OutcomeNotified})
+			out = append(out, b)
+		}
+	}
+	return out, reports
+}
+
+// Pending returns the match waiting for confirmation, if any
+func (g *Guard) Pending() (Match, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.pending == nil {
+		return Match{}, false
+	}
+	return g.pending.match, true
+}
+
+// Resolve answers a confirmation and returns what to write to the shell:
+// the held input if approved, otherwise a line cancel
+func (g *Guard) Resolve(id string, approve bool, outcome string) []byte {
+	g.mu.Lock()
+	p := g.pending
+	if p == nil || p.match.ID != id {
+		g.mu.Unlock()
+		return nil
+	}
+	p.timer.Stop()
+	g.pending = nil
+	g.mu.Unlock()
+
+	if outcome == "" {
+		outcome = OutcomeDeclined
+		if approve {
+			outcome = OutcomeConfirmed
+		}
+	}
+	if g.OnMatch != nil {
+		g.OnMatch(p.match, outcome)
+	}
+
+	if !approve {
+		return cancelLine
+	}
+	// Input typed while waiting is checked as usual
+	held := p.held
+	return append(held[:1:1], g.Filter(held[1:])...)
+}
+
+// track follows the typed line
+func (g *Guard) track(b byte) {
+	switch {
+	case g.esc == 1:
+		g.esc = 0
+		if b == '[' || b == 'O' {
+			g.esc = 2
+		}
+	case g.esc == 2:
+		if b >= 0x40 && b <= 0x7e {
+			g.esc = 0
+		}
+	case b == 0x1b:
+		g.esc = 1
+		g.edited = true
+	case b == 0x7f || b == 0x08:
+		if len(g.line) > 0 {
+			g.line = g.line[:len(g.line)-1]
+		}
+	case b == 0x03 || b == 0x15:
+		g.line = g.line[:0]
+		g.edited = false
+	case b == '\t':
+		g.edited = true
+	case b < 0x20:
+	default:
+		g.line = append(g.line, b)
+	}
+}
+
+// check evaluates the line being entered against the rules
+func (g *Guard) check() (Match, bool) {
+	var candidates []string
+	if typed := strings.TrimSpace(string(g.line)); typed != "" && !g.edited {
+		candidates = append(candidates, typed)
+	}
+	if g.echo != nil {
+		if echoed := g.echo(); echoed != "" {
+			candidates = append(candidates, echoed)
+		}
+	}
+
+	var match Match
+	for _, command := range candidates {
+		for _, r := range g.rules {
+			if !r.Match(command) || containsPolicy(match.Policies, r.Policy.ID) {
+				continue
+			}
+			if match.Command == "" {
+				match.Command = command
+			}
+			match.Policies = append(match.Policies, r.Policy)
+			if severity[r.Policy.Action] > severity[match.Action] {
+				match.Action = r.Policy.Action
+			}
+		}
+	}
+	if len(match.Policies) == 0 {
+		return Match{}, false
+	}
+	match.ID = newID()
+	return match, true
+}
+
+func containsPolicy(list []models.CommandPolicy, id uint) bool {
+	for _, p := range list {
+		if p.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ihxw/termiscope/internal/config"
+	"github.com/ihxw/termiscope/internal/middleware"
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/ssh"
+	"github.com/ihxw/termiscope/internal/utils"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"gorm.io/gorm"
+)
+
+// AgentHandler manages the per-user in-memory key agent used for agent forwarding
+type AgentHandler struct {
+	db     *gorm.DB
+	config *config.Config
+}
+
+func NewAgentHandler(db *gorm.DB, cfg *config.Config) *AgentHandler {
+	return &AgentHandler{
+		db:     db,
+		config: cfg,
+	}
+}
+
+type AgentKeyInfo struct {
+	Fingerprint string `json:"fingerprint"`
+	Type        string `json:"type"`
+	Comment     string `json:"comment"`
+}
+
+type AddAgentKeyRequest struct {
+	HostID       uint   `json:"host_id"`     // Load the key saved on this host
+	PrivateKey   string `json:"private_key"` // Or load a key directly
+	Passphrase   string `json:"passphrase"`
+	Certificate  string `json:"certificate"`
+	Comment      string `json:"comment"`
+	LifetimeSecs uint32 `json:"lifetime_secs"` // 0 keeps the key until logout
+}
+
+// ListKeys returns the keys currently loaded in the user's agent
+func (h *AgentHandler) ListKeys(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	keys, err := ssh.Agents.Get(userID).List()
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to list agent keys")
+		return
+	}
+
+	result := make([]AgentKeyInfo, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, AgentKeyInfo{
+			Fingerprint: gossh.FingerprintSHA256(k),
+			Type:        k.Type(),
+			Comment:     k.Comment,
+		})
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, result)
+}
+
+// AddKey loads a private key into the user's agent, either from a saved host or from the request
+func (h *AgentHandler) AddKey(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req AddAgentKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	privateKey, passphrase, certificate, comment := req.PrivateKey, req.Passphrase, req.Certificate, req.Comment
+	if req.HostID != 0 {
+		var host models.SSHHost
+		if err := h.db.Where("id = ? AND user_id = ?", req.HostID, userID).First(&host).Error; err != nil {
+			utils.ErrorResponse(c, http.StatusNotFound, "host not found")
+			return
+		}
+		if host.PrivateKeyEncrypted == "" {
+			utils.ErrorResponse(c, http.StatusBadRequest, "host has no private key")
+			return
+		}
+		sshCfg, err := hostSSHConfig(h.config, &host, 0)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		privateKey, passphrase, certificate = sshCfg.PrivateKey, sshCfg.Passphrase, sshCfg.Certificate
+		if comment == "" {
+			comment = host.Username + "@" + host.Name
+		}
+	}
+	if privateKey == "" {
+		utils.ErrorResponse(c, http.StatusBadRequest, "host_id or private_key is required")
+		return
+	}
+
+	rawKey, err := ssh.ParseRawKey(privateKey, passphrase)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	addedKey := agent.AddedKey{
+		PrivateKey:   rawKey,
+		Comment:      comment,
+		LifetimeSecs: req.LifetimeSecs,
+	}
+	if certificate != "" {
+		cert, err := ssh.ParseUserCertificate(certificate)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		addedKey.Certificate = cert
+	}
+
+	if err := ssh.Agents.Get(userID).Add(addedKey); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "failed to add key: "+err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusCreated, gin.H{"message": "key added to agent"})
+}
+
+// RemoveKey removes a single key (by SHA256 fingerprint) from the user's agent
+func (h *AgentHandler) RemoveKey(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	fingerprint := c.Query("fingerprint")
+
+	keyring := ssh.Agents.Get(userID)
+	keys, err := keyring.List()
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to list agent keys")
+		return
+	}
+
+	for _, k := range keys {
+		if gossh.FingerprintSHA256(k) == fingerprint {
+			if err := keyring.Remove(k); err != nil {
+				utils.ErrorResponse(c, http.StatusInternalServerError, "failed to remove key")
+				return
+			}
+			utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "key removed from agent"})
+			return
+		}
+	}
+
+	utils.ErrorResponse(c, http.StatusNotFound, "key not found")
+}
+
+// ClearKeys removes every key from the user's agent
+func (h *AgentHandler) ClearKeys(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	ssh.Agents.Clear(userID)
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "agent cleared"})
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ihxw/termiscope/internal/middleware"
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/utils"
+	"gorm.io/gorm"
+)
+
+type AuditLogHandler struct {
+	db *gorm.DB
+}
+
+func NewAuditLogHandler(db *gorm.DB) *AuditLogHandler {
+	return &AuditLogHandler{db: db}
+}
+
+// recordAudit writes an audit entry; failures are logged but never block the caller
+func recordAudit(db *gorm.DB, entry *models.AuditLog) {
+	if err := db.Create(entry).Error; err != nil {
+		utils.LogError("Failed to write audit log (%s): %v", entry.Action, err)
+	}
+}
+
+// List returns audit logs
+func (h *AuditLogHandler) List(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	role := middleware.GetRole(c)
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	startDate := c.Query("start_date")
+	endDate := c.Query("end_date")
+	hostID := c.Query("host_id")
+	action := c.Query("action")
+	queryUserID := c.Query("user_id")
+
+	query := h.db.Model(&models.AuditLog{}).Preload("User").Preload("SSHHost")
+
+	// Non-admin users can only see their own logs
+	if role != "admin" {
+		query = query.Where("user_id = ?", userID)
+	} else if queryUserID != "" {
+		query = query.Where("user_id = ?", queryUserID)
+	}
+
+	// Date range filter
+	if startDate != "" {
+		if t, err := time.Parse("2006-01-02", startDate); err == nil {
+			query = query.Where("created_at >= ?", t)
+		}
+	}
+	if endDate != "" {
+		if t, err := time.Parse("2006-01-02", endDate); err == nil {
+			query = query.Where("created_at <= ?", t.Add(24*time.Hour))
+		}
+	}
+
+	if hostID != "" {
+		query = query.Where("ssh_host_id = ?", hostID)
+	}
+	if action != "" {
+		query = query.Where("action = ?", action)
+	}
+
+	// Count total
+	var total int64
+	query.Count(&total)
+
+	// Paginate
+	var logs []models.AuditLog
+	offset := (page - 1) * pageSize
+	if err := query.Order("created_at DESC").Offset(offset).Limit(pageSize).Find(&logs).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to fetch audit logs")
+		return
+	}
+
+	utils.PaginatedResponse(c, http.StatusOK, logs, total, page, pageSize)
+}
@@ -4,12 +4,14 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ihxw/termiscope/internal/config"
 	"github.com/ihxw/termiscope/internal/middleware"
 	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/ssh"
 	"github.com/ihxw/termiscope/internal/utils"
 	"github.com/pquerna/otp/totp"
 	"golang.org/x/crypto/bcrypt"
@@ -184,7 +186,13 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 
 // Logout handles user logout
 func (h *AuthHandler) Logout(c *gin.Context) {
-	// In a stateless JWT system, logout is handled client-side
+	// In a stateless JWT system, logout is handled client-side.
+	// Server-side state tied to the user (the forwarding key agent) is dropped here.
+	if parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2); len(parts) == 2 && parts[0] == "Bearer" {
+		if claims, err := utils.ValidateToken(parts[1], h.config.Security.JWTSecret); err == nil {
+			ssh.Agents.Clear(claims.UserID)
+		}
+	}
 	utils.SuccessResponse(c, http.StatusOK, gin.H{
 		"message": "logged out successfully",
 	})
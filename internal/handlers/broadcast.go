@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ihxw/termiscope/internal/middleware"
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/terminal"
+	"github.com/ihxw/termiscope/internal/utils"
+	"gorm.io/gorm"
+)
+
+// BroadcastHandler manages broadcast groups: sets of the user's sessions that receive the same input
+type BroadcastHandler struct {
+	db *gorm.DB
+}
+
+func NewBroadcastHandler(db *gorm.DB) *BroadcastHandler {
+	return &BroadcastHandler{db: db}
+}
+
+type CreateBroadcastGroupRequest struct {
+	Name       string   `json:"name" binding:"max=100"`
+	SessionIDs []string `json:"session_ids" binding:"required,min=1"`
+}
+
+type AddBroadcastMemberRequest struct {
+	SessionID string `json:"session_id" binding:"required"`
+}
+
+type UpdateBroadcastMemberRequest struct {
+	Excluded bool `json:"excluded"`
+}
+
+type BroadcastInputRequest struct {
+	Data string `json:"data" binding:"required"`
+}
+
+// ownedGroup looks up one of the current user's groups
+func (h *BroadcastHandler) ownedGroup(c *gin.Context) (terminal.BroadcastGroup, bool) {
+	group, ok := terminal.Groups.Get(c.Param("id"))
+	if !ok || group.UserID != middleware.GetUserID(c) {
+		utils.ErrorResponse(c, http.StatusNotFound, "broadcast group not found")
+		return terminal.BroadcastGroup{}, false
+	}
+	return group, true
+}
+
+// ownedSessions resolves session IDs to the current user's running sessions
+func ownedSessions(c *gin.Context, ids []string) ([]*terminal.Session, bool) {
+	userID := middleware.GetUserID(c)
+	sessions := make([]*terminal.Session, 0, len(ids))
+	for _, id := range ids {
+		sess, ok := terminal.Sessions.Get(id)
+		if !ok || sess.UserID != userID {
+			utils.ErrorResponse(c, http.StatusNotFound, "session not found: "+id)
+			return nil, false
+		}
+		sessions = append(sessions, sess)
+	}
+	return sessions, true
+}
+
+// List returns the user's broadcast groups
+func (h *BroadcastHandler) List(c *gin.Context) {
+	utils.SuccessResponse(c, http.StatusOK, terminal.Groups.ListByUser(middleware.GetUserID(c)))
+}
+
+// Create makes a broadcast group from some of the user's sessions
+func (h *BroadcastHandler) Create(c *gin.Context) {
+	var req CreateBroadcastGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	sessions, ok := ownedSessions(c, req.SessionIDs)
+	if !ok {
+		return
+	}
+
+	group := terminal.Groups.Create(middleware.GetUserID(c), req.Name, sessions)
+	utils.SuccessResponse(c, http.StatusCreated, group)
+}
+
+// Delete removes a broadcast group; its sessions keep running
+func (h *BroadcastHandler) Delete(c *gin.Context) {
+	group, ok := h.ownedGroup(c)
+	if !ok {
+		return
+	}
+
+	terminal.Groups.Delete(group.ID)
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "broadcast group deleted successfully"})
+}
+
+// AddMember joins another session to a group
+func (h *BroadcastHandler) AddMember(c *gin.Context) {
+	group, ok := h.ownedGroup(c)
+	if !ok {
+		return
+	}
+
+	var req AddBroadcastMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	sessions, ok := ownedSessions(c, []string{req.SessionID})
+	if !ok {
+		return
+	}
+
+	updated, err := terminal.Groups.AddMember(group.ID, sessions[0])
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+	utils.SuccessResponse(c, http.StatusOK, updated)
+}
+
+// RemoveMember drops a session from a group
+func (h *BroadcastHandler) RemoveMember(c *gin.Context) {
+	group, ok := h.ownedGroup(c)
+	if !ok {
+		return
+	}
+
+	updated, ok := terminal.Groups.RemoveMember(group.ID, c.Param("sessionId"))
+	if !ok {
+		utils.ErrorResponse(c, http.StatusNotFound, "member not found")
+		return
+	}
+	utils.SuccessResponse(c, http.StatusOK, updated)
+}
+
+// UpdateMember excludes a member from broadcasts for now, or includes it again
+func (h *BroadcastHandler) UpdateMember(c *gin.Context) {
+	group, ok := h.ownedGroup(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateBroadcastMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	updated, ok := terminal.Groups.SetExcluded(group.ID, c.Param("sessionId"), req.Excluded)
+	if !ok {
+		utils.ErrorResponse(c, http.StatusNotFound, "member not found")
+		return
+	}
+	utils.SuccessResponse(c, http.StatusOK, updated)
+}
+
+// Input sends the same input to every member of a group. The whole broadcast is one audit entry.
+func (h *BroadcastHandler) Input(c *gin.Context) {
+	group, ok := h.ownedGroup(c)
+	if !ok {
+		return
+	}
+
+	var req BroadcastInputRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	result, err := terminal.Groups.Send(group.ID, []byte(req.Data))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	hosts := make([]string, 0, len(result.Sent))
+	for _, m := range result.Sent {
+		hosts = append(hosts, fmt.Sprintf("%s(%s)", m.HostName, m.SessionID))
+	}
+	recordAudit(h.db, &models.AuditLog{
+		UserID:   group.UserID,
+		Action:   "session_broadcast",
+		Detail:   fmt.Sprintf("group=%s bytes=%d sent=%d skipped=%d failed=%d sessions=%s", group.ID, len(req.Data), len(result.Sent), len(result.Skipped), len(result.Failed), strings.Join(hosts, ",")),
+		ClientIP: c.ClientIP(),
+	})
+
+	utils.SuccessResponse(c, http.StatusOK, result)
+}
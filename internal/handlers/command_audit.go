@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/utils"
+	"gorm.io/gorm"
+)
+
+// CommandAuditHandler serves the command audit trail to administrators
+type CommandAuditHandler struct {
+	db *gorm.DB
+}
+
+func NewCommandAuditHandler(db *gorm.DB) *CommandAuditHandler {
+	return &CommandAuditHandler{db: db}
+}
+
+// List searches the commands run in terminal sessions
+func (h *CommandAuditHandler) List(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	startDate := c.Query("start_date")
+	endDate := c.Query("end_date")
+	hostID := c.Query("host_id")
+	userID := c.Query("user_id")
+	connLogID := c.Query("connection_log_id")
+	sessionID := c.Query("session_id")
+	text := c.Query("q")
+	exitCode := c.Query("exit_code")
+
+	query := h.db.Model(&models.CommandAudit{}).Preload("User").Preload("SSHHost")
+
+	if userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if hostID != "" {
+		query = query.Where("ssh_host_id = ?", hostID)
+	}
+	if connLogID != "" {
+		query = query.Where("connection_log_id = ?", connLogID)
+	}
+	if sessionID != "" {
+		query = query.Where("session_id = ?", sessionID)
+	}
+
+	// Date range filter
+	if startDate != "" {
+		if t, err := time.Parse("2006-01-02", startDate); err == nil {
+			query = query.Where("started_at >= ?", t)
+		}
+	}
+	if endDate != "" {
+		if t, err := time.Parse("2006-01-02", endDate); err == nil {
+			query = query.Where("started_at <= ?", t.Add(24*time.Hour))
+		}
+	}
+
+	// Text search on the command line
+	if text != "" {
+		query = query.Where("command LIKE ?", "%"+text+"%")
+	}
+	if exitCode != "" {
+		if code, err := strconv.Atoi(exitCode); err == nil {
+			query = query.Where("exit_code = ?", code)
+		}
+	}
+
+	// Count total
+	var total int64
+	query.Count(&total)
+
+	// Paginate
+	var commands []models.CommandAudit
+	offset := (page - 1) * pageSize
+	if err := query.Order("started_at DESC").Offset(offset).Limit(pageSize).Find(&commands).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to fetch commands")
+		return
+	}
+
+	utils.PaginatedResponse(c, http.StatusOK, commands, total, page, pageSize)
+}
+
+// commandAuditWriter inserts the commands of one session in the order they ran. Inserts
+// run on their own goroutine, so the database does not hold up the terminal.
+type commandAuditWriter struct {
+	db *gorm.DB
+
+	mu      sync.Mutex
+	queue   []*models.CommandAudit
+	running bool
+}
+
+// add queues a command for insertion
+func (w *commandAuditWriter) add(audit *models.CommandAudit) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.queue = append(w.queue, audit)
+	if !w.running {
+		w.running = true
+		go w.run()
+	}
+}
+
+// run inserts queued commands one at a time until the queue is empty
+func (w *commandAuditWriter) run() {
+	for {
+		w.mu.Lock()
+		if len(w.queue) == 0 {
+			w.running = false
+			w.mu.Unlock()
+			return
+		}
+		audit := w.queue[0]
+		w.queue = w.queue[1:]
+		w.mu.Unlock()
+
+		if err := w.db.Create(audit).Error; err != nil {
+			utils.LogError("Failed to save command of session %s: %v", audit.SessionID, err)
+		}
+	}
+}
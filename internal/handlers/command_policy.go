@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ihxw/termiscope/internal/guardrail"
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/terminal"
+	"github.com/ihxw/termiscope/internal/utils"
+	"gorm.io/gorm"
+)
+
+// CommandPolicyHandler manages dangerous-command guardrails
+type CommandPolicyHandler struct {
+	db *gorm.DB
+}
+
+func NewCommandPolicyHandler(db *gorm.DB) *CommandPolicyHandler {
+	return &CommandPolicyHandler{db: db}
+}
+
+type CommandPolicyRequest struct {
+	Name        string `json:"name" binding:"required,max=100"`
+	Description string `json:"description"`
+	MatchType   string `json:"match_type" binding:"required,oneof=prefix regex"`
+	Pattern     string `json:"pattern" binding:"required,max=500"`
+	Action      string `json:"action" binding:"required,oneof=block confirm notify"`
+	HostGroups  string `json:"host_groups"`
+	HostTags    string `json:"host_tags"`
+	Roles       string `json:"roles"`
+	Enabled     *bool  `json:"enabled"`
+}
+
+// List returns all command policies
+func (h *CommandPolicyHandler) List(c *gin.Context) {
+	var policies []models.CommandPolicy
+	if err := h.db.Order("id ASC").Find(&policies).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to fetch command policies")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, policies)
+}
+
+// Create adds a command policy
+func (h *CommandPolicyHandler) Create(c *gin.Context) {
+	var req CommandPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	policy := models.CommandPolicy{Enabled: true}
+	if !h.apply(c, &policy, &req) {
+		return
+	}
+
+	if err := h.db.Create(&policy).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to create command policy")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusCreated, policy)
+}
+
+// Update changes a command policy. Running sessions keep the policies they started with.
+func (h *CommandPolicyHandler) Update(c *gin.Context) {
+	var policy models.CommandPolicy
+	if err := h.db.First(&policy, c.Param("id")).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "command policy not found")
+		return
+	}
+
+	var req CommandPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	if !h.apply(c, &policy, &req) {
+		return
+	}
+
+	if err := h.db.Save(&policy).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to update command policy")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, policy)
+}
+
+// Delete removes a command policy
+func (h *CommandPolicyHandler) Delete(c *gin.Context) {
+	result := h.db.Delete(&models.CommandPolicy{}, c.Param("id"))
+	if result.Error != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to delete command policy")
+		return
+	}
+	if result.RowsAffected == 0 {
+		utils.ErrorResponse(c, http.StatusNotFound, "command policy not found")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "command policy deleted successfully"})
+}
+
+// apply copies a request onto a policy, rejecting patterns that do not compile
+func (h *CommandPolicyHandler) apply(c *gin.Context, policy *models.CommandPolicy, req *CommandPolicyRequest) bool {
+	policy.Name = req.Name
+	policy.Description = req.Description
+	policy.MatchType = req.MatchType
+	policy.Pattern = req.Pattern
+	policy.Action = req.Action
+	policy.HostGroups = strings.TrimSpace(req.HostGroups)
+	policy.HostTags = strings.TrimSpace(req.HostTags)
+	policy.Roles = strings.TrimSpace(req.Roles)
+	if req.Enabled != nil {
+		policy.Enabled = *req.Enabled
+	}
+
+	if _, err := guardrail.Compile(*policy); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid pattern: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// commandRules compiles the command policies that apply to a session on host by a user
+// with role. An error means the policies could not be read, and the session must not start.
+func commandRules(db *gorm.DB, host models.SSHHost, role string) ([]*guardrail.Rule, error) {
+	var policies []models.CommandPolicy
+	if err := db.Where("enabled = ?", true).Order("id ASC").Find(&policies).Error; err != nil {
+		return nil, err
+	}
+
+	var rules []*guardrail.Rule
+	for _, p := range policies {
+		if !p.Covers(host, role) {
+			continue
+		}
+		rule, err := guardrail.Compile(p)
+		if err != nil {
+			utils.LogError("Skipping command policy %d: %v", p.ID, err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// newCommandGuard builds the guardrails for a session on host from rules, nil if there are none.
+// Matches are audited, notify policies raise a notification, and the browser is asked to
+// confirm or told about a block through the session.
+func newCommandGuard(db *gorm.DB, host models.SSHHost, rules []*guardrail.Rule, connLogID uint, sess *terminal.Session, echo func() string) *guardrail.Guard {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	guard := guardrail.New(rules, echo)
+	guard.OnMatch = func(m guardrail.Match, outcome string) {
+		names := make([]string, 0, len(m.Policies))
+		for _, p := range m.Policies {
+			names = append(names, fmt.Sprintf("%s(#%d)", p.Name, p.ID))
+		}
+		recordAudit(db, &models.AuditLog{
+			UserID:          sess.UserID,
+			SSHHostID:       &host.ID,
+			ConnectionLogID: &connLogID,
+			Action:          "command_policy",
+			Detail:          fmt.Sprintf("outcome=%s action=%s policies=%s command=%q", outcome, m.Action, strings.Join(names, ","), m.Command),
+			ClientIP:        sess.ClientIP,
+		})
+
+		switch outcome {
+		case guardrail.OutcomeBlocked:
+			sess.Notify("guard_blocked", m)
+		case guardrail.OutcomePending:
+			sess.Notify("guard_confirm", m)
+		case guardrail.OutcomeConfirmed, guardrail.OutcomeDeclined, guardrail.OutcomeTimedOut:
+			// Answer to an earlier match, notifications already went out
+			return
+		}
+
+		for _, p := range m.Policies {
+			if p.Action == guardrail.ActionNotify {
+				go utils.SendNotification(db, host, fmt.Sprintf("Command Policy: %s", p.Name),
+					fmt.Sprintf("%s ran on %s (%s): %s", sess.Username, host.Name, outcome, m.Command))
+			}
+		}
+	}
+	guard.OnTimeout = func(id string) {
+		sess.ResolveInput(id, false, guardrail.OutcomeTimedOut)
+	}
+	return guard
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ihxw/termiscope/internal/config"
+	"github.com/ihxw/termiscope/internal/connlimit"
+	"github.com/ihxw/termiscope/internal/middleware"
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/utils"
+	"gorm.io/gorm"
+)
+
+// ConnectionCountHandler exposes concurrent connection counts and limits
+type ConnectionCountHandler struct {
+	db     *gorm.DB
+	config *config.Config
+}
+
+func NewConnectionCountHandler(db *gorm.DB, cfg *config.Config) *ConnectionCountHandler {
+	return &ConnectionCountHandler{
+		db:     db,
+		config: cfg,
+	}
+}
+
+// ConnectionUsage is the number of open connections against a limit (<= 0 is unlimited)
+type ConnectionUsage struct {
+	connlimit.Counts
+	Total int `json:"total"`
+	Limit int `json:"limit"`
+}
+
+type HostConnectionUsage struct {
+	ConnectionUsage
+	HostID uint   `json:"host_id"`
+	Name   string `json:"name"`
+}
+
+type UserConnectionUsage struct {
+	ConnectionUsage
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+}
+
+func newUsage(counts connlimit.Counts, limit int) ConnectionUsage {
+	return ConnectionUsage{Counts: counts, Total: counts.Total(), Limit: limit}
+}
+
+// Mine returns the current user's connection counts, overall and per host
+func (h *ConnectionCountHandler) Mine(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "user not found")
+		return
+	}
+
+	var hosts []models.SSHHost
+	h.db.Where("user_id = ?", userID).Order("sort_order asc, id asc").Find(&hosts)
+
+	hostUsage := make([]HostConnectionUsage, 0, len(hosts))
+	for _, host := range hosts {
+		hostUsage = append(hostUsage, HostConnectionUsage{
+			ConnectionUsage: newUsage(connlimit.Default.HostCounts(host.ID), host.MaxConnections),
+			HostID:          host.ID,
+			Name:            host.Name,
+		})
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{
+		"user":  newUsage(connlimit.Default.UserCounts(userID), effectiveUserLimit(h.config, &user)),
+		"hosts": hostUsage,
+	})
+}
+
+// All returns the connection counts of every user with open connections (admin only)
+func (h *ConnectionCountHandler) All(c *gin.Context) {
+	counts := connlimit.Default.AllUsers()
+
+	ids := make([]uint, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+
+	var users []models.User
+	if len(ids) > 0 {
+		h.db.Where("id IN ?", ids).Order("id asc").Find(&users)
+	}
+
+	result := make([]UserConnectionUsage, 0, len(users))
+	for i := range users {
+		result = append(result, UserConnectionUsage{
+			ConnectionUsage: newUsage(counts[users[i].ID], effectiveUserLimit(h.config, &users[i])),
+			UserID:          users[i].ID,
+			Username:        users[i].Username,
+		})
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, result)
+}
@@ -31,8 +31,11 @@ func (h *ConnectionLogHandler) List(c *gin.Context) {
 	endDate := c.Query("end_date")
 	hostID := c.Query("host_id")
 	queryUserID := c.Query("user_id")
+	exitCode := c.Query("exit_code")
+	exitSignal := c.Query("exit_signal")
+	reason := c.Query("reason")
 
-	query := h.db.Model(&models.ConnectionLog{}).Preload("User").Preload("SSHHost")
+	query := h.db.Model(&models.ConnectionLog{}).Preload("User").Preload("SSHHost").Preload("Events")
 
 	// Non-admin users can only see their own logs
 	if role != "admin" {
@@ -59,6 +62,19 @@ func (h *ConnectionLogHandler) List(c *gin.Context) {
 		query = query.Where("ssh_host_id = ?", hostID)
 	}
 
+	// How the session ended
+	if exitCode != "" {
+		if code, err := strconv.Atoi(exitCode); err == nil {
+			query = query.Where("exit_code = ?", code)
+		}
+	}
+	if exitSignal != "" {
+		query = query.Where("exit_signal = ?", exitSignal)
+	}
+	if reason != "" {
+		query = query.Where("reason LIKE ?", "%"+reason+"%")
+	}
+
 	// Count total
 	var total int64
 	query.Count(&total)
@@ -15,10 +15,10 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/ihxw/termiscope/internal/config"
+	"github.com/ihxw/termiscope/internal/middleware"
 	"github.com/ihxw/termiscope/internal/models"
 	"github.com/ihxw/termiscope/internal/monitor"
 	"github.com/ihxw/termiscope/internal/utils"
-	"golang.org/x/crypto/ssh"
 	"gorm.io/gorm"
 )
 
@@ -419,35 +419,17 @@ func (h *MonitorHandler) Deploy(c *gin.Context) {
 	}
 	serverURL := fmt.Sprintf("%s://%s", scheme, c.Request.Host)
 
-	// Connect SSH
+	// Connect SSH (through the jump host chain, if any)
 	password, _ := utils.DecryptAES(host.PasswordEncrypted, h.Config.Security.EncryptionKey)
-	privateKey, _ := utils.DecryptAES(host.PrivateKeyEncrypted, h.Config.Security.EncryptionKey)
 
-	authMethods := []ssh.AuthMethod{}
-	if host.AuthType == "key" && privateKey != "" {
-		signer, err := ssh.ParsePrivateKey([]byte(privateKey))
-		if err == nil {
-			authMethods = append(authMethods, ssh.PublicKeys(signer))
-		}
-	}
-	if password != "" {
-		authMethods = append(authMethods, ssh.Password(password))
-	}
-
-	sshConfig := &ssh.ClientConfig{
-		User:            host.Username,
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // TODO: Use TOFU
-		Timeout:         10 * time.Second,
-	}
-
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", host.Host, host.Port), sshConfig)
+	sshClient, err := connectHost(h.DB, h.Config, middleware.GetUserID(c), &host)
 	if err != nil {
 		log.Printf("Monitor Deploy: SSH Dial failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("SSH Connection failed: %v", err)})
 		return
 	}
-	defer client.Close()
+	defer sshClient.Close()
+	client := sshClient.GetRawClient()
 
 	// 1. Detect Architecture
 	session, _ := client.NewSession()
@@ -634,36 +616,18 @@ func (h *MonitorHandler) Stop(c *gin.Context) {
 	// Update DB immediately
 	h.DB.Model(&host).Update("monitor_enabled", false)
 
-	// Connect SSH to stop service
+	// Connect SSH to stop service (through the jump host chain, if any)
 	password, _ := utils.DecryptAES(host.PasswordEncrypted, h.Config.Security.EncryptionKey)
-	privateKey, _ := utils.DecryptAES(host.PrivateKeyEncrypted, h.Config.Security.EncryptionKey)
-
-	authMethods := []ssh.AuthMethod{}
-	if host.AuthType == "key" && privateKey != "" {
-		signer, err := ssh.ParsePrivateKey([]byte(privateKey))
-		if err == nil {
-			authMethods = append(authMethods, ssh.PublicKeys(signer))
-		}
-	}
-	if password != "" {
-		authMethods = append(authMethods, ssh.Password(password))
-	}
-
-	sshConfig := &ssh.ClientConfig{
-		User:            host.Username,
-		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         10 * time.Second,
-	}
 
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", host.Host, host.Port), sshConfig)
+	sshClient, err := connectHost(h.DB, h.Config, middleware.GetUserID(c), &host)
 	if err != nil {
 		// Just log error, basic cleanup manually if needed
 		log.Printf("Monitor Stop: SSH Dial failed: %v", err)
 		c.JSON(http.StatusOK, gin.H{"message": "Monitoring disabled (Agent stop failed: SSH connection error)"})
 		return
 	}
-	defer client.Close()
+	defer sshClient.Close()
+	client := sshClient.GetRawClient()
 
 	session, _ := client.NewSession()
 	defer session.Close()
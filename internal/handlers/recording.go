@@ -1,67 +1,361 @@
 package handlers
 
 import (
-	"bufio"
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ihxw/termiscope/internal/asciicast"
+	"github.com/ihxw/termiscope/internal/config"
 	"github.com/ihxw/termiscope/internal/middleware"
 	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/recsign"
+	"github.com/ihxw/termiscope/internal/storage"
 	"github.com/ihxw/termiscope/internal/utils"
 	"gorm.io/gorm"
 )
 
 type RecordingHandler struct {
-	db *gorm.DB
+	db       *gorm.DB
+	config   *config.Config
+	store    storage.Storage
+	keys     *recsign.Keyring
+	verified sync.Map // Recording ID to verifiedFile
 }
 
-func NewRecordingHandler(db *gorm.DB) *RecordingHandler {
-	return &RecordingHandler{db: db}
+func NewRecordingHandler(db *gorm.DB, cfg *config.Config, store storage.Storage, keys *recsign.Keyring) *RecordingHandler {
+	return &RecordingHandler{db: db, config: cfg, store: store, keys: keys}
 }
 
-// List returns a list of terminal recordings for the current user
+// recordingFilters applies the common recording filters to query. Users only see their
+// own recordings; administrators see everyone's and may filter by user_id.
+func recordingFilters(c *gin.Context, query *gorm.DB) *gorm.DB {
+	if middleware.GetRole(c) != "admin" {
+		query = query.Where("terminal_recordings.user_id = ?", middleware.GetUserID(c))
+	} else if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("terminal_recordings.user_id = ?", userID)
+	}
+	if hostID := c.Query("host_id"); hostID != "" {
+		query = query.Where("terminal_recordings.ssh_host_id = ?", hostID)
+	}
+	if hold := c.Query("legal_hold"); hold != "" {
+		query = query.Where("terminal_recordings.legal_hold = ?", hold == "true")
+	}
+
+	// Date range filter
+	if startDate := c.Query("start_date"); startDate != "" {
+		if t, err := time.Parse("2006-01-02", startDate); err == nil {
+			query = query.Where("terminal_recordings.start_time >= ?", t)
+		}
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		if t, err := time.Parse("2006-01-02", endDate); err == nil {
+			query = query.Where("terminal_recordings.start_time <= ?", t.Add(24*time.Hour))
+		}
+	}
+	return query
+}
+
+// List returns terminal recordings, newest first, filtered by user_id, host_id and date range
 func (h *RecordingHandler) List(c *gin.Context) {
-	userID := middleware.GetUserID(c)
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	query := recordingFilters(c, h.db.Model(&models.TerminalRecording{}))
+
+	// Count total
+	var total int64
+	query.Count(&total)
 
+	// Paginate
 	var recordings []models.TerminalRecording
-	if err := h.db.Where("user_id = ?", userID).Find(&recordings).Error; err != nil {
+	offset := (page - 1) * pageSize
+	if err := query.Order("start_time DESC").Offset(offset).Limit(pageSize).Find(&recordings).Error; err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to fetch recordings")
 		return
 	}
 
-	utils.SuccessResponse(c, http.StatusOK, recordings)
+	utils.PaginatedResponse(c, http.StatusOK, recordings, total, page, pageSize)
+}
+
+// maxSearchMatches caps the matching lines returned per recording
+const maxSearchMatches = 20
+
+// RecordingSearchResult is a recording with the lines that matched a search
+type RecordingSearchResult struct {
+	models.TerminalRecording
+	Hits    int64                  `json:"hits"`    // Matching lines in total
+	Matches []models.RecordingText `json:"matches"` // The first matches, with their time offsets
+}
+
+// Search finds recordings whose output or input contains q (kind=o or kind=i to pick one).
+// Takes the same filters as List. Recordings are searchable once their session has ended.
+func (h *RecordingHandler) Search(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	text := c.Query("q")
+	kind := c.Query("kind")
+	if text == "" {
+		utils.ErrorResponse(c, http.StatusBadRequest, "q is required")
+		return
+	}
+
+	// A chained query cannot be reused, so each use builds its own
+	matching := func() *gorm.DB {
+		query := h.db.Table("recording_texts").
+			Joins("JOIN terminal_recordings ON terminal_recordings.id = recording_texts.recording_id AND terminal_recordings.deleted_at IS NULL").
+			Where(`recording_texts.text LIKE ? ESCAPE '\'`, likeContains(text))
+		if kind != "" {
+			query = query.Where("recording_texts.kind = ?", kind)
+		}
+		return recordingFilters(c, query)
+	}
+
+	// Count total
+	var total int64
+	if err := h.db.Table("(?) AS matched", matching().Select("recording_texts.recording_id").Group("recording_texts.recording_id")).Count(&total).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to search recordings")
+		return
+	}
+
+	// Paginate
+	var hits []struct {
+		RecordingID uint
+		Hits        int64
+	}
+	offset := (page - 1) * pageSize
+	if err := matching().Select("recording_texts.recording_id, COUNT(*) AS hits").
+		Group("recording_texts.recording_id").
+		Order("recording_texts.recording_id DESC").
+		Offset(offset).Limit(pageSize).
+		Scan(&hits).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to search recordings")
+		return
+	}
+
+	results := make([]RecordingSearchResult, 0, len(hits))
+	for _, hit := range hits {
+		var result RecordingSearchResult
+		if err := h.db.First(&result.TerminalRecording, hit.RecordingID).Error; err != nil {
+			continue
+		}
+		result.Hits = hit.Hits
+		matches := h.db.Where(`recording_id = ? AND text LIKE ? ESCAPE '\'`, hit.RecordingID, likeContains(text))
+		if kind != "" {
+			matches = matches.Where("kind = ?", kind)
+		}
+		matches.Order("time_offset ASC").Limit(maxSearchMatches).Find(&result.Matches)
+		results = append(results, result)
+	}
+
+	utils.PaginatedResponse(c, http.StatusOK, results, total, page, pageSize)
+}
+
+// likeContains returns a LIKE pattern, to be used with ESCAPE '\', matching text literally
+// anywhere in a value
+func likeContains(text string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(text) + "%"
 }
 
-// GetStream streams the recording content (JSON lines)
+// GetStream streams the recording content (asciicast JSON lines). The optional from and to
+// query parameters select a time range in seconds, speed scales the event times.
+// The X-Recording-Verification header tells whether the file still matches its signature.
 func (h *RecordingHandler) GetStream(c *gin.Context) {
-	userID := middleware.GetUserID(c)
-	id := c.Param("id")
+	opts, ok := streamOptions(c)
+	if !ok {
+		return
+	}
 
-	var recording models.TerminalRecording
-	if err := h.db.Where("id = ? AND user_id = ?", id, userID).First(&recording).Error; err != nil {
-		utils.ErrorResponse(c, http.StatusNotFound, "recording not found")
+	recording, ok := h.viewableRecording(c)
+	if !ok {
 		return
 	}
 
-	f, err := os.Open(recording.FilePath)
+	f, err := h.store.Open(recording.FilePath)
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to open recording file")
 		return
 	}
 	defer f.Close()
 
+	// Compressed recordings have an index to seek with; without it they are read from the start
+	var index asciicast.Index
+	if idx, err := h.store.Open(asciicast.IndexPath(recording.FilePath)); err == nil {
+		index, _ = asciicast.ReadIndex(idx)
+		idx.Close()
+	}
+
+	status, err := h.verifyRecording(recording)
+	if err != nil {
+		utils.LogError("Recording %d failed verification: %v", recording.ID, err)
+	}
+
 	c.Header("Content-Type", "application/x-asciicast")
+	c.Header("X-Recording-Verification", status)
+	if err := asciicast.Stream(c.Writer, f, index, opts); err != nil {
+		utils.LogError("Failed to stream recording %d: %v", recording.ID, err)
+	}
+}
+
+// Export downloads a recording for reading without the player, limited to the from and
+// to query parameters in seconds. format is one of:
+//   - text: a plain-text transcript, rendered through a virtual terminal
+//   - typescript: a zip of a script(1) typescript and its timing file for scriptreplay(1)
+//   - cast: an asciicast of the selected time range
+func (h *RecordingHandler) Export(c *gin.Context) {
+	opts, ok := streamOptions(c)
+	if !ok {
+		return
+	}
+	opts.Speed = 1
+	format := c.DefaultQuery("format", "text")
+	if format != "text" && format != "typescript" && format != "cast" {
+		utils.ErrorResponse(c, http.StatusBadRequest, "format must be text, typescript or cast")
+		return
+	}
+
+	recording, ok := h.viewableRecording(c)
+	if !ok {
+		return
+	}
+
+	f, err := h.store.Open(recording.FilePath)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to open recording file")
+		return
+	}
+	defer f.Close()
+	var index asciicast.Index
+	if idx, err := h.store.Open(asciicast.IndexPath(recording.FilePath)); err == nil {
+		index, _ = asciicast.ReadIndex(idx)
+		idx.Close()
+	}
+
+	recordAudit(h.db, &models.AuditLog{
+		UserID:    middleware.GetUserID(c),
+		SSHHostID: &recording.SSHHostID,
+		Action:    "recording_export",
+		Detail:    fmt.Sprintf("recording=%d owner=%d format=%s from=%g to=%g", recording.ID, recording.UserID, format, opts.From, opts.To),
+		ClientIP:  c.ClientIP(),
+	})
+
+	name := fmt.Sprintf("recording-%d", recording.ID)
+	switch format {
+	case "text":
+		c.Header("Content-Type", "text/plain; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.txt", name))
+		err = asciicast.WriteTranscript(c.Writer, f, opts)
+	case "typescript":
+		c.Header("Content-Type", "application/zip")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s-typescript.zip", name))
+		err = writeTypescriptZip(c.Writer, name, f, index, opts)
+	case "cast":
+		c.Header("Content-Type", "application/x-asciicast")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.cast", name))
+		err = asciicast.Stream(c.Writer, f, index, opts)
+	}
+	if err != nil {
+		utils.LogError("Failed to export recording %d as %s: %v", recording.ID, format, err)
+	}
+}
+
+// writeTypescriptZip writes a zip with name.typescript and name.timing
+func writeTypescriptZip(dst io.Writer, name string, src io.ReadSeeker, index asciicast.Index, opts asciicast.StreamOptions) error {
+	// The timing file is small, the typescript may not be: only the timing is buffered
+	var timing bytes.Buffer
+	zw := zip.NewWriter(dst)
+	ts, err := zw.Create(name + ".typescript")
+	if err != nil {
+		return err
+	}
+	if err := asciicast.WriteTypescript(ts, &timing, src, index, opts); err != nil {
+		return err
+	}
+	tm, err := zw.Create(name + ".timing")
+	if err != nil {
+		return err
+	}
+	if _, err := timing.WriteTo(tm); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// streamOptions parses the from, to and speed query parameters, or responds with bad request
+func streamOptions(c *gin.Context) (asciicast.StreamOptions, bool) {
+	var opts asciicast.StreamOptions
+	for name, dst := range map[string]*float64{"from": &opts.From, "to": &opts.To, "speed": &opts.Speed} {
+		if v := c.Query(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 {
+				utils.ErrorResponse(c, http.StatusBadRequest, "invalid "+name)
+				return opts, false
+			}
+			*dst = f
+		}
+	}
+	return opts, true
+}
+
+// viewableRecording loads the recording named by the id parameter if the user may view it,
+// or responds with not found. Administrators may view any recording, e.g. one found by Search.
+func (h *RecordingHandler) viewableRecording(c *gin.Context) (models.TerminalRecording, bool) {
+	query := h.db.Where("id = ?", c.Param("id"))
+	if middleware.GetRole(c) != "admin" {
+		query = query.Where("user_id = ?", middleware.GetUserID(c))
+	}
+	var recording models.TerminalRecording
+	if err := query.First(&recording).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "recording not found")
+		return recording, false
+	}
+	return recording, true
+}
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		c.Writer.Write(scanner.Bytes())
-		c.Writer.WriteString("\n")
-		c.Writer.Flush()
+// Verify checks a recording against its signature and returns the signed manifest, which
+// the verify-recording command checks offline together with the file and SigningKey
+func (h *RecordingHandler) Verify(c *gin.Context) {
+	recording, ok := h.viewableRecording(c)
+	if !ok {
+		return
+	}
+
+	status, err := h.verifyRecording(recording)
+	result := gin.H{"status": status}
+	if err != nil {
+		result["error"] = err.Error()
 	}
+	if recording.SignedAt != nil {
+		result["manifest"] = recordingManifest(recording)
+		result["signed_at"] = recording.SignedAt
+	}
+	utils.SuccessResponse(c, http.StatusOK, result)
+}
+
+// SigningKey returns the public key recordings are signed with, and every key whose
+// signatures are trusted
+func (h *RecordingHandler) SigningKey(c *gin.Context) {
+	utils.SuccessResponse(c, http.StatusOK, gin.H{
+		"scheme":       recsign.Scheme,
+		"public_key":   recsign.EncodeKey(h.keys.Public()),
+		"trusted_keys": h.keys.Trusted(),
+	})
 }
 
-// Delete removes a recording
+// Delete removes one of the user's finished recordings. Recordings a policy required are
+// left to administrators and retention.
 func (h *RecordingHandler) Delete(c *gin.Context) {
 	userID := middleware.GetUserID(c)
 	id := c.Param("id")
@@ -71,15 +365,430 @@ func (h *RecordingHandler) Delete(c *gin.Context) {
 		utils.ErrorResponse(c, http.StatusNotFound, "recording not found")
 		return
 	}
+	if recording.LegalHold {
+		utils.ErrorResponse(c, http.StatusConflict, "recording is under legal hold")
+		return
+	}
+	if recording.EndTime == nil {
+		utils.ErrorResponse(c, http.StatusConflict, "recording is still in progress")
+		return
+	}
+	if recording.Required {
+		utils.ErrorResponse(c, http.StatusForbidden, "recording is required by policy, only an administrator can delete it")
+		return
+	}
+
+	if err := removeRecording(h.db, h.store, recording); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to delete recording")
+		return
+	}
 
-	// Delete file
-	os.Remove(recording.FilePath)
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "recording deleted successfully"})
+}
+
+// AdminDelete removes any user's recording, unless it is under legal hold
+func (h *RecordingHandler) AdminDelete(c *gin.Context) {
+	var recording models.TerminalRecording
+	if err := h.db.First(&recording, c.Param("id")).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "recording not found")
+		return
+	}
+	if recording.LegalHold {
+		utils.ErrorResponse(c, http.StatusConflict, "recording is under legal hold, release it first")
+		return
+	}
 
-	// Delete record
-	if err := h.db.Delete(&recording).Error; err != nil {
+	if err := removeRecording(h.db, h.store, recording); err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to delete recording")
 		return
 	}
 
+	recordAudit(h.db, &models.AuditLog{
+		UserID:    middleware.GetUserID(c),
+		SSHHostID: &recording.SSHHostID,
+		Action:    "recording_delete",
+		Detail:    fmt.Sprintf("recording=%d owner=%d key=%s", recording.ID, recording.UserID, recording.FilePath),
+		ClientIP:  c.ClientIP(),
+	})
+
 	utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "recording deleted successfully"})
 }
+
+type LegalHoldRequest struct {
+	Hold bool   `json:"hold"`
+	Note string `json:"note" binding:"max=255"`
+}
+
+// SetLegalHold places a recording under legal hold or releases it
+func (h *RecordingHandler) SetLegalHold(c *gin.Context) {
+	var req LegalHoldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	var recording models.TerminalRecording
+	if err := h.db.First(&recording, c.Param("id")).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "recording not found")
+		return
+	}
+
+	recording.LegalHold = req.Hold
+	recording.HoldNote = req.Note
+	if err := h.db.Model(&recording).Select("legal_hold", "hold_note").Updates(&recording).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to update recording")
+		return
+	}
+
+	action := "recording_hold"
+	if !req.Hold {
+		action = "recording_release"
+	}
+	recordAudit(h.db, &models.AuditLog{
+		UserID:    middleware.GetUserID(c),
+		SSHHostID: &recording.SSHHostID,
+		Action:    action,
+		Detail:    fmt.Sprintf("recording=%d owner=%d note=%q", recording.ID, recording.UserID, req.Note),
+		ClientIP:  c.ClientIP(),
+	})
+
+	utils.SuccessResponse(c, http.StatusOK, recording)
+}
+
+// UserRecordingUsage is one user's share of recording storage
+type UserRecordingUsage struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Count    int64  `json:"count"`
+	Size     int64  `json:"size"`
+}
+
+// Usage reports recording storage per user and the retention limits in effect
+func (h *RecordingHandler) Usage(c *gin.Context) {
+	var users []UserRecordingUsage
+	if err := h.db.Table("terminal_recordings").
+		Select("terminal_recordings.user_id, users.username, COUNT(*) AS count, COALESCE(SUM(terminal_recordings.size), 0) AS size").
+		Joins("LEFT JOIN users ON users.id = terminal_recordings.user_id").
+		Where("terminal_recordings.deleted_at IS NULL").
+		Group("terminal_recordings.user_id, users.username").
+		Order("size DESC").
+		Scan(&users).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to compute recording usage")
+		return
+	}
+
+	var held struct {
+		Count int64
+		Size  int64
+	}
+	h.db.Model(&models.TerminalRecording{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(size), 0) AS size").
+		Where("legal_hold = ?", true).
+		Scan(&held)
+
+	var inProgress int64
+	h.db.Model(&models.TerminalRecording{}).Where("end_time IS NULL").Count(&inProgress)
+
+	var total, count int64
+	for _, u := range users {
+		total += u.Size
+		count += u.Count
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{
+		"total_size":  total,
+		"count":       count,
+		"held_count":  held.Count,
+		"held_size":   held.Size,
+		"users":       users,
+		"retention":   h.config.RecordingRules(),
+		"in_progress": inProgress,
+	})
+}
+
+// removeRecording deletes a recording's objects, search text and record
+func removeRecording(db *gorm.DB, store storage.Storage, recording models.TerminalRecording) error {
+	for _, key := range []string{recording.FilePath, asciicast.IndexPath(recording.FilePath)} {
+		if err := store.Delete(key); err != nil {
+			return err
+		}
+	}
+	if err := db.Where("recording_id = ?", recording.ID).Delete(&models.RecordingText{}).Error; err != nil {
+		return err
+	}
+	return db.Delete(&recording).Error
+}
+
+// recordingSize returns the bytes a recording takes in storage, with its index
+func recordingSize(store storage.Storage, key string) int64 {
+	var size int64
+	for _, k := range []string{key, asciicast.IndexPath(key)} {
+		if info, err := store.Stat(k); err == nil {
+			size += info.Size
+		}
+	}
+	return size
+}
+
+// Results of checking a recording against its signature
+const (
+	VerificationVerified = "verified"
+	VerificationFailed   = "failed"
+	VerificationUnsigned = "unsigned" // Recorded before signing existed, or still in progress
+)
+
+// recordingManifest returns what the signature of a recording covers
+func recordingManifest(recording models.TerminalRecording) recsign.Manifest {
+	return recsign.Manifest{
+		Scheme:      recsign.Scheme,
+		RecordingID: recording.ID,
+		UserID:      recording.UserID,
+		HostID:      recording.SSHHostID,
+		StartTime:   recording.StartTime.Unix(),
+		Events:      recording.EventCount,
+		Digest:      recording.Digest,
+		PublicKey:   recording.SigningKey,
+		Signature:   recording.Signature,
+	}
+}
+
+// signRecording hashes a finished recording and stores the signature
+func signRecording(db *gorm.DB, store storage.Storage, key ed25519.PrivateKey, recording *models.TerminalRecording) error {
+	f, err := store.Open(recording.FilePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	m, err := recsign.Sign(key, recordingManifest(*recording), f)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	recording.Digest = m.Digest
+	recording.EventCount = m.Events
+	recording.Signature = m.Signature
+	recording.SigningKey = m.PublicKey
+	recording.SignedAt = &now
+	return db.Model(recording).Select("digest", "event_count", "signature", "signing_key", "signed_at").Updates(recording).Error
+}
+
+// completeRecording stores the size of a finished recording, then signs and indexes it.
+// EndTime and Duration must be set.
+func completeRecording(db *gorm.DB, store storage.Storage, signingKey ed25519.PrivateKey, recording models.TerminalRecording) {
+	recording.Size = recordingSize(store, recording.FilePath)
+	// Only the closing fields: an administrator may have placed a hold meanwhile
+	db.Model(&recording).Select("end_time", "duration", "size").Updates(&recording)
+
+	// Sign first, so the recording is tamper-evident from the moment it is complete
+	if err := signRecording(db, store, signingKey, &recording); err != nil {
+		utils.LogError("Failed to sign recording %d: %v", recording.ID, err)
+	}
+	if err := indexRecording(db, store, recording); err != nil {
+		utils.LogError("Failed to index recording %d: %v", recording.ID, err)
+	}
+}
+
+// verifiedFile identifies the object a verification result is for
+type verifiedFile struct {
+	signedAt time.Time
+	modTime  time.Time
+	size     int64
+	status   string
+	err      error
+}
+
+// verifyRecording checks a recording against its signature and the trusted keys. Results are
+// cached until the file changes, since playback asks for every part of a recording.
+func (h *RecordingHandler) verifyRecording(recording models.TerminalRecording) (string, error) {
+	if recording.SignedAt == nil {
+		return VerificationUnsigned, nil
+	}
+
+	f, err := h.store.Open(recording.FilePath)
+	if err != nil {
+		return VerificationFailed, err
+	}
+	defer f.Close()
+	info := f.Info()
+
+	if cached, ok := h.verified.Load(recording.ID); ok {
+		v := cached.(verifiedFile)
+		if v.signedAt.Equal(*recording.SignedAt) && v.modTime.Equal(info.ModTime) && v.size == info.Size {
+			return v.status, v.err
+		}
+	}
+
+	status := VerificationVerified
+	err = h.keys.Verify(recordingManifest(recording), f)
+	if err != nil {
+		status = VerificationFailed
+	}
+	h.verified.Store(recording.ID, verifiedFile{
+		signedAt: *recording.SignedAt,
+		modTime:  info.ModTime,
+		size:     info.Size,
+		status:   status,
+		err:      err,
+	})
+	return status, err
+}
+
+// RecordingPrefix is the storage key prefix of terminal recordings
+const RecordingPrefix = "recordings/"
+
+// legacyDataDir is where recordings were written before storage backends. Their keys are
+// their paths relative to it, so local storage at the default path keeps using them.
+const legacyDataDir = "data"
+
+// createRecordingObjects creates a compressed recording and its index
+func createRecordingObjects(store storage.Storage, key string) (data, index io.WriteCloser, err error) {
+	data, err = store.Create(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	index, err = store.Create(asciicast.IndexPath(key))
+	if err != nil {
+		data.Close()
+		store.Delete(key)
+		return nil, nil, err
+	}
+	return data, index, nil
+}
+
+// UpgradeRecordings validates the recordings on disk and upgrades those written before
+// recordings had an asciicast header. It only looks at files present when it starts.
+// Recordings that old were always written to the local data directory.
+func UpgradeRecordings(db *gorm.DB) {
+	reports, err := asciicast.UpgradeDir(filepath.Join(legacyDataDir, RecordingPrefix), func(path string) asciicast.Header {
+		// Older versions always requested an 80x24 PTY
+		header := asciicast.Header{Width: 80, Height: 24, Env: map[string]string{"TERM": "xterm-256color"}}
+		key := strings.TrimPrefix(filepath.ToSlash(path), legacyDataDir+"/")
+		var recording models.TerminalRecording
+		if err := db.Where("file_path = ?", key).First(&recording).Error; err == nil {
+			header.Timestamp = recording.StartTime.Unix()
+			header.Title = fmt.Sprintf("%s@%s", recording.Username, recording.Host)
+		} else if info, err := os.Stat(path); err == nil {
+			header.Timestamp = info.ModTime().Unix()
+		}
+		return header
+	})
+	if err != nil {
+		log.Printf("Failed to check recordings: %v", err)
+		return
+	}
+
+	for _, r := range reports {
+		switch r.Status {
+		case asciicast.StatusUpgraded:
+			log.Printf("Upgraded recording %s to asciicast v2 (%d events, %d unreadable lines dropped)", r.Path, r.Events, r.Dropped)
+		case asciicast.StatusInvalid:
+			utils.LogError("Recording %s is invalid: %s", r.Path, r.Error)
+		}
+	}
+}
+
+// indexBatchSize is how many lines of text are inserted at a time
+const indexBatchSize = 500
+
+// indexRecording extracts the text of a finished recording for search, replacing any earlier index
+func indexRecording(db *gorm.DB, store storage.Storage, recording models.TerminalRecording) error {
+	f, err := store.Open(recording.FilePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader, err := asciicast.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("recording_id = ?", recording.ID).Delete(&models.RecordingText{}).Error; err != nil {
+			return err
+		}
+
+		batch := make([]models.RecordingText, 0, indexBatchSize)
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			err := tx.Create(&batch).Error
+			batch = batch[:0]
+			return err
+		}
+		err := asciicast.ExtractText(reader, func(line asciicast.TextLine) error {
+			batch = append(batch, models.RecordingText{
+				RecordingID: recording.ID,
+				TimeOffset:  line.Time,
+				Kind:        line.Code,
+				Text:        line.Text,
+			})
+			if len(batch) >= indexBatchSize {
+				return flush()
+			}
+			return nil
+		})
+		if err == nil {
+			err = flush()
+		}
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&recording).Update("indexed_at", time.Now()).Error
+	})
+}
+
+// IndexRecordings indexes finished recordings that have not been indexed yet,
+// e.g. those made before search existed or whose server stopped before indexing
+func IndexRecordings(db *gorm.DB, store storage.Storage) {
+	var recordings []models.TerminalRecording
+	if err := db.Where("end_time IS NOT NULL AND indexed_at IS NULL").Find(&recordings).Error; err != nil {
+		log.Printf("Failed to find recordings to index: %v", err)
+		return
+	}
+	for _, recording := range recordings {
+		if err := indexRecording(db, store, recording); err != nil {
+			utils.LogError("Failed to index recording %d: %v", recording.ID, err)
+		}
+	}
+}
+
+// ImportRecordings moves recordings left in the local data directory, e.g. by versions
+// before storage backends, into store when it keeps them elsewhere
+func ImportRecordings(db *gorm.DB, store storage.Storage) {
+	var recordings []models.TerminalRecording
+	if err := db.Where("end_time IS NOT NULL").Find(&recordings).Error; err != nil {
+		log.Printf("Failed to find recordings to import: %v", err)
+		return
+	}
+	legacy := storage.NewLocal(legacyDataDir)
+	for _, recording := range recordings {
+		for _, key := range []string{recording.FilePath, asciicast.IndexPath(recording.FilePath)} {
+			if _, err := store.Stat(key); !errors.Is(err, storage.ErrNotFound) {
+				continue // Already there, or storage unavailable
+			}
+			if err := moveObject(legacy, store, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				utils.LogError("Failed to import recording %d: %v", recording.ID, err)
+				break
+			}
+		}
+	}
+}
+
+// moveObject copies an object from one storage to another and removes the original
+func moveObject(from, to storage.Storage, key string) error {
+	src, err := from.Open(key)
+	if err != nil {
+		return err
+	}
+	err = to.Put(key, src, src.Info().Size)
+	src.Close()
+	if err != nil {
+		return err
+	}
+	log.Printf("Moved %s to recording storage", key)
+	return from.Delete(key)
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"log"
+
+	"github.com/ihxw/termiscope/internal/config"
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/recsign"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoadRecordingKeys loads the key recordings are signed with, creating it on first start,
+// and the earlier keys whose signatures are still trusted. Each key in use is remembered,
+// so replacing the key file or the encryption key keeps old recordings verifiable.
+func LoadRecordingKeys(db *gorm.DB, cfg *config.Config) (*recsign.Keyring, error) {
+	key, created, err := recsign.LoadKey(cfg.Security.RecordingKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		log.Printf("Created recording signing key %s, keep a backup of it", cfg.Security.RecordingKeyFile)
+	}
+	if err := trustSigningKey(db, key, "file"); err != nil {
+		return nil, err
+	}
+
+	// Recordings used to be signed with a key derived from the encryption key
+	legacy := recsign.KeyFromSecret(cfg.Security.EncryptionKey)
+	var count int64
+	encoded := recsign.EncodeKey(legacy.Public().(ed25519.PublicKey))
+	if err := db.Model(&models.TerminalRecording{}).Where("signing_key = ?", encoded).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		if err := trustSigningKey(db, legacy, "legacy"); err != nil {
+			return nil, err
+		}
+	}
+
+	var rows []models.RecordingSigningKey
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	var trusted []ed25519.PublicKey
+	for _, row := range rows {
+		pub, err := recsign.ParseKey(row.PublicKey)
+		if err != nil {
+			log.Printf("Ignoring recording signing key %d: %v", row.ID, err)
+			continue
+		}
+		trusted = append(trusted, pub)
+	}
+	return recsign.NewKeyring(key, trusted...), nil
+}
+
+// trustSigningKey records the public half of key as trusted
+func trustSigningKey(db *gorm.DB, key ed25519.PrivateKey, origin string) error {
+	row := models.RecordingSigningKey{
+		PublicKey: recsign.EncodeKey(key.Public().(ed25519.PublicKey)),
+		Origin:    origin,
+	}
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/utils"
+	"gorm.io/gorm"
+)
+
+// RecordingPolicyHandler manages server-side recording policies
+type RecordingPolicyHandler struct {
+	db *gorm.DB
+}
+
+func NewRecordingPolicyHandler(db *gorm.DB) *RecordingPolicyHandler {
+	return &RecordingPolicyHandler{db: db}
+}
+
+type RecordingPolicyRequest struct {
+	Name        string `json:"name" binding:"required,max=100"`
+	Description string `json:"description"`
+	Mode        string `json:"mode" binding:"required,oneof=always never user_choice"`
+	RecordInput bool   `json:"record_input"`
+	HostGroups  string `json:"host_groups"`
+	HostTags    string `json:"host_tags"`
+	Roles       string `json:"roles"`
+	Enabled     *bool  `json:"enabled"`
+}
+
+// List returns all recording policies
+func (h *RecordingPolicyHandler) List(c *gin.Context) {
+	var policies []models.RecordingPolicy
+	if err := h.db.Order("id ASC").Find(&policies).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to fetch recording policies")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, policies)
+}
+
+// Create adds a recording policy
+func (h *RecordingPolicyHandler) Create(c *gin.Context) {
+	var req RecordingPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	policy := models.RecordingPolicy{Enabled: true}
+	req.apply(&policy)
+
+	if err := h.db.Create(&policy).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to create recording policy")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusCreated, policy)
+}
+
+// Update changes a recording policy. It applies to sessions started afterwards.
+func (h *RecordingPolicyHandler) Update(c *gin.Context) {
+	var policy models.RecordingPolicy
+	if err := h.db.First(&policy, c.Param("id")).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "recording policy not found")
+		return
+	}
+
+	var req RecordingPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	req.apply(&policy)
+
+	if err := h.db.Save(&policy).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to update recording policy")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, policy)
+}
+
+// Delete removes a recording policy
+func (h *RecordingPolicyHandler) Delete(c *gin.Context) {
+	result := h.db.Delete(&models.RecordingPolicy{}, c.Param("id"))
+	if result.Error != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to delete recording policy")
+		return
+	}
+	if result.RowsAffected == 0 {
+		utils.ErrorResponse(c, http.StatusNotFound, "recording policy not found")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "recording policy deleted successfully"})
+}
+
+func (req *RecordingPolicyRequest) apply(policy *models.RecordingPolicy) {
+	policy.Name = req.Name
+	policy.Description = req.Description
+	policy.Mode = req.Mode
+	policy.RecordInput = req.RecordInput
+	policy.HostGroups = strings.TrimSpace(req.HostGroups)
+	policy.HostTags = strings.TrimSpace(req.HostTags)
+	policy.Roles = strings.TrimSpace(req.Roles)
+	if req.Enabled != nil {
+		policy.Enabled = *req.Enabled
+	}
+}
+
+// recordingMode returns the recording mode for a session on host by a user with role,
+// and the policy that decided it (nil when no policy applies and the user chooses).
+// When several policies apply, always wins over never, and never over user_choice.
+// An error means the policies could not be read, and the session must not start.
+func recordingMode(db *gorm.DB, host models.SSHHost, role string) (string, *models.RecordingPolicy, error) {
+	var policies []models.RecordingPolicy
+	if err := db.Where("enabled = ?", true).Order("id ASC").Find(&policies).Error; err != nil {
+		return "", nil, err
+	}
+
+	rank := map[string]int{models.RecordingUserChoice: 1, models.RecordingNever: 2, models.RecordingAlways: 3}
+	var decided *models.RecordingPolicy
+	for i := range policies {
+		p := &policies[i]
+		if !p.Covers(host, role) {
+			continue
+		}
+		if decided == nil || rank[p.Mode] > rank[decided.Mode] {
+			decided = p
+		}
+	}
+	if decided == nil {
+		return models.RecordingUserChoice, nil, nil
+	}
+	return decided.Mode, decided, nil
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ihxw/termiscope/internal/asciicast"
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/recsign"
+	"github.com/ihxw/termiscope/internal/storage"
+	"github.com/ihxw/termiscope/internal/utils"
+	"gorm.io/gorm"
+)
+
+// recoveryInterval is how often uploads that failed are retried
+const recoveryInterval = 10 * time.Minute
+
+// StartRecordingRecovery finishes the recordings of sessions that were still running when
+// the server stopped, and with object storage uploads recordings that were left spooled by
+// a crash or a failed upload. It runs now and then every recoveryInterval; only recordings
+// started before it was called count as abandoned.
+func StartRecordingRecovery(db *gorm.DB, store storage.Storage, keys *recsign.Keyring) {
+	started := time.Now()
+	signingKey := keys.Signing()
+	go func() {
+		ticker := time.NewTicker(recoveryInterval)
+		defer ticker.Stop()
+		for {
+			recoverRecordings(db, store, signingKey, started)
+			<-ticker.C
+		}
+	}()
+}
+
+func recoverRecordings(db *gorm.DB, store storage.Storage, signingKey ed25519.PrivateKey, before time.Time) {
+	var recovered []string
+	if r, ok := store.(storage.Recoverer); ok {
+		keys, err := r.Recover()
+		if err != nil {
+			utils.LogError("Failed to upload spooled recordings: %v", err)
+		}
+		for _, key := range keys {
+			log.Printf("Uploaded spooled object %s", key)
+			if strings.HasSuffix(key, asciicast.IndexExt) {
+				key = strings.TrimSuffix(key, asciicast.IndexExt) + asciicast.CompressedExt
+			}
+			recovered = append(recovered, key)
+		}
+	}
+
+	// Finished recordings whose upload failed are only complete now
+	if len(recovered) > 0 {
+		var uploaded []models.TerminalRecording
+		if err := db.Where("end_time IS NOT NULL AND file_path IN ?", recovered).Find(&uploaded).Error; err != nil {
+			log.Printf("Failed to find uploaded recordings: %v", err)
+		}
+		for _, recording := range uploaded {
+			completeRecording(db, store, signingKey, recording)
+		}
+	}
+
+	var abandoned []models.TerminalRecording
+	if err := db.Where("end_time IS NULL AND start_time < ?", before).Find(&abandoned).Error; err != nil {
+		log.Printf("Failed to find abandoned recordings: %v", err)
+		return
+	}
+	for _, recording := range abandoned {
+		// The session is gone either way: end the recording with whatever could be saved
+		duration, err := salvageRecording(store, recording.FilePath)
+		if err != nil {
+			utils.LogError("Failed to salvage recording %d: %v", recording.ID, err)
+		}
+		end := recording.StartTime.Add(duration)
+		recording.EndTime = &end
+		recording.Duration = int(duration.Seconds())
+		completeRecording(db, store, signingKey, recording)
+		log.Printf("Finished recording %d of a session that ended with the server", recording.ID)
+	}
+}
+
+// salvageRecording rewrites a compressed recording cut short by a crash so that it ends
+// with a whole line, and returns how long it lasted
+func salvageRecording(store storage.Storage, key string) (time.Duration, error) {
+	src, err := store.Open(key)
+	if err != nil {
+		return 0, err
+	}
+	if !strings.HasSuffix(key, asciicast.CompressedExt) {
+		// Plain recordings need no repair, the reader skips a torn last line
+		defer src.Close()
+		last, err := lastEventTime(src)
+		return seconds(last), err
+	}
+
+	data, err := os.CreateTemp("", "salvage-*")
+	if err != nil {
+		src.Close()
+		return 0, err
+	}
+	defer os.Remove(data.Name())
+	defer data.Close()
+
+	var index bytes.Buffer
+	last, err := asciicast.Salvage(data, &index, src)
+	src.Close()
+	if err != nil {
+		return 0, err
+	}
+	size, err := data.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	if err := store.Put(key, data, size); err != nil {
+		return 0, err
+	}
+	if err := store.Put(asciicast.IndexPath(key), &index, int64(index.Len())); err != nil {
+		return 0, err
+	}
+	return seconds(last), nil
+}
+
+// lastEventTime returns the time of the last event of a recording
+func lastEventTime(src io.ReadSeeker) (float64, error) {
+	r, err := asciicast.NewReader(src)
+	if err != nil {
+		return 0, err
+	}
+	last := 0.0
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return last, nil
+		}
+		if err != nil {
+			return last, err
+		}
+		last = e.Time
+	}
+}
+
+func seconds(t float64) time.Duration {
+	return time.Duration(t * float64(time.Second))
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"github.com/ihxw/termiscope/internal/config"
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/storage"
+	"github.com/ihxw/termiscope/internal/utils"
+	"gorm.io/gorm"
+)
+
+// retentionInterval is how often the retention rules are enforced
+const retentionInterval = time.Hour
+
+// StartRecordingRetention enforces the recording retention rules now and then every hour.
+// The rules are read from cfg on every run, so changed settings apply without a restart.
+func StartRecordingRetention(db *gorm.DB, cfg *config.Config, store storage.Storage) {
+	go func() {
+		ticker := time.NewTicker(retentionInterval)
+		defer ticker.Stop()
+		for {
+			enforceRetention(db, store, cfg.RecordingRules())
+			<-ticker.C
+		}
+	}()
+}
+
+// enforceRetention removes finished recordings that break a retention rule, oldest first.
+// Recordings under legal hold still count towards the limits but are never removed.
+func enforceRetention(db *gorm.DB, store storage.Storage, rules config.RecordingConfig) {
+	backfillRecordingSizes(db, store)
+
+	var removed int
+	var freed int64
+	remove := func(recording models.TerminalRecording, rule string) {
+		if err := removeRecording(db, store, recording); err != nil {
+			utils.LogError("Retention: failed to remove recording %d: %v", recording.ID, err)
+			return
+		}
+		log.Printf("Retention: removed recording %d of user %d (%s, %d bytes)", recording.ID, recording.UserID, rule, recording.Size)
+		removed++
+		freed += recording.Size
+	}
+	candidates := func() *gorm.DB {
+		return db.Where("end_time IS NOT NULL AND legal_hold = ?", false).Order("start_time ASC")
+	}
+
+	// Maximum age
+	if rules.MaxAgeDays > 0 {
+		var expired []models.TerminalRecording
+		cutoff := time.Now().AddDate(0, 0, -rules.MaxAgeDays)
+		candidates().Where("start_time < ?", cutoff).Find(&expired)
+		for _, recording := range expired {
+			remove(recording, "max age")
+		}
+	}
+
+	// Per-user quota
+	if rules.UserQuotaMB > 0 {
+		quota := int64(rules.UserQuotaMB) << 20
+		var usage []struct {
+			UserID uint
+			Size   int64
+		}
+		db.Model(&models.TerminalRecording{}).
+			Select("user_id, SUM(size) AS size").
+			Group("user_id").
+			Having("SUM(size) > ?", quota).
+			Scan(&usage)
+		for _, u := range usage {
+			var recordings []models.TerminalRecording
+			candidates().Where("user_id = ?", u.UserID).Find(&recordings)
+			for _, recording := range recordings {
+				if u.Size <= quota {
+					break
+				}
+				remove(recording, "user quota")
+				u.Size -= recording.Size
+			}
+		}
+	}
+
+	// Total size
+	if rules.MaxTotalSizeMB > 0 {
+		limit := int64(rules.MaxTotalSizeMB) << 20
+		var total int64
+		db.Model(&models.TerminalRecording{}).Select("COALESCE(SUM(size), 0)").Scan(&total)
+		if total > limit {
+			var recordings []models.TerminalRecording
+			candidates().Find(&recordings)
+			for _, recording := range recordings {
+				if total <= limit {
+					break
+				}
+				remove(recording, "total size")
+				total -= recording.Size
+			}
+		}
+	}
+
+	if removed > 0 {
+		log.Printf("Retention: removed %d recordings, freed %d bytes", removed, freed)
+	}
+}
+
+// backfillRecordingSizes records the size of finished recordings made before sizes were kept
+func backfillRecordingSizes(db *gorm.DB, store storage.Storage) {
+	var recordings []models.TerminalRecording
+	db.Where("end_time IS NOT NULL AND size = 0").Find(&recordings)
+	for _, recording := range recordings {
+		if size := recordingSize(store, recording.FilePath); size > 0 {
+			db.Model(&recording).Update("size", size)
+		}
+	}
+}
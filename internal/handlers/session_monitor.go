@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ihxw/termiscope/internal/middleware"
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/terminal"
+	"github.com/ihxw/termiscope/internal/utils"
+	"gorm.io/gorm"
+)
+
+// SessionMonitorHandler lets admins see and terminate live terminal sessions of all users
+type SessionMonitorHandler struct {
+	db *gorm.DB
+}
+
+func NewSessionMonitorHandler(db *gorm.DB) *SessionMonitorHandler {
+	return &SessionMonitorHandler{db: db}
+}
+
+type KillSessionRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// List returns all live sessions, optionally filtered by ?user_id= and ?host_id=
+func (h *SessionMonitorHandler) List(c *gin.Context) {
+	queryUserID, _ := strconv.ParseUint(c.Query("user_id"), 10, 64)
+	queryHostID, _ := strconv.ParseUint(c.Query("host_id"), 10, 64)
+
+	result := make([]terminal.Status, 0)
+	for _, sess := range terminal.Sessions.List() {
+		if queryUserID != 0 && sess.UserID != uint(queryUserID) {
+			continue
+		}
+		if queryHostID != 0 && sess.HostID != uint(queryHostID) {
+			continue
+		}
+		result = append(result, sess.MonitorStatus())
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, result)
+}
+
+// Kill forcibly terminates a session; the reason is shown to the user and written to the connection log
+func (h *SessionMonitorHandler) Kill(c *gin.Context) {
+	var req KillSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	sess, ok := terminal.Sessions.Get(c.Param("id"))
+	if !ok {
+		utils.ErrorResponse(c, http.StatusNotFound, "session not found")
+		return
+	}
+
+	adminName := middleware.GetUsername(c)
+	reason := fmt.Sprintf("terminated by administrator %s: %s", adminName, req.Reason)
+
+	h.db.Create(&models.ConnectionLogEvent{
+		ConnectionLogID: sess.ConnectionLogID,
+		Event:           "kill",
+		ClientIP:        c.ClientIP(),
+		Detail:          reason,
+	})
+
+	hostID := sess.HostID
+	connLogID := sess.ConnectionLogID
+	recordAudit(h.db, &models.AuditLog{
+		UserID:          middleware.GetUserID(c),
+		SSHHostID:       &hostID,
+		ConnectionLogID: &connLogID,
+		Action:          "session_kill",
+		Detail:          fmt.Sprintf("session=%s owner=%s reason=%s", sess.ID, sess.Username, req.Reason),
+		ClientIP:        c.ClientIP(),
+	})
+
+	sess.Kill(reason)
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "session terminated successfully"})
+}
+
+// HandleShadowWebSocket lets an admin watch a live session read-only, without notifying its owner
+func (h *SSHWebSocketHandler) HandleShadowWebSocket(c *gin.Context) {
+	ticket, ok := utils.ValidateTicket(c.Query("ticket"))
+	if !ok {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "invalid or expired ticket")
+		return
+	}
+	if ticket.Role != "admin" {
+		utils.ErrorResponse(c, http.StatusForbidden, "admin access required")
+		return
+	}
+
+	sess, ok := terminal.Sessions.Get(c.Param("id"))
+	if !ok {
+		utils.ErrorResponse(c, http.StatusNotFound, "session not found")
+		return
+	}
+
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade to WebSocket: %v", err)
+		return
+	}
+	defer ws.Close()
+
+	client := newWSClient(ws)
+
+	client.WriteJSON(gin.H{
+		"type":       "connected",
+		"data":       fmt.Sprintf("Shadowing %s's session on %s (read-only)", sess.Username, sess.HostName),
+		"session_id": sess.ID,
+		"mode":       terminal.ModeReadOnly,
+		"viewer":     true,
+	})
+
+	viewer, err := sess.AddShadow(terminal.Viewer{
+		UserID:   ticket.UserID,
+		Username: ticket.Username,
+		ClientIP: c.ClientIP(),
+	}, client)
+	if err != nil {
+		client.Send("error", err.Error())
+		return
+	}
+	defer sess.RemoveViewer(viewer.ID)
+
+	hostID := sess.HostID
+	connLogID := sess.ConnectionLogID
+	recordAudit(h.db, &models.AuditLog{
+		UserID:          ticket.UserID,
+		SSHHostID:       &hostID,
+		ConnectionLogID: &connLogID,
+		Action:          "session_shadow",
+		Detail:          fmt.Sprintf("session=%s owner=%s", sess.ID, sess.Username),
+		ClientIP:        c.ClientIP(),
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go pingLoop(client, stop)
+
+	// Input is never forwarded; keep reading only to notice the admin leaving
+	for {
+		if _, err := client.Read(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/terminal"
+	"github.com/ihxw/termiscope/internal/utils"
+)
+
+// HandleShareWebSocket lets another user join a live session through a share token.
+// The viewer authenticates with their own one-time ticket, like the owner does.
+func (h *SSHWebSocketHandler) HandleShareWebSocket(c *gin.Context) {
+	ticket, ok := utils.ValidateTicket(c.Query("ticket"))
+	if !ok {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "invalid or expired ticket")
+		return
+	}
+
+	token := c.Param("token")
+	sess, _, ok := terminal.Sessions.FindShare(token)
+	if !ok {
+		utils.ErrorResponse(c, http.StatusNotFound, "share not found or expired")
+		return
+	}
+
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade to WebSocket: %v", err)
+		return
+	}
+	defer ws.Close()
+
+	client := newWSClient(ws)
+
+	viewer, err := sess.AddViewer(token, terminal.Viewer{
+		UserID:   ticket.UserID,
+		Username: ticket.Username,
+		ClientIP: c.ClientIP(),
+	}, client)
+	if err != nil {
+		client.Send("error", err.Error())
+		return
+	}
+	defer sess.RemoveViewer(viewer.ID)
+
+	hostID := sess.HostID
+	connLogID := sess.ConnectionLogID
+	recordAudit(h.db, &models.AuditLog{
+		UserID:          ticket.UserID,
+		SSHHostID:       &hostID,
+		ConnectionLogID: &connLogID,
+		Action:          "session_share_join",
+		Detail:          fmt.Sprintf("session=%s owner=%s mode=%s", sess.ID, sess.Username, viewer.Mode),
+		ClientIP:        c.ClientIP(),
+	})
+
+	client.WriteJSON(gin.H{
+		"type":       "connected",
+		"data":       fmt.Sprintf("Joined %s's session on %s (%s)", sess.Username, sess.HostName, viewer.Mode),
+		"session_id": sess.ID,
+		"mode":       viewer.Mode,
+		"viewer":     true,
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go pingLoop(client, stop)
+
+	for {
+		msg, err := client.Read()
+		if err != nil {
+			return
+		}
+
+		// Only co-pilots may type; the mode is re-checked so a revoked viewer cannot race in input
+		switch msg.Type {
+		case "input":
+			if sess.CanInput(viewer.ID) {
+				sess.Input(msg.Input)
+			}
+		case "close":
+			return
+		}
+	}
+}
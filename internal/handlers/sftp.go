@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/ihxw/termiscope/internal/config"
+	"github.com/ihxw/termiscope/internal/connlimit"
 	"github.com/ihxw/termiscope/internal/middleware"
 	"github.com/ihxw/termiscope/internal/models"
 	"github.com/ihxw/termiscope/internal/ssh"
@@ -46,54 +48,18 @@ func (h *SftpHandler) getSftpClient(userID uint, hostID string) (*sftp.Client, *
 		return nil, nil, fmt.Errorf("host not found")
 	}
 
-	// Decrypt credentials
-	var password, privateKey string
-	if host.PasswordEncrypted != "" {
-		decrypted, err := utils.DecryptAES(host.PasswordEncrypted, h.config.Security.EncryptionKey)
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to decrypt password")
-		}
-		password = decrypted
-	}
-	if host.PrivateKeyEncrypted != "" {
-		decrypted, err := utils.DecryptAES(host.PrivateKeyEncrypted, h.config.Security.EncryptionKey)
-		if err != nil {
-			return nil, nil, fmt.Errorf("failed to decrypt private key")
-		}
-		privateKey = decrypted
-	}
-
-	// Create SSH client
-	timeout, _ := time.ParseDuration(h.config.SSH.Timeout)
-	if timeout == 0 {
-		timeout = 30 * time.Second
-	}
-
-	sshClient, err := ssh.NewSSHClient(&ssh.SSHConfig{
-		Host:        host.Host,
-		Port:        host.Port,
-		Username:    host.Username,
-		Password:    password,
-		PrivateKey:  privateKey,
-		Timeout:     timeout,
-		Fingerprint: host.Fingerprint,
-	})
+	release, err := acquireConnection(h.db, h.config, connlimit.KindSFTP, userID, &host)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create SSH client: %w", err)
-	}
-
-	if err := sshClient.Connect(); err != nil {
-		return nil, nil, fmt.Errorf("failed to connect: %w", err)
+		return nil, nil, err
 	}
 
-	// TOFU: Save fingerprint if it was empty
-	if host.Fingerprint == "" {
-		newFp := sshClient.GetFingerprint()
-		if newFp != "" {
-			host.Fingerprint = newFp
-			h.db.Save(&host)
-		}
+	sshClient, err := connectHost(h.db, h.config, userID, &host)
+	if err != nil {
+		release()
+		return nil, nil, err
 	}
+	// The slot is held until the caller closes the SSH client
+	sshClient.OnClose(release)
 
 	// Create SFTP client
 	sftpClient, err := sftp.NewClient(sshClient.GetRawClient())
@@ -105,6 +71,16 @@ func (h *SftpHandler) getSftpClient(userID uint, hostID string) (*sftp.Client, *
 	return sftpClient, sshClient, nil
 }
 
+// sftpConnectError reports a failure to open the SFTP connection, with 429 when a connection limit is hit
+func sftpConnectError(c *gin.Context, err error) {
+	var limitErr *connlimit.LimitError
+	if errors.As(err, &limitErr) {
+		utils.ErrorResponse(c, http.StatusTooManyRequests, err.Error())
+		return
+	}
+	utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+}
+
 // List handled GET /api/sftp/list/:hostId?path=...
 func (h *SftpHandler) List(c *gin.Context) {
 	userID := middleware.GetUserID(c)
@@ -113,7 +89,7 @@ func (h *SftpHandler) List(c *gin.Context) {
 
 	sftpClient, sshClient, err := h.getSftpClient(userID, hostID)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		sftpConnectError(c, err)
 		return
 	}
 	defer sftpClient.Close()
@@ -165,7 +141,7 @@ func (h *SftpHandler) Download(c *gin.Context) {
 
 	sftpClient, sshClient, err := h.getSftpClient(userID, hostID)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		sftpConnectError(c, err)
 		return
 	}
 	defer sftpClient.Close()
@@ -216,7 +192,7 @@ func (h *SftpHandler) Upload(c *gin.Context) {
 
 	sftpClient, sshClient, err := h.getSftpClient(userID, hostID)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		sftpConnectError(c, err)
 		return
 	}
 	defer sftpClient.Close()
@@ -282,7 +258,7 @@ func (h *SftpHandler) Delete(c *gin.Context) {
 
 	sftpClient, sshClient, err := h.getSftpClient(userID, hostID)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		sftpConnectError(c, err)
 		return
 	}
 	defer sftpClient.Close()
@@ -315,7 +291,7 @@ func (h *SftpHandler) Rename(c *gin.Context) {
 
 	sftpClient, sshClient, err := h.getSftpClient(userID, hostID)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		sftpConnectError(c, err)
 		return
 	}
 	defer sftpClient.Close()
@@ -347,7 +323,7 @@ func (h *SftpHandler) Paste(c *gin.Context) {
 
 	sftpClient, sshClient, err := h.getSftpClient(userID, hostID)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		sftpConnectError(c, err)
 		return
 	}
 	defer sftpClient.Close()
@@ -445,7 +421,7 @@ func (h *SftpHandler) Mkdir(c *gin.Context) {
 
 	sftpClient, sshClient, err := h.getSftpClient(userID, hostID)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		sftpConnectError(c, err)
 		return
 	}
 	defer sftpClient.Close()
@@ -475,7 +451,7 @@ func (h *SftpHandler) CreateFile(c *gin.Context) {
 
 	sftpClient, sshClient, err := h.getSftpClient(userID, hostID)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		sftpConnectError(c, err)
 		return
 	}
 	defer sftpClient.Close()
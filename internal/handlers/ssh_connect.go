@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ihxw/termiscope/internal/config"
+	"github.com/ihxw/termiscope/internal/connlimit"
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/ssh"
+	"github.com/ihxw/termiscope/internal/utils"
+	"gorm.io/gorm"
+)
+
+// hopResult captures the outcome of dialing a single jump host
+type hopResult struct {
+	Index       int
+	Fingerprint string
+	Err         error
+}
+
+// buildSSHConfig decrypts the host credentials and resolves its jump host chain.
+// The returned jump hosts are in dial order and line up with SSHConfig.JumpHosts.
+// Jump hosts without a saved fingerprint are trusted on first use; onHop (optional)
+// is called for every hop after that.
+func buildSSHConfig(db *gorm.DB, cfg *config.Config, host *models.SSHHost, onHop ssh.HopCallback) (*ssh.SSHConfig, []models.SSHHost, error) {
+	timeout, err := time.ParseDuration(cfg.SSH.Timeout)
+	if err != nil || timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	sshCfg, err := hostSSHConfig(cfg, host, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	jumpHosts, err := loadJumpHosts(db, host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range jumpHosts {
+		hopCfg, err := hostSSHConfig(cfg, &jumpHosts[i], timeout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("jump host %s: %w", jumpHosts[i].Name, err)
+		}
+		sshCfg.JumpHosts = append(sshCfg.JumpHosts, hopCfg)
+	}
+
+	sshCfg.OnHop = func(index int, hop *ssh.SSHConfig, fingerprint string, err error) {
+		if err == nil {
+			trustOnFirstUse(db, &jumpHosts[index], fingerprint)
+		}
+		if onHop != nil {
+			onHop(index, hop, fingerprint, err)
+		}
+	}
+
+	return sshCfg, jumpHosts, nil
+}
+
+// hostSSHConfig builds the client config for a single host without its jump chain
+func hostSSHConfig(cfg *config.Config, host *models.SSHHost, timeout time.Duration) (*ssh.SSHConfig, error) {
+	var password, privateKey, passphrase string
+	if host.PasswordEncrypted != "" {
+		decrypted, err := utils.DecryptAES(host.PasswordEncrypted, cfg.Security.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt password")
+		}
+		password = decrypted
+	}
+	if host.PrivateKeyEncrypted != "" {
+		decrypted, err := utils.DecryptAES(host.PrivateKeyEncrypted, cfg.Security.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt private key")
+		}
+		privateKey = decrypted
+	}
+	if host.PassphraseEncrypted != "" {
+		decrypted, err := utils.DecryptAES(host.PassphraseEncrypted, cfg.Security.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt key passphrase")
+		}
+		passphrase = decrypted
+	}
+
+	proxy, err := hostProxyConfig(cfg, host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.SSHConfig{
+		Host:        host.Host,
+		Port:        host.Port,
+		Username:    host.Username,
+		Password:    password,
+		PrivateKey:  privateKey,
+		Passphrase:  passphrase,
+		Certificate: host.Certificate,
+		Timeout:     timeout,
+		Fingerprint: host.Fingerprint,
+		Proxy:       proxy,
+	}, nil
+}
+
+// hostProxyConfig resolves the outbound proxy for a host, falling back to the global setting.
+// It returns nil when the host should be dialed directly.
+func hostProxyConfig(cfg *config.Config, host *models.SSHHost) (*ssh.ProxyConfig, error) {
+	proxyType := host.ProxyType
+	proxyHost, proxyPort := host.ProxyHost, host.ProxyPort
+	username, passwordEncrypted := host.ProxyUsername, host.ProxyPasswordEncrypted
+	if proxyType == "" {
+		proxyType = cfg.SSH.ProxyType
+		proxyHost, proxyPort = cfg.SSH.ProxyHost, cfg.SSH.ProxyPort
+		username, passwordEncrypted = cfg.SSH.ProxyUsername, cfg.SSH.ProxyPasswordEncrypted
+	}
+	if proxyType == "" || proxyType == "none" {
+		return nil, nil
+	}
+
+	var password string
+	if passwordEncrypted != "" {
+		decrypted, err := utils.DecryptAES(passwordEncrypted, cfg.Security.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt proxy password")
+		}
+		password = decrypted
+	}
+
+	return &ssh.ProxyConfig{
+		Type:     proxyType,
+		Host:     proxyHost,
+		Port:     proxyPort,
+		Username: username,
+		Password: password,
+	}, nil
+}
+
+// validateProxySettings checks a proxy type/host/port combination
+func validateProxySettings(proxyType, proxyHost string, proxyPort int) error {
+	switch proxyType {
+	case "", "none":
+		return nil
+	case "socks5", "http":
+		if proxyHost == "" || proxyPort <= 0 || proxyPort > 65535 {
+			return fmt.Errorf("proxy host and port are required for %s proxy", proxyType)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid proxy type: %s", proxyType)
+	}
+}
+
+// loadJumpHosts returns the host's jump chain in dial order
+func loadJumpHosts(db *gorm.DB, host *models.SSHHost) ([]models.SSHHost, error) {
+	ids := parseJumpHostIDs(host.JumpHostIDs)
+	jumpHosts := make([]models.SSHHost, 0, len(ids))
+	for _, id := range ids {
+		var jump models.SSHHost
+		if err := db.Where("id = ? AND user_id = ?", id, host.UserID).First(&jump).Error; err != nil {
+			return nil, fmt.Errorf("jump host %d not found", id)
+		}
+		jumpHosts = append(jumpHosts, jump)
+	}
+	return jumpHosts, nil
+}
+
+// parseJumpHostIDs parses the comma-separated jump host chain
+func parseJumpHostIDs(value string) []uint {
+	var ids []uint
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids
+}
+
+// validateJumpHostIDs normalizes a jump chain and checks that every hop belongs to the user
+func validateJumpHostIDs(db *gorm.DB, userID, hostID uint, value string) (string, error) {
+	ids := parseJumpHostIDs(value)
+	seen := make(map[uint]bool)
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id == hostID {
+			return "", fmt.Errorf("a host cannot be its own jump host")
+		}
+		if seen[id] {
+			return "", fmt.Errorf("jump host %d appears more than once", id)
+		}
+		seen[id] = true
+
+		var count int64
+		db.Model(&models.SSHHost{}).Where("id = ? AND user_id = ?", id, userID).Count(&count)
+		if count == 0 {
+			return "", fmt.Errorf("jump host %d not found", id)
+		}
+		parts = append(parts, strconv.FormatUint(uint64(id), 10))
+	}
+	return strings.Join(parts, ","), nil
+}
+
+// trustOnFirstUse saves the fingerprint if the host does not have one yet
+func trustOnFirstUse(db *gorm.DB, host *models.SSHHost, fingerprint string) {
+	if host.Fingerprint != "" || fingerprint == "" {
+		return
+	}
+	host.Fingerprint = fingerprint
+	db.Model(host).Update("fingerprint", fingerprint)
+	log.Printf("TOFU: Saved new fingerprint for host %s: %s", host.Host, fingerprint)
+}
+
+// logHops writes one connection log per dialed jump host, linked to the parent log
+func logHops(db *gorm.DB, userID uint, parent *models.ConnectionLog, jumpHosts []models.SSHHost, hops []hopResult) []*models.ConnectionLog {
+	parentID := parent.ID
+	logs := make([]*models.ConnectionLog, 0, len(hops))
+	for _, hop := range hops {
+		jump := jumpHosts[hop.Index]
+		hopLog := &models.ConnectionLog{
+			UserID:      userID,
+			SSHHostID:   &jump.ID,
+			ParentLogID: &parentID,
+			Host:        jump.Host,
+			Port:        jump.Port,
+			Username:    jump.Username,
+			Status:      "success",
+			ConnectedAt: parent.ConnectedAt,
+		}
+		if hop.Err != nil {
+			hopLog.Status = "failed"
+			hopLog.ErrorMessage = hop.Err.Error()
+		}
+		db.Create(hopLog)
+		logs = append(logs, hopLog)
+	}
+	return logs
+}
+
+// closeHopLogs marks the connection logs of a finished connection as disconnected
+func closeHopLogs(db *gorm.DB, logs []*models.ConnectionLog, now time.Time) {
+	for _, hopLog := range logs {
+		if hopLog.Status != "success" {
+			continue
+		}
+		hopLog.DisconnectedAt = &now
+		hopLog.Duration = int(now.Sub(hopLog.ConnectedAt).Seconds())
+		hopLog.Status = "disconnected"
+		db.Save(hopLog)
+	}
+}
+
+// acquireConnection reserves a terminal or SFTP slot for the user on the host.
+// Admins are never limited; a user's own limit, if set, overrides the global one.
+func acquireConnection(db *gorm.DB, cfg *config.Config, kind string, userID uint, host *models.SSHHost) (func(), error) {
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	userLimit, hostLimit := effectiveUserLimit(cfg, &user), host.MaxConnections
+	if user.IsAdmin() {
+		hostLimit = 0
+	}
+
+	return connlimit.Default.Acquire(kind, userID, userLimit, host.ID, hostLimit)
+}
+
+// effectiveUserLimit returns the concurrent connection limit of a user, <= 0 meaning unlimited
+func effectiveUserLimit(cfg *config.Config, user *models.User) int {
+	if user.IsAdmin() {
+		return 0
+	}
+	if user.MaxConnections != 0 {
+		return user.MaxConnections
+	}
+	return cfg.SSH.MaxConnectionsPerUser
+}
+
+// connectHost dials a saved host through its jump host chain for features other than the
+// terminal. Host keys seen for the first time are trusted. A connection through jump hosts
+// is written to the connection log with one log per hop, closed along with the client.
+func connectHost(db *gorm.DB, cfg *config.Config, userID uint, host *models.SSHHost) (*ssh.SSHClient, error) {
+	var hops []hopResult
+	sshConfig, jumpHosts, err := buildSSHConfig(db, cfg, host, func(index int, hop *ssh.SSHConfig, fingerprint string, err error) {
+		hops = append(hops, hopResult{Index: index, Fingerprint: fingerprint, Err: err})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sshClient, err := ssh.NewSSHClient(sshConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SSH client: %w", err)
+	}
+
+	connectedAt := time.Now()
+	err = sshClient.Connect()
+	if len(jumpHosts) > 0 {
+		connLog := &models.ConnectionLog{
+			UserID:      userID,
+			SSHHostID:   &host.ID,
+			Host:        host.Host,
+			Port:        host.Port,
+			Username:    host.Username,
+			Status:      "success",
+			ConnectedAt: connectedAt,
+		}
+		if err != nil {
+			connLog.Status = "failed"
+			connLog.ErrorMessage = err.Error()
+		}
+		db.Create(connLog)
+		logs := append([]*models.ConnectionLog{connLog}, logHops(db, userID, connLog, jumpHosts, hops)...)
+		if err != nil {
+			closeHopLogs(db, logs, time.Now())
+		} else {
+			sshClient.OnClose(func() {
+				closeHopLogs(db, logs, time.Now())
+			})
+		}
+	}
+	if err != nil {
+		sshClient.Close()
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	// TOFU: Save fingerprint if it was empty
+	trustOnFirstUse(db, host, sshClient.GetFingerprint())
+	return sshClient, nil
+}
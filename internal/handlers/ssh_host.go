@@ -1,15 +1,18 @@
 package handlers
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ihxw/termiscope/internal/config"
 	"github.com/ihxw/termiscope/internal/middleware"
 	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/ssh"
 	"github.com/ihxw/termiscope/internal/utils"
 	"gorm.io/gorm"
 )
@@ -27,29 +30,53 @@ func NewSSHHostHandler(db *gorm.DB, cfg *config.Config) *SSHHostHandler {
 }
 
 type CreateSSHHostRequest struct {
-	Name        string `json:"name" binding:"required"`
-	Host        string `json:"host" binding:"required"`
-	Port        int    `json:"port"`
-	Username    string `json:"username" binding:"required"`
-	AuthType    string `json:"auth_type" binding:"required,oneof=password key"`
-	Password    string `json:"password"`
-	PrivateKey  string `json:"private_key"`
-	GroupName   string `json:"group_name"`
-	Tags        string `json:"tags"`
-	Description string `json:"description"`
+	Name           string `json:"name" binding:"required"`
+	Host           string `json:"host" binding:"required"`
+	Port           int    `json:"port"`
+	Username       string `json:"username" binding:"required"`
+	AuthType       string `json:"auth_type" binding:"required,oneof=password key"`
+	Password       string `json:"password"`
+	PrivateKey     string `json:"private_key"`
+	Passphrase     string `json:"passphrase"`  // For encrypted private keys
+	Certificate    string `json:"certificate"` // OpenSSH user certificate (*-cert.pub)
+	GroupName      string `json:"group_name"`
+	Tags           string `json:"tags"`
+	Description    string `json:"description"`
+	JumpHostIDs    string `json:"jump_host_ids"` // Ordered, comma-separated host IDs
+	ForwardAgent   bool   `json:"forward_agent"`
+	WebProxyPorts  string `json:"web_proxy_ports"`                 // Comma-separated ports allowed through /proxy
+	MaxConnections int    `json:"max_connections" binding:"min=0"` // Concurrent terminal/SFTP connections, 0 is unlimited
+	// Outbound proxy ("" inherits the global proxy)
+	ProxyType     string `json:"proxy_type" binding:"omitempty,oneof=none socks5 http"`
+	ProxyHost     string `json:"proxy_host"`
+	ProxyPort     int    `json:"proxy_port"`
+	ProxyUsername string `json:"proxy_username"`
+	ProxyPassword string `json:"proxy_password"`
 }
 
 type UpdateSSHHostRequest struct {
-	Name        string `json:"name"`
-	Host        string `json:"host"`
-	Port        int    `json:"port"`
-	Username    string `json:"username"`
-	AuthType    string `json:"auth_type" binding:"omitempty,oneof=password key"`
-	Password    string `json:"password"`
-	PrivateKey  string `json:"private_key"`
-	GroupName   string `json:"group_name"`
-	Tags        string `json:"tags"`
-	Description string `json:"description"`
+	Name           string  `json:"name"`
+	Host           string  `json:"host"`
+	Port           int     `json:"port"`
+	Username       string  `json:"username"`
+	AuthType       string  `json:"auth_type" binding:"omitempty,oneof=password key"`
+	Password       string  `json:"password"`
+	PrivateKey     string  `json:"private_key"`
+	Passphrase     string  `json:"passphrase"`  // Empty keeps the current passphrase unless a new key is sent
+	Certificate    *string `json:"certificate"` // nil keeps the current certificate, "" removes it
+	GroupName      string  `json:"group_name"`
+	Tags           string  `json:"tags"`
+	Description    string  `json:"description"`
+	JumpHostIDs    *string `json:"jump_host_ids"` // nil keeps the current chain, "" clears it
+	ForwardAgent   *bool   `json:"forward_agent"`
+	WebProxyPorts  *string `json:"web_proxy_ports"` // nil keeps the current allow-list, "" disables the proxy
+	MaxConnections *int    `json:"max_connections" binding:"omitempty,min=0"`
+	// Outbound proxy; nil keeps the current setting, "" inherits the global proxy
+	ProxyType     *string `json:"proxy_type" binding:"omitempty,oneof=none socks5 http"`
+	ProxyHost     string  `json:"proxy_host"`
+	ProxyPort     int     `json:"proxy_port"`
+	ProxyUsername string  `json:"proxy_username"`
+	ProxyPassword string  `json:"proxy_password"`
 	// Network Config
 	NetInterface string `json:"net_interface"`
 	NetResetDay  int    `json:"net_reset_day"`
@@ -117,6 +144,18 @@ func (h *SSHHostHandler) Get(c *gin.Context) {
 			host.PrivateKey = privateKey
 		}
 	}
+	if host.PassphraseEncrypted != "" {
+		passphrase, err := utils.DecryptAES(host.PassphraseEncrypted, h.config.Security.EncryptionKey)
+		if err == nil {
+			host.Passphrase = passphrase
+		}
+	}
+	if host.ProxyPasswordEncrypted != "" {
+		proxyPassword, err := utils.DecryptAES(host.ProxyPasswordEncrypted, h.config.Security.EncryptionKey)
+		if err == nil {
+			host.ProxyPassword = proxyPassword
+		}
+	}
 
 	utils.SuccessResponse(c, http.StatusOK, host)
 }
@@ -140,23 +179,55 @@ func (h *SSHHostHandler) Create(c *gin.Context) {
 		utils.ErrorResponse(c, http.StatusBadRequest, "private key is required for key authentication")
 		return
 	}
+	if req.PrivateKey != "" {
+		// Reject unusable keys up front rather than at connect time
+		if _, err := ssh.ParseSigner(req.PrivateKey, req.Passphrase, req.Certificate); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "invalid private key: "+err.Error())
+			return
+		}
+	}
 
 	// Set default port
 	if req.Port == 0 {
 		req.Port = 22
 	}
 
+	jumpHostIDs, err := validateJumpHostIDs(h.db, userID, 0, req.JumpHostIDs)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validateProxySettings(req.ProxyType, req.ProxyHost, req.ProxyPort); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	webProxyPorts, err := normalizeWebProxyPorts(req.WebProxyPorts)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// Create host
 	host := &models.SSHHost{
-		UserID:      userID,
-		Name:        req.Name,
-		Host:        req.Host,
-		Port:        req.Port,
-		Username:    req.Username,
-		AuthType:    req.AuthType,
-		GroupName:   req.GroupName,
-		Tags:        req.Tags,
-		Description: req.Description,
+		UserID:         userID,
+		Name:           req.Name,
+		Host:           req.Host,
+		Port:           req.Port,
+		Username:       req.Username,
+		AuthType:       req.AuthType,
+		GroupName:      req.GroupName,
+		Tags:           req.Tags,
+		Description:    req.Description,
+		Certificate:    strings.TrimSpace(req.Certificate),
+		JumpHostIDs:    jumpHostIDs,
+		ForwardAgent:   req.ForwardAgent,
+		WebProxyPorts:  webProxyPorts,
+		MaxConnections: req.MaxConnections,
+		// Proxy
+		ProxyType:     req.ProxyType,
+		ProxyHost:     req.ProxyHost,
+		ProxyPort:     req.ProxyPort,
+		ProxyUsername: req.ProxyUsername,
 		// Default Notification Settings for new host
 		NotifyOfflineEnabled:   true,
 		NotifyTrafficEnabled:   true,
@@ -182,6 +253,22 @@ func (h *SSHHostHandler) Create(c *gin.Context) {
 		}
 		host.PrivateKeyEncrypted = encrypted
 	}
+	if req.Passphrase != "" {
+		encrypted, err := utils.EncryptAES(req.Passphrase, h.config.Security.EncryptionKey)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, "failed to encrypt passphrase")
+			return
+		}
+		host.PassphraseEncrypted = encrypted
+	}
+	if req.ProxyPassword != "" {
+		encrypted, err := utils.EncryptAES(req.ProxyPassword, h.config.Security.EncryptionKey)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, "failed to encrypt proxy password")
+			return
+		}
+		host.ProxyPasswordEncrypted = encrypted
+	}
 
 	if err := h.db.Create(host).Error; err != nil {
 		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to create host")
@@ -233,6 +320,38 @@ func (h *SSHHostHandler) Update(c *gin.Context) {
 	if req.Description != "" {
 		host.Description = req.Description
 	}
+	if req.JumpHostIDs != nil {
+		jumpHostIDs, err := validateJumpHostIDs(h.db, userID, host.ID, *req.JumpHostIDs)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		host.JumpHostIDs = jumpHostIDs
+	}
+	if req.ForwardAgent != nil {
+		host.ForwardAgent = *req.ForwardAgent
+	}
+	if req.MaxConnections != nil {
+		host.MaxConnections = *req.MaxConnections
+	}
+	if req.WebProxyPorts != nil {
+		webProxyPorts, err := normalizeWebProxyPorts(*req.WebProxyPorts)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		host.WebProxyPorts = webProxyPorts
+	}
+	if req.ProxyType != nil {
+		if err := validateProxySettings(*req.ProxyType, req.ProxyHost, req.ProxyPort); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		host.ProxyType = *req.ProxyType
+		host.ProxyHost = req.ProxyHost
+		host.ProxyPort = req.ProxyPort
+		host.ProxyUsername = req.ProxyUsername
+	}
 	// Network Config
 	if req.NetInterface != "" {
 		// If interface selection changed, we MUST reset LastRaw to avoid massive delta spikes
@@ -327,13 +446,19 @@ func (h *SSHHostHandler) Update(c *gin.Context) {
 		}
 		host.PasswordEncrypted = encrypted
 	}
-	if req.PrivateKey != "" {
-		encrypted, err := utils.EncryptAES(req.PrivateKey, h.config.Security.EncryptionKey)
+	if req.PrivateKey != "" || req.Passphrase != "" || req.Certificate != nil {
+		if err := h.updateKeyMaterial(&host, &req); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if req.ProxyPassword != "" {
+		encrypted, err := utils.EncryptAES(req.ProxyPassword, h.config.Security.EncryptionKey)
 		if err != nil {
-			utils.ErrorResponse(c, http.StatusInternalServerError, "failed to encrypt private key")
+			utils.ErrorResponse(c, http.StatusInternalServerError, "failed to encrypt proxy password")
 			return
 		}
-		host.PrivateKeyEncrypted = encrypted
+		host.ProxyPasswordEncrypted = encrypted
 	}
 
 	if err := h.db.Save(&host).Error; err != nil {
@@ -344,6 +469,60 @@ func (h *SSHHostHandler) Update(c *gin.Context) {
 	utils.SuccessResponse(c, http.StatusOK, host)
 }
 
+// updateKeyMaterial validates and stores a changed private key, passphrase or certificate.
+// Values not present in the request are taken from the saved host so the full combination is checked.
+func (h *SSHHostHandler) updateKeyMaterial(host *models.SSHHost, req *UpdateSSHHostRequest) error {
+	key := h.config.Security.EncryptionKey
+
+	privateKey := req.PrivateKey
+	if privateKey == "" && host.PrivateKeyEncrypted != "" {
+		decrypted, err := utils.DecryptAES(host.PrivateKeyEncrypted, key)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt private key")
+		}
+		privateKey = decrypted
+	}
+
+	// A new key without a passphrase drops the old passphrase
+	passphrase := req.Passphrase
+	if passphrase == "" && req.PrivateKey == "" && host.PassphraseEncrypted != "" {
+		decrypted, err := utils.DecryptAES(host.PassphraseEncrypted, key)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt passphrase")
+		}
+		passphrase = decrypted
+	}
+
+	certificate := host.Certificate
+	if req.Certificate != nil {
+		certificate = strings.TrimSpace(*req.Certificate)
+	}
+
+	if privateKey == "" {
+		return fmt.Errorf("a private key is required to use a passphrase or certificate")
+	}
+	if _, err := ssh.ParseSigner(privateKey, passphrase, certificate); err != nil {
+		return fmt.Errorf("invalid private key: %w", err)
+	}
+
+	encryptedKey, err := utils.EncryptAES(privateKey, key)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt private key")
+	}
+	encryptedPassphrase := ""
+	if passphrase != "" {
+		encryptedPassphrase, err = utils.EncryptAES(passphrase, key)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt passphrase")
+		}
+	}
+
+	host.PrivateKeyEncrypted = encryptedKey
+	host.PassphraseEncrypted = encryptedPassphrase
+	host.Certificate = certificate
+	return nil
+}
+
 // Delete deletes an SSH host
 func (h *SSHHostHandler) Delete(c *gin.Context) {
 	userID := middleware.GetUserID(c)
@@ -375,9 +554,22 @@ func (h *SSHHostHandler) TestConnection(c *gin.Context) {
 		return
 	}
 
-	target := net.JoinHostPort(host.Host, strconv.Itoa(host.Port))
+	// With a jump chain, the first bastion is the only host we can reach directly
+	entry := host
+	jumpHosts, err := loadJumpHosts(h.db, &host)
+	if err == nil && len(jumpHosts) > 0 {
+		entry = jumpHosts[0]
+	}
+
+	proxy, err := hostProxyConfig(h.config, &entry)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	target := net.JoinHostPort(entry.Host, strconv.Itoa(entry.Port))
 	start := time.Now()
-	conn, err := net.DialTimeout("tcp", target, 5*time.Second)
+	conn, err := ssh.DialTCP(proxy, target, 5*time.Second)
 	duration := time.Since(start)
 
 	if err != nil {
@@ -2,22 +2,28 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
-	"os"
-	"path/filepath"
 	"runtime/debug"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/ihxw/termiscope/internal/asciicast"
+	"github.com/ihxw/termiscope/internal/cmdaudit"
 	"github.com/ihxw/termiscope/internal/config"
+	"github.com/ihxw/termiscope/internal/connlimit"
 	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/recsign"
 	"github.com/ihxw/termiscope/internal/ssh"
+	"github.com/ihxw/termiscope/internal/storage"
+	"github.com/ihxw/termiscope/internal/terminal"
 	"github.com/ihxw/termiscope/internal/utils"
+	gossh "golang.org/x/crypto/ssh"
 	"gorm.io/gorm"
 )
 
@@ -28,22 +34,27 @@ var upgrader = websocket.Upgrader{
 		return true // Allow all origins in development
 	},
 	EnableCompression: true,
+	Subprotocols:      []string{SubprotocolBinary, SubprotocolJSON},
 }
 
 type SSHWebSocketHandler struct {
 	db     *gorm.DB
 	config *config.Config
+	store  storage.Storage // Where recordings are written
+	keys   *recsign.Keyring
 }
 
-func NewSSHWebSocketHandler(db *gorm.DB, cfg *config.Config) *SSHWebSocketHandler {
+func NewSSHWebSocketHandler(db *gorm.DB, cfg *config.Config, store storage.Storage, keys *recsign.Keyring) *SSHWebSocketHandler {
 	return &SSHWebSocketHandler{
 		db:     db,
 		config: cfg,
+		store:  store,
+		keys:   keys,
 	}
 }
 
 type WSMessage struct {
-	Type string      `json:"type"` // input, resize
+	Type string      `json:"type"` // input, resize, auth_response, auth_cancel
 	Data interface{} `json:"data"`
 }
 
@@ -52,6 +63,63 @@ type ResizeData struct {
 	Cols int `json:"cols"`
 }
 
+// AuthPrompt is a single keyboard-interactive question sent to the browser
+type AuthPrompt struct {
+	Prompt string `json:"prompt"`
+	Echo   bool   `json:"echo"`
+}
+
+// authPromptTimeout bounds how long we wait for the user to answer an auth prompt
+const authPromptTimeout = 2 * time.Minute
+
+// authPrompter relays keyboard-interactive questions to the browser as "auth_prompt"
+// messages and waits for the matching "auth_response". It runs before the stdin loop
+// starts, so it is the only reader of the WebSocket at that point.
+func (h *SSHWebSocketHandler) authPrompter(client *wsClient, hostName string, pendingResize **ResizeData) ssh.PromptFunc {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		prompts := make([]AuthPrompt, len(questions))
+		for i, q := range questions {
+			prompts[i] = AuthPrompt{Prompt: q, Echo: echos[i]}
+		}
+		if err := client.WriteJSON(gin.H{
+			"type": "auth_prompt",
+			"data": gin.H{
+				"host":        hostName,
+				"name":        name,
+				"instruction": instruction,
+				"prompts":     prompts,
+			},
+		}); err != nil {
+			return nil, err
+		}
+
+		client.ws.SetReadDeadline(time.Now().Add(authPromptTimeout))
+		defer client.ws.SetReadDeadline(time.Time{})
+
+		for {
+			msg, err := client.Read()
+			if err != nil {
+				return nil, fmt.Errorf("waiting for authentication response: %w", err)
+			}
+
+			switch msg.Type {
+			case "auth_response":
+				var answers []string
+				dataBytes, _ := json.Marshal(msg.Msg.Data)
+				if err := json.Unmarshal(dataBytes, &answers); err != nil {
+					return nil, fmt.Errorf("invalid authentication response")
+				}
+				return answers, nil
+			case "auth_cancel":
+				return nil, fmt.Errorf("authentication cancelled by user")
+			case "resize":
+				resizeData := msg.Resize
+				*pendingResize = &resizeData
+			}
+		}
+	}
+}
+
 // HandleWebSocket handles WebSocket connections for SSH
 func (h *SSHWebSocketHandler) HandleWebSocket(c *gin.Context) {
 	ticketID := c.Query("ticket")
@@ -61,6 +129,12 @@ func (h *SSHWebSocketHandler) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
+	// Reattach to a detached (or attached elsewhere) session
+	if sessionID := c.Query("session"); sessionID != "" {
+		h.reattach(c, ticket, sessionID)
+		return
+	}
+
 	userID := ticket.UserID
 	hostID := c.Param("hostId")
 
@@ -71,23 +145,14 @@ func (h *SSHWebSocketHandler) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
-	// Decrypt credentials
-	var password, privateKey string
-	if host.PasswordEncrypted != "" {
-		decrypted, err := utils.DecryptAES(host.PasswordEncrypted, h.config.Security.EncryptionKey)
-		if err != nil {
-			utils.ErrorResponse(c, http.StatusInternalServerError, "failed to decrypt password")
-			return
-		}
-		password = decrypted
-	}
-	if host.PrivateKeyEncrypted != "" {
-		decrypted, err := utils.DecryptAES(host.PrivateKeyEncrypted, h.config.Security.EncryptionKey)
-		if err != nil {
-			utils.ErrorResponse(c, http.StatusInternalServerError, "failed to decrypt private key")
-			return
-		}
-		privateKey = decrypted
+	// Resolve credentials and jump host chain
+	var hops []hopResult
+	sshConfig, jumpHosts, err := buildSSHConfig(h.db, h.config, &host, func(index int, hop *ssh.SSHConfig, fingerprint string, err error) {
+		hops = append(hops, hopResult{Index: index, Fingerprint: fingerprint, Err: err})
+	})
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
 	}
 
 	// Upgrade to WebSocket
@@ -98,53 +163,46 @@ func (h *SSHWebSocketHandler) HandleWebSocket(c *gin.Context) {
 	}
 	defer ws.Close()
 
-	// Parse timeout
-	timeout, err := time.ParseDuration(h.config.SSH.Timeout)
-	if err != nil {
-		timeout = 30 * time.Second
-	}
+	// client serializes writes and speaks whichever protocol the browser negotiated
+	client := newWSClient(ws)
+	writeJSON := client.WriteJSON
 
-	// Parse idle timeout
-	idleTimeout, err := time.ParseDuration(h.config.SSH.IdleTimeout)
-	if err != nil {
-		idleTimeout = 30 * time.Minute
+	// Keyboard-interactive prompts are relayed to the browser while connecting.
+	// A resize sent by the client in the meantime is applied once the PTY exists.
+	var pendingResize *ResizeData
+	sshConfig.Prompt = h.authPrompter(client, host.Name, &pendingResize)
+	for i, hop := range sshConfig.JumpHosts {
+		hop.Prompt = h.authPrompter(client, jumpHosts[i].Name, &pendingResize)
 	}
 
 	// Create SSH client
-	sshClient, err := ssh.NewSSHClient(&ssh.SSHConfig{
-		Host:        host.Host,
-		Port:        host.Port,
-		Username:    host.Username,
-		Password:    password,
-		PrivateKey:  privateKey,
-		Timeout:     timeout,
-		Fingerprint: host.Fingerprint,
-	})
+	sshClient, err := ssh.NewSSHClient(sshConfig)
 	if err != nil {
-		ws.WriteJSON(gin.H{"type": "error", "data": "Failed to create SSH client: " + err.Error()})
+		writeJSON(gin.H{"type": "error", "data": "Failed to create SSH client: " + err.Error()})
 		return
 	}
-	defer sshClient.Close()
-
-	// ... connLog ... (omitted creation to keep context short, but assuming it follows)
-	// Actually I need to match enough context.
-	// Let's match from Create SSH client to Connect.
-
-	// wsMutex ensures concurrent writes to the websocket are safe
-	var wsMutex sync.Mutex
-
-	// Helper to write to websocket safely
-	writeParams := func(msgType int, data []byte) error {
-		wsMutex.Lock()
-		defer wsMutex.Unlock()
-		return ws.WriteMessage(msgType, data)
+	// Reserve a connection slot, held until the SSH client is closed
+	release, err := acquireConnection(h.db, h.config, connlimit.KindTerminal, userID, &host)
+	if err != nil {
+		var limitErr *connlimit.LimitError
+		if errors.As(err, &limitErr) {
+			writeJSON(gin.H{"type": "error", "code": "connection_limit", "data": err.Error(), "meta": limitErr})
+			ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "connection_limit"), time.Now().Add(time.Second))
+		} else {
+			writeJSON(gin.H{"type": "error", "data": err.Error()})
+		}
+		sshClient.Close()
+		return
 	}
+	sshClient.OnClose(release)
 
-	writeJSON := func(v interface{}) error {
-		wsMutex.Lock()
-		defer wsMutex.Unlock()
-		return ws.WriteJSON(v)
-	}
+	// Until the session takes over, the client is closed when this handler returns
+	ownsClient := true
+	defer func() {
+		if ownsClient {
+			sshClient.Close()
+		}
+	}()
 
 	// Create connection log
 	connLog := &models.ConnectionLog{
@@ -158,24 +216,37 @@ func (h *SSHWebSocketHandler) HandleWebSocket(c *gin.Context) {
 	}
 	h.db.Create(connLog)
 
-	// Connect to SSH server
-	if err := sshClient.Connect(); err != nil {
+	// Connect to SSH server (through the jump host chain, if any)
+	err = sshClient.Connect()
+	hopLogs := logHops(h.db, userID, connLog, jumpHosts, hops)
+	closeHops := true
+	defer func() {
+		if closeHops {
+			closeHopLogs(h.db, hopLogs, time.Now())
+		}
+	}()
+	if err != nil {
 		connLog.Status = "failed"
 		connLog.ErrorMessage = err.Error()
 		h.db.Save(connLog)
 
 		// Check for host key mismatch
-		if err.Error() == "host key fingerprint mismatch" || (len(err.Error()) > 29 && err.Error()[:29] == "host key fingerprint mismatch") {
-			// Extract the new fingerprint from the error or client
-			// The error message format is "host key fingerprint mismatch: anticipated %s, got %s"
-			// But we can also get it from client.GetFingerprint() because the callback sets it even on error?
-			// Let's check client implementation. The callback sets client.fingerprint = fp BEFORE returning error.
+		if errors.Is(err, ssh.ErrHostKeyMismatch) {
+			// The mismatch may come from a jump host rather than the target.
+			// The callback records the fingerprint BEFORE returning the error.
+			mismatchHost := host
 			newFp := sshClient.GetFingerprint()
+			if len(hops) > 0 && hops[len(hops)-1].Err != nil {
+				last := hops[len(hops)-1]
+				mismatchHost = jumpHosts[last.Index]
+				newFp = last.Fingerprint
+			}
 			writeJSON(gin.H{
 				"type": "error",
 				"code": "fingerprint_mismatch",
-				"data": fmt.Sprintf("Host key verification failed. The remote host identification has changed! New fingerprint: %s", newFp),
+				"data": fmt.Sprintf("Host key verification failed for %s. The remote host identification has changed! New fingerprint: %s", mismatchHost.Name, newFp),
 				"meta": gin.H{
+					"host_id":         mismatchHost.ID,
 					"new_fingerprint": newFp,
 				},
 			})
@@ -186,14 +257,7 @@ func (h *SSHWebSocketHandler) HandleWebSocket(c *gin.Context) {
 	}
 
 	// TOFU: Save fingerprint if it was empty
-	if host.Fingerprint == "" {
-		newFp := sshClient.GetFingerprint()
-		if newFp != "" {
-			host.Fingerprint = newFp
-			h.db.Save(&host)
-			log.Printf("TOFU: Saved new fingerprint for host %s: %s", host.Host, newFp)
-		}
-	}
+	trustOnFirstUse(h.db, &host, sshClient.GetFingerprint())
 
 	// Create session
 	if err := sshClient.NewSession(); err != nil {
@@ -206,6 +270,29 @@ func (h *SSHWebSocketHandler) HandleWebSocket(c *gin.Context) {
 
 	session := sshClient.GetSession()
 
+	// Agent forwarding: the remote side can sign with the user's loaded keys, every signature is audited
+	if host.ForwardAgent {
+		clientIP := c.ClientIP()
+		forwarded := ssh.NewForwardedAgent(ssh.Agents.Get(userID), func(key gossh.PublicKey, err error) {
+			detail := fmt.Sprintf("key=%s type=%s", gossh.FingerprintSHA256(key), key.Type())
+			if err != nil {
+				detail += " error=" + err.Error()
+			}
+			recordAudit(h.db, &models.AuditLog{
+				UserID:          userID,
+				SSHHostID:       &host.ID,
+				ConnectionLogID: &connLog.ID,
+				Action:          "agent_sign",
+				Detail:          detail,
+				ClientIP:        clientIP,
+			})
+		})
+		if err := sshClient.ForwardAgent(forwarded); err != nil {
+			log.Printf("Agent forwarding failed for host %s: %v", host.Host, err)
+			writeJSON(gin.H{"type": "warning", "data": "Agent forwarding unavailable: " + err.Error()})
+		}
+	}
+
 	// Request PTY
 	if err := sshClient.RequestPTY("xterm-256color", 24, 80); err != nil {
 		connLog.Status = "failed"
@@ -215,6 +302,10 @@ func (h *SSHWebSocketHandler) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
+	if pendingResize != nil {
+		sshClient.Resize(pendingResize.Rows, pendingResize.Cols)
+	}
+
 	// Set up pipes
 	stdin, err := session.StdinPipe()
 	if err != nil {
@@ -252,193 +343,349 @@ func (h *SSHWebSocketHandler) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
+	// Hand the shell over to a session that can outlive this WebSocket
+	grace, err := time.ParseDuration(h.config.SSH.DetachGracePeriod)
+	if err != nil {
+		grace = 0
+	}
+	// Recording policies override what the client asked for
+	recordMode, recordPolicy, err := recordingMode(h.db, host, ticket.Role)
+	if err != nil {
+		utils.LogError("Failed to load recording policies: %v", err)
+		connLog.Status = "failed"
+		connLog.ErrorMessage = "recording policies unavailable: " + err.Error()
+		h.db.Save(connLog)
+		writeJSON(gin.H{"type": "error", "data": "Recording policies could not be checked, the session was not started"})
+		return
+	}
+	// Guardrails likewise: a session whose command policies cannot be read does not run unguarded
+	guardRules, err := commandRules(h.db, host, ticket.Role)
+	if err != nil {
+		utils.LogError("Failed to load command policies: %v", err)
+		connLog.Status = "failed"
+		connLog.ErrorMessage = "command policies unavailable: " + err.Error()
+		h.db.Save(connLog)
+		writeJSON(gin.H{"type": "error", "data": "Command policies could not be checked, the session was not started"})
+		return
+	}
+	record := recordMode == models.RecordingAlways || (recordMode == models.RecordingUserChoice && c.Query("record") == "true")
+
+	var recordFile, indexFile io.WriteCloser
+	var recordKey string
+	if record {
+		var err error
+		recordKey = fmt.Sprintf("%s%d-%d-%d%s", RecordingPrefix, userID, host.ID, time.Now().UnixNano(), asciicast.CompressedExt)
+		recordFile, indexFile, err = createRecordingObjects(h.store, recordKey)
+		if err != nil {
+			if recordMode == models.RecordingAlways {
+				// A session that must be recorded does not run unrecorded
+				connLog.Status = "failed"
+				connLog.ErrorMessage = "recording required but unavailable: " + err.Error()
+				h.db.Save(connLog)
+				writeJSON(gin.H{"type": "error", "data": "This session must be recorded, but the recording could not be started"})
+				return
+			}
+			log.Printf("Failed to create recording file: %v", err)
+			record = false
+		}
+	}
+
+	sess := terminal.NewSession(terminal.Info{
+		UserID:          userID,
+		Username:        ticket.Username,
+		HostID:          host.ID,
+		HostName:        host.Name,
+		Host:            host.Host,
+		ClientIP:        c.ClientIP(),
+		ConnectionLogID: connLog.ID,
+		Recorded:        record,
+	}, sshClient, stdin, grace)
+	ownsClient = false
+
 	// Update connection log
 	connLog.Status = "success"
+	connLog.SessionID = sess.ID
 	h.db.Save(connLog)
 
-	// Send success message
-	writeJSON(gin.H{"type": "connected", "data": "Connected successfully"})
-
-	// Channel to signal completion
-	done := make(chan bool)
-
 	// Handle recording
-	record := c.Query("record") == "true"
 	var recording *models.TerminalRecording
-	var recordFile *os.File
-	if record {
-		recordingDir := "data/recordings"
-		os.MkdirAll(recordingDir, 0755)
-
-		fileName := fmt.Sprintf("%d-%d-%d.cast", userID, host.ID, time.Now().Unix())
-		filePath := filepath.Join(recordingDir, fileName)
-
-		f, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-		if err == nil {
-			recordFile = f
-			recording = &models.TerminalRecording{
-				UserID:    userID,
-				SSHHostID: host.ID,
-				Host:      host.Host,
-				Username:  host.Username,
-				FilePath:  filePath,
-				StartTime: time.Now(),
-			}
-			h.db.Create(recording)
+	var cast *asciicast.Writer
+	if recordFile != nil {
+		recording = &models.TerminalRecording{
+			UserID:    userID,
+			SSHHostID: host.ID,
+			Host:      host.Host,
+			Username:  host.Username,
+			FilePath:  recordKey,
+			StartTime: time.Now(),
+			Required:  recordMode == models.RecordingAlways,
 		}
-	}
+		h.db.Create(recording)
 
-	// Ping loop to keep connection alive
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				utils.LogError("SSH Ping Loop Panic: %v\nStack: %s", r, string(debug.Stack()))
-			}
-		}()
-		ticker := time.NewTicker(20 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				if err := writeParams(websocket.PingMessage, []byte{}); err != nil {
-					return
-				}
-			case <-done:
-				return
+		cols, rows := 80, 24
+		if pendingResize != nil {
+			cols, rows = pendingResize.Cols, pendingResize.Rows
+		}
+		cast, err = asciicast.NewCompressedWriter(recordFile, indexFile, asciicast.Header{
+			Width:     cols,
+			Height:    rows,
+			Timestamp: recording.StartTime.Unix(),
+			Title:     fmt.Sprintf("%s@%s (%s)", host.Username, host.Host, host.Name),
+			Env:       map[string]string{"TERM": "xterm-256color"},
+		})
+		if err != nil {
+			log.Printf("Failed to write recording header: %v", err)
+		} else {
+			sess.AddOutputTap(cast.Output)
+			sess.AddResizeTap(func(rows, cols int) { cast.Resize(cols, rows) })
+			// Keystrokes include passwords typed at prompts, so only a policy can turn this on
+			if recordPolicy != nil && recordPolicy.RecordInput {
+				sess.AddInputTap(cast.Input)
 			}
 		}
-	}()
+	}
 
-	// Read from SSH stdout and send to WebSocket
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				utils.LogError("SSH Stdout Loop Panic: %v\nStack: %s", r, string(debug.Stack()))
-			}
-		}()
-		buf := make([]byte, 1024)
-		start := time.Now()
-		for {
-			n, err := stdout.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					log.Printf("Error reading from stdout: %v", err)
+	// Extract the commands run in the session for the command audit trail
+	audits := &commandAuditWriter{db: h.db}
+	commands := cmdaudit.NewTracker(func(cmd cmdaudit.Command) {
+		audits.add(&models.CommandAudit{
+			ConnectionLogID: connLog.ID,
+			UserID:          userID,
+			SSHHostID:       &host.ID,
+			SessionID:       sess.ID,
+			Command:         cmd.Command,
+			WorkingDir:      cmd.WorkingDir,
+			ExitCode:        cmd.ExitCode,
+			Source:          cmd.Source,
+			StartedAt:       cmd.StartedAt,
+			FinishedAt:      cmd.FinishedAt,
+		})
+	})
+	sess.AddOutputTap(commands.Output)
+	sess.AddInputTap(commands.Input)
+
+	// Guardrails: dangerous commands are checked before Enter reaches the shell
+	if guard := newCommandGuard(h.db, host, guardRules, connLog.ID, sess, commands.Current); guard != nil {
+		sess.SetInputFilter(guard)
+	}
+
+	// Finalize logs and recording once the session ends, which may be long after this handler returns
+	closeHops = false
+	sess.OnClose(func(exit terminal.ExitStatus) {
+		reason := exit.Reason
+		commands.Close()
+		if recordFile != nil {
+			if cast != nil {
+				if err := cast.Close(); err != nil {
+					utils.LogError("Recording %s: %v", recordKey, err)
 				}
-				done <- true
-				return
 			}
-			if n > 0 {
-				data := buf[:n]
-				if recordFile != nil {
-					// Store as [time_offset, "o", "data"]
-					offset := time.Since(start).Seconds()
-					entry, _ := json.Marshal([]interface{}{offset, "o", string(data)})
-					recordFile.Write(entry)
-					recordFile.WriteString("\n")
-				}
-				if err := writeParams(websocket.TextMessage, data); err != nil {
-					log.Printf("Error writing to WebSocket: %v", err)
-					done <- true
-					return
+			end := time.Now()
+			go func(recording models.TerminalRecording) {
+				// Closing uploads the recording when it goes to object storage, which takes a while
+				for _, f := range []io.Closer{recordFile, indexFile} {
+					if err := f.Close(); err != nil {
+						utils.LogError("Recording %s: %v", recordKey, err)
+					}
 				}
-			}
+				recording.EndTime = &end
+				recording.Duration = int(end.Sub(recording.StartTime).Seconds())
+				completeRecording(h.db, h.store, h.keys.Signing(), recording)
+			}(*recording)
+		}
+
+		now := time.Now()
+		if reason == terminal.ReasonDetachTimeout {
+			h.logSessionEvent(connLog.ID, "expire", "", reason)
+		}
+		connLog.DisconnectedAt = &now
+		connLog.Duration = int(now.Sub(connLog.ConnectedAt).Seconds())
+		connLog.Status = "disconnected"
+		connLog.ExitCode = exit.Code
+		connLog.ExitSignal = exit.Signal
+		connLog.Reason = reason
+		if sess.Killed() {
+			connLog.Status = "terminated"
+			connLog.ErrorMessage = reason
+		}
+		h.db.Save(connLog)
+		closeHopLogs(h.db, hopLogs, now)
+
+		log.Printf("SSH session %s closed for user %d, host %s: %s", sess.ID, userID, host.Host, exit)
+	})
+
+	// Audit every file moved with rz/sz
+	sess.OnTransfer(func(file terminal.TransferFile) {
+		recordAudit(h.db, &models.AuditLog{
+			UserID:          userID,
+			SSHHostID:       &host.ID,
+			ConnectionLogID: &connLog.ID,
+			Action:          "zmodem_transfer",
+			Detail: fmt.Sprintf("direction=%s file=%q size=%d transferred=%d status=%s host=%s",
+				file.Direction, file.Name, file.Size, file.Bytes, file.Status, host.Host),
+			ClientIP: sess.ClientIP,
+		})
+	})
+
+	terminal.Sessions.Add(sess)
+	sess.Start(stdout, stderr)
+
+	// Send success message
+	banner := "Connected successfully"
+	switch {
+	case record && recordPolicy != nil:
+		banner += fmt.Sprintf(" - this session is being recorded (policy: %s)", recordPolicy.Name)
+	case record:
+		banner += " - this session is being recorded"
+	case recordPolicy != nil && c.Query("record") == "true":
+		banner += fmt.Sprintf(" - recording is disabled for this host (policy: %s)", recordPolicy.Name)
+	}
+	client.WriteJSON(gin.H{"type": "connected", "data": banner, "session_id": sess.ID, "recorded": record})
+
+	h.serveSession(client, sess, c.ClientIP())
+}
+
+// reattach connects a WebSocket to an existing session, replaying its scrollback first
+func (h *SSHWebSocketHandler) reattach(c *gin.Context, ticket utils.TicketData, sessionID string) {
+	sess, ok := terminal.Sessions.Get(sessionID)
+	if !ok || sess.UserID != ticket.UserID || fmt.Sprint(sess.HostID) != c.Param("hostId") {
+		utils.ErrorResponse(c, http.StatusNotFound, "session not found")
+		return
+	}
+
+	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade to WebSocket: %v", err)
+		return
+	}
+	defer ws.Close()
+
+	client := newWSClient(ws)
+	banner := "Reattached to session"
+	if sess.Recorded {
+		banner += " - this session is being recorded"
+	}
+	client.WriteJSON(gin.H{"type": "connected", "data": banner, "session_id": sess.ID, "reattached": true, "recorded": sess.Recorded})
+
+	sess.Attach(client)
+	h.logSessionEvent(sess.ConnectionLogID, "reattach", c.ClientIP(), "")
+	h.db.Model(&models.ConnectionLog{}).Where("id = ? AND status = ?", sess.ConnectionLogID, "detached").Update("status", "success")
+
+	h.readInput(client, sess, c.ClientIP())
+}
+
+// serveSession attaches a freshly opened session to its WebSocket and serves it
+func (h *SSHWebSocketHandler) serveSession(client *wsClient, sess *terminal.Session, clientIP string) {
+	sess.Attach(client)
+	h.readInput(client, sess, clientIP)
+}
+
+// readInput forwards WebSocket input to the session until the WebSocket goes away.
+// A dropped WebSocket detaches the session; it is only closed on explicit request, idle timeout or exit.
+func (h *SSHWebSocketHandler) readInput(client *wsClient, sess *terminal.Session, clientIP string) {
+	ws := client.ws
+
+	// Parse idle timeout
+	idleTimeout, err := time.ParseDuration(h.config.SSH.IdleTimeout)
+	if err != nil {
+		idleTimeout = 30 * time.Minute
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	defer client.markClosed()
+
+	go pingLoop(client, stop)
+
+	defer func() {
+		if r := recover(); r != nil {
+			utils.LogError("SSH Stdin Loop Panic: %v\nStack: %s", r, string(debug.Stack()))
+			sess.Close(terminal.ReasonExited)
 		}
 	}()
 
-	// Read from SSH stderr and send to WebSocket
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				utils.LogError("SSH Stderr Loop Panic: %v\nStack: %s", r, string(debug.Stack()))
+	for {
+		if idleTimeout > 0 {
+			ws.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+		msg, err := client.Read()
+		if err != nil {
+			select {
+			case <-sess.Done():
+				return
+			default:
 			}
-		}()
-		buf := make([]byte, 1024)
-		for {
-			n, err := stderr.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					log.Printf("Error reading from stderr: %v", err)
-				}
+
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				sess.Close(terminal.ReasonIdleTimeout)
 				return
 			}
-			if n > 0 {
-				if err := writeParams(websocket.TextMessage, buf[:n]); err != nil {
-					log.Printf("Error writing to WebSocket: %v", err)
-					return
-				}
+
+			log.Printf("Error reading from WebSocket: %v", err)
+			if sess.Detach(client) {
+				h.logSessionEvent(sess.ConnectionLogID, "detach", clientIP, err.Error())
+				h.db.Model(&models.ConnectionLog{}).Where("id = ?", sess.ConnectionLogID).Update("status", "detached")
 			}
+			return
 		}
-	}()
 
-	// Read from WebSocket and send to SSH stdin
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				utils.LogError("SSH Stdin Loop Panic: %v\nStack: %s", r, string(debug.Stack()))
-				done <- true // Ensure we close cleanup
-			}
-		}()
-		for {
-			if idleTimeout > 0 {
-				ws.SetReadDeadline(time.Now().Add(idleTimeout))
+		switch msg.Type {
+		case "resize":
+			sess.Resize(msg.Resize.Rows, msg.Resize.Cols)
+		case "input":
+			sess.Input(msg.Input)
+		case "guard_confirm":
+			// The user answered a command policy confirmation
+			var answer struct {
+				ID      string `json:"id"`
+				Approve bool   `json:"approve"`
 			}
-			messageType, message, err := ws.ReadMessage()
-			if err != nil {
-				log.Printf("Error reading from WebSocket: %v", err)
-				done <- true
-				return
+			dataBytes, _ := json.Marshal(msg.Msg.Data)
+			if err := json.Unmarshal(dataBytes, &answer); err == nil {
+				sess.ResolveInput(answer.ID, answer.Approve, "")
 			}
+		case "zmodem":
+			sess.TransferInput(client, msg.Input)
+		case "zmodem_cancel":
+			sess.CancelTransfer()
+		case "zmodem_end":
+			sess.EndTransfer()
+		case "close":
+			// The user closed the terminal, end the session instead of detaching
+			sess.Close(terminal.ReasonClosedByUser)
+			return
+		}
+	}
+}
 
-			if messageType == websocket.TextMessage {
-				// Try to parse as JSON message
-				var wsMsg WSMessage
-				if err := json.Unmarshal(message, &wsMsg); err == nil {
-					// Handle structured messages
-					switch wsMsg.Type {
-					case "resize":
-						var resizeData ResizeData
-						dataBytes, _ := json.Marshal(wsMsg.Data)
-						if err := json.Unmarshal(dataBytes, &resizeData); err == nil {
-							sshClient.Resize(resizeData.Rows, resizeData.Cols)
-						}
-					case "input":
-						if data, ok := wsMsg.Data.(string); ok {
-							stdin.Write([]byte(data))
-						}
-					}
-				} else {
-					// Handle plain text input
-					stdin.Write(message)
-				}
-			} else if messageType == websocket.PongMessage {
-				// Pong received, reset deadline (handled by SetReadDeadline above implicitly on next read)
-				// Actually, ReadMessage handles Ping/Pong control messages mostly automatically,
-				// but we need to ensure our idle timeout is reset.
-				// Since we call SetReadDeadline before ReadMessage, any message including Pong will allow the loop to continue.
-			}
+// pingLoop keeps a WebSocket alive until stop is closed
+func pingLoop(client *wsClient, stop <-chan struct{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			utils.LogError("SSH Ping Loop Panic: %v\nStack: %s", r, string(debug.Stack()))
 		}
 	}()
-
-	// Wait for completion
-	<-done
-
-	// Finalize recording
-	if recordFile != nil {
-		recordFile.Close()
-		if recording != nil {
-			now := time.Now()
-			recording.EndTime = &now
-			recording.Duration = int(now.Sub(recording.StartTime).Seconds())
-			h.db.Save(recording)
+	ticker := time.NewTicker(20 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := client.Ping(); err != nil {
+				return
+			}
+		case <-stop:
+			return
 		}
 	}
+}
 
-	// Update connection log
-	now := time.Now()
-	connLog.DisconnectedAt = &now
-	connLog.Duration = int(now.Sub(connLog.ConnectedAt).Seconds())
-	connLog.Status = "disconnected"
-	h.db.Save(connLog)
-
-	log.Printf("SSH session closed for user %d, host %s", userID, host.Host)
+// logSessionEvent appends a detach/reattach style event to a connection log
+func (h *SSHWebSocketHandler) logSessionEvent(connLogID uint, event, clientIP, detail string) {
+	h.db.Create(&models.ConnectionLogEvent{
+		ConnectionLogID: connLogID,
+		Event:           event,
+		ClientIP:        clientIP,
+		Detail:          detail,
+	})
 }
@@ -6,12 +6,14 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ihxw/termiscope/internal/config"
 	"github.com/ihxw/termiscope/internal/middleware"
 	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/storage"
 	"github.com/ihxw/termiscope/internal/utils"
 	"gorm.io/gorm"
 )
@@ -19,16 +21,22 @@ import (
 type SystemHandler struct {
 	db     *gorm.DB
 	config *config.Config
+	store  storage.Storage // Where backups are kept
 }
 
-func NewSystemHandler(db *gorm.DB, cfg *config.Config) *SystemHandler {
+func NewSystemHandler(db *gorm.DB, cfg *config.Config, store storage.Storage) *SystemHandler {
 	return &SystemHandler{
 		db:     db,
 		config: cfg,
+		store:  store,
 	}
 }
 
-// Backup handles database backup download
+// BackupPrefix is the storage key prefix of database backups
+const BackupPrefix = "backups/"
+
+// Backup creates a database backup, keeps it in storage and downloads it.
+// With download=false only the stored backup is reported.
 func (h *SystemHandler) Backup(c *gin.Context) {
 	dbPath := h.config.Database.Path
 	password := c.Query("password")
@@ -65,11 +73,82 @@ func (h *SystemHandler) Backup(c *gin.Context) {
 		downloadName = fmt.Sprintf("termiscope_backup_enc_%s.db", time.Now().Format("20060102_150405"))
 	}
 
-	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", downloadName))
-	c.Header("Content-Type", "application/octet-stream")
-	c.File(finalFile)
+	f, err := os.Open(finalFile)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to read backup: "+err.Error())
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to read backup: "+err.Error())
+		return
+	}
+	key := BackupPrefix + downloadName
+	if err := h.store.Put(key, f, info.Size()); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to store backup: "+err.Error())
+		return
+	}
+
+	if c.Query("download") == "false" {
+		utils.SuccessResponse(c, http.StatusOK, gin.H{"name": downloadName, "size": info.Size()})
+		return
+	}
+	h.sendBackup(c, downloadName)
+}
+
+// ListBackups lists the stored backups, newest first
+func (h *SystemHandler) ListBackups(c *gin.Context) {
+	objects, err := h.store.List(BackupPrefix)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to list backups: "+err.Error())
+		return
+	}
+
+	backups := make([]gin.H, 0, len(objects))
+	for i := len(objects) - 1; i >= 0; i-- {
+		backups = append(backups, gin.H{
+			"name":     strings.TrimPrefix(objects[i].Key, BackupPrefix),
+			"size":     objects[i].Size,
+			"mod_time": objects[i].ModTime,
+		})
+	}
+	utils.SuccessResponse(c, http.StatusOK, backups)
+}
+
+// DownloadBackup downloads a stored backup
+func (h *SystemHandler) DownloadBackup(c *gin.Context) {
+	h.sendBackup(c, c.Param("name"))
+}
+
+// DeleteBackup removes a stored backup
+func (h *SystemHandler) DeleteBackup(c *gin.Context) {
+	name := c.Param("name")
+	if _, err := h.store.Stat(BackupPrefix + name); err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "backup not found")
+		return
+	}
+	if err := h.store.Delete(BackupPrefix + name); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to delete backup: "+err.Error())
+		return
+	}
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "backup deleted successfully"})
+}
+
+// sendBackup streams a stored backup to the browser
+func (h *SystemHandler) sendBackup(c *gin.Context, name string) {
+	obj, err := h.store.Open(BackupPrefix + name)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "backup not found")
+		return
+	}
+	defer obj.Close()
+
+	c.DataFromReader(http.StatusOK, obj.Info().Size, "application/octet-stream", obj, map[string]string{
+		"Content-Description":       "File Transfer",
+		"Content-Transfer-Encoding": "binary",
+		"Content-Disposition":       fmt.Sprintf("attachment; filename=%s", name),
+	})
 }
 
 // Restore handles database restoration from uploaded file
@@ -167,13 +246,23 @@ func (h *SystemHandler) GetSettings(c *gin.Context) {
 	var configs []models.SystemConfig
 	h.db.Where("config_key LIKE ? OR config_key LIKE ? OR config_key = ?", "smtp_%", "telegram_%", "notification_template").Find(&configs)
 
+	rules := h.config.RecordingRules()
 	settings := gin.H{
 		"ssh_timeout":              h.config.SSH.Timeout,
 		"idle_timeout":             h.config.SSH.IdleTimeout,
+		"detach_grace_period":      h.config.SSH.DetachGracePeriod,
 		"max_connections_per_user": h.config.SSH.MaxConnectionsPerUser,
 		"login_rate_limit":         h.config.Security.LoginRateLimit,
 		"access_expiration":        h.config.Security.AccessExpiration,
 		"refresh_expiration":       h.config.Security.RefreshExpiration,
+		"proxy_type":               h.config.SSH.ProxyType,
+		"proxy_host":               h.config.SSH.ProxyHost,
+		"proxy_port":               h.config.SSH.ProxyPort,
+		"proxy_username":           h.config.SSH.ProxyUsername,
+		"proxy_password_set":       h.config.SSH.ProxyPasswordEncrypted != "",
+		"recording_max_age_days":   rules.MaxAgeDays,
+		"recording_max_total_mb":   rules.MaxTotalSizeMB,
+		"recording_user_quota_mb":  rules.UserQuotaMB,
 	}
 
 	for _, cfg := range configs {
@@ -187,6 +276,7 @@ func (h *SystemHandler) GetSettings(c *gin.Context) {
 type UpdateSettingsRequest struct {
 	SSHTimeout            string `json:"ssh_timeout" binding:"required"`
 	IdleTimeout           string `json:"idle_timeout" binding:"required"`
+	DetachGracePeriod     string `json:"detach_grace_period"` // Optional, empty keeps the current value
 	MaxConnectionsPerUser int    `json:"max_connections_per_user" binding:"required"`
 	LoginRateLimit        int    `json:"login_rate_limit" binding:"required"`
 	AccessExpiration      string `json:"access_expiration" binding:"required"`
@@ -201,6 +291,16 @@ type UpdateSettingsRequest struct {
 	TelegramBotToken     string `json:"telegram_bot_token"`
 	TelegramChatID       string `json:"telegram_chat_id"`
 	NotificationTemplate string `json:"notification_template"`
+	// Global Outbound Proxy (Optional, empty proxy_type keeps the current setting)
+	ProxyType     string `json:"proxy_type" binding:"omitempty,oneof=none socks5 http"`
+	ProxyHost     string `json:"proxy_host"`
+	ProxyPort     int    `json:"proxy_port"`
+	ProxyUsername string `json:"proxy_username"`
+	ProxyPassword string `json:"proxy_password"` // Empty keeps the current password
+	// Recording retention (Optional, omitted keeps the current value, 0 disables)
+	RecordingMaxAgeDays  *int `json:"recording_max_age_days" binding:"omitempty,min=0"`
+	RecordingMaxTotalMB  *int `json:"recording_max_total_mb" binding:"omitempty,min=0"`
+	RecordingUserQuotaMB *int `json:"recording_user_quota_mb" binding:"omitempty,min=0"`
 }
 
 // Global rate limiter reference for dynamic updates
@@ -231,6 +331,26 @@ func (h *SystemHandler) UpdateSettings(c *gin.Context) {
 		utils.ErrorResponse(c, http.StatusBadRequest, "invalid idle_timeout format (e.g. 30m)")
 		return
 	}
+	if req.DetachGracePeriod != "" {
+		if _, err := time.ParseDuration(req.DetachGracePeriod); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "invalid detach_grace_period format (e.g. 15m, 0 to disable)")
+			return
+		}
+	}
+	if err := validateProxySettings(req.ProxyType, req.ProxyHost, req.ProxyPort); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	proxyPasswordEncrypted := h.config.SSH.ProxyPasswordEncrypted
+	if req.ProxyPassword != "" {
+		encrypted, err := utils.EncryptAES(req.ProxyPassword, h.config.Security.EncryptionKey)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, "failed to encrypt proxy password")
+			return
+		}
+		proxyPasswordEncrypted = encrypted
+	}
 
 	// Update DB (Transaction)
 	err := h.db.Transaction(func(tx *gorm.DB) error {
@@ -252,6 +372,25 @@ func (h *SystemHandler) UpdateSettings(c *gin.Context) {
 			"telegram_chat_id":      req.TelegramChatID,
 			"notification_template": req.NotificationTemplate,
 		}
+		if req.DetachGracePeriod != "" {
+			updates["ssh.detach_grace_period"] = req.DetachGracePeriod
+		}
+		if req.RecordingMaxAgeDays != nil {
+			updates["recording.max_age_days"] = fmt.Sprintf("%d", *req.RecordingMaxAgeDays)
+		}
+		if req.RecordingMaxTotalMB != nil {
+			updates["recording.max_total_size_mb"] = fmt.Sprintf("%d", *req.RecordingMaxTotalMB)
+		}
+		if req.RecordingUserQuotaMB != nil {
+			updates["recording.user_quota_mb"] = fmt.Sprintf("%d", *req.RecordingUserQuotaMB)
+		}
+		if req.ProxyType != "" {
+			updates["ssh.proxy_type"] = req.ProxyType
+			updates["ssh.proxy_host"] = req.ProxyHost
+			updates["ssh.proxy_port"] = fmt.Sprintf("%d", req.ProxyPort)
+			updates["ssh.proxy_username"] = req.ProxyUsername
+			updates["ssh.proxy_password"] = proxyPasswordEncrypted
+		}
 
 		for key, value := range updates {
 			// Upsert Logic
@@ -280,10 +419,31 @@ func (h *SystemHandler) UpdateSettings(c *gin.Context) {
 	// Update in-memory config
 	h.config.SSH.Timeout = req.SSHTimeout
 	h.config.SSH.IdleTimeout = req.IdleTimeout
+	if req.DetachGracePeriod != "" {
+		h.config.SSH.DetachGracePeriod = req.DetachGracePeriod
+	}
 	h.config.SSH.MaxConnectionsPerUser = req.MaxConnectionsPerUser
 	h.config.Security.LoginRateLimit = req.LoginRateLimit
 	h.config.Security.AccessExpiration = req.AccessExpiration
 	h.config.Security.RefreshExpiration = req.RefreshExpiration
+	rules := h.config.RecordingRules()
+	if req.RecordingMaxAgeDays != nil {
+		rules.MaxAgeDays = *req.RecordingMaxAgeDays
+	}
+	if req.RecordingMaxTotalMB != nil {
+		rules.MaxTotalSizeMB = *req.RecordingMaxTotalMB
+	}
+	if req.RecordingUserQuotaMB != nil {
+		rules.UserQuotaMB = *req.RecordingUserQuotaMB
+	}
+	h.config.SetRecordingRules(rules)
+	if req.ProxyType != "" {
+		h.config.SSH.ProxyType = req.ProxyType
+		h.config.SSH.ProxyHost = req.ProxyHost
+		h.config.SSH.ProxyPort = req.ProxyPort
+		h.config.SSH.ProxyUsername = req.ProxyUsername
+		h.config.SSH.ProxyPasswordEncrypted = proxyPasswordEncrypted
+	}
 
 	// Hot-reload rate limit if global limiter is set
 	if LoginRateLimiter != nil {
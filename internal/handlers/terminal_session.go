@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ihxw/termiscope/internal/middleware"
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/terminal"
+	"github.com/ihxw/termiscope/internal/utils"
+	"gorm.io/gorm"
+)
+
+// TerminalSessionHandler lists and ends the current user's running terminal sessions
+type TerminalSessionHandler struct {
+	db *gorm.DB
+}
+
+func NewTerminalSessionHandler(db *gorm.DB) *TerminalSessionHandler {
+	return &TerminalSessionHandler{db: db}
+}
+
+// List returns the user's sessions; ?detached=true only returns those that can be reattached
+func (h *TerminalSessionHandler) List(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	detachedOnly := c.Query("detached") == "true"
+
+	result := make([]terminal.Status, 0)
+	for _, sess := range terminal.Sessions.ListByUser(userID) {
+		status := sess.Status()
+		if detachedOnly && status.Attached {
+			continue
+		}
+		result = append(result, status)
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, result)
+}
+
+type CreateShareRequest struct {
+	Mode      string `json:"mode" binding:"required,oneof=read-only copilot"`
+	ExpiresIn int    `json:"expires_in" binding:"min=0"` // Seconds, 0 lasts as long as the session
+}
+
+// SessionDetail is a session with its shares, only shown to the owner
+type SessionDetail struct {
+	terminal.Status
+	Shares []terminal.Share `json:"shares"`
+}
+
+// ownedSession looks up one of the current user's sessions
+func (h *TerminalSessionHandler) ownedSession(c *gin.Context) (*terminal.Session, bool) {
+	sess, ok := terminal.Sessions.Get(c.Param("id"))
+	if !ok || sess.UserID != middleware.GetUserID(c) {
+		utils.ErrorResponse(c, http.StatusNotFound, "session not found")
+		return nil, false
+	}
+	return sess, true
+}
+
+// Get returns a session with its viewers and shares
+func (h *TerminalSessionHandler) Get(c *gin.Context) {
+	sess, ok := h.ownedSession(c)
+	if !ok {
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, SessionDetail{Status: sess.Status(), Shares: sess.Shares()})
+}
+
+// CreateShare mints a share token other users can join the session with
+func (h *TerminalSessionHandler) CreateShare(c *gin.Context) {
+	sess, ok := h.ownedSession(c)
+	if !ok {
+		return
+	}
+
+	var req CreateShareRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	share, err := sess.CreateShare(req.Mode, time.Duration(req.ExpiresIn)*time.Second)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusConflict, err.Error())
+		return
+	}
+
+	hostID := sess.HostID
+	connLogID := sess.ConnectionLogID
+	recordAudit(h.db, &models.AuditLog{
+		UserID:          sess.UserID,
+		SSHHostID:       &hostID,
+		ConnectionLogID: &connLogID,
+		Action:          "session_share",
+		Detail:          fmt.Sprintf("session=%s mode=%s", sess.ID, share.Mode),
+		ClientIP:        c.ClientIP(),
+	})
+
+	utils.SuccessResponse(c, http.StatusCreated, gin.H{
+		"share":   share,
+		"ws_path": "/api/ws/share/" + share.Token,
+	})
+}
+
+// RevokeShare invalidates a share and disconnects its viewers
+func (h *TerminalSessionHandler) RevokeShare(c *gin.Context) {
+	sess, ok := h.ownedSession(c)
+	if !ok {
+		return
+	}
+
+	if !sess.RevokeShare(c.Param("token")) {
+		utils.ErrorResponse(c, http.StatusNotFound, "share not found")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "share revoked successfully"})
+}
+
+// KickViewer disconnects a single viewer
+func (h *TerminalSessionHandler) KickViewer(c *gin.Context) {
+	sess, ok := h.ownedSession(c)
+	if !ok {
+		return
+	}
+
+	if !sess.KickViewer(c.Param("viewerId")) {
+		utils.ErrorResponse(c, http.StatusNotFound, "viewer not found")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "viewer removed successfully"})
+}
+
+// Terminate closes one of the user's sessions
+func (h *TerminalSessionHandler) Terminate(c *gin.Context) {
+	sess, ok := h.ownedSession(c)
+	if !ok {
+		return
+	}
+
+	sess.Close(terminal.ReasonClosedByUser)
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "session terminated successfully"})
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ihxw/termiscope/internal/config"
+	"github.com/ihxw/termiscope/internal/middleware"
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/ssh"
+	"github.com/ihxw/termiscope/internal/tunnel"
+	"github.com/ihxw/termiscope/internal/utils"
+	"gorm.io/gorm"
+)
+
+// TunnelHandler manages saved port forwards and runs them on the server
+type TunnelHandler struct {
+	db      *gorm.DB
+	config  *config.Config
+	manager *tunnel.Manager
+}
+
+func NewTunnelHandler(db *gorm.DB, cfg *config.Config) *TunnelHandler {
+	h := &TunnelHandler{
+		db:     db,
+		config: cfg,
+	}
+	h.manager = tunnel.NewManager(h.connect)
+	return h
+}
+
+type CreateTunnelRequest struct {
+	SSHHostID  uint   `json:"ssh_host_id" binding:"required"`
+	Name       string `json:"name" binding:"required,max=100"`
+	Type       string `json:"type" binding:"required,oneof=local remote dynamic"`
+	ListenHost string `json:"listen_host"`
+	ListenPort int    `json:"listen_port" binding:"required,min=1,max=65535"`
+	TargetHost string `json:"target_host"`
+	TargetPort int    `json:"target_port" binding:"omitempty,min=1,max=65535"`
+	AutoStart  bool   `json:"auto_start"`
+	Start      bool   `json:"start"` // Start immediately after creation
+}
+
+// TunnelResponse is a saved tunnel with its live status
+type TunnelResponse struct {
+	models.Tunnel
+	Status tunnel.Status `json:"status"`
+}
+
+// connect opens an SSH connection for a tunnel using the owner's saved host
+func (h *TunnelHandler) connect(t *models.Tunnel) (*ssh.SSHClient, error) {
+	var host models.SSHHost
+	if err := h.db.Where("id = ? AND user_id = ?", t.SSHHostID, t.UserID).First(&host).Error; err != nil {
+		return nil, fmt.Errorf("host not found")
+	}
+	if t.Type == "remote" {
+		// Also stops remote tunnels of users who are no longer administrators
+		var owner models.User
+		if err := h.db.First(&owner, t.UserID).Error; err != nil || !owner.IsAdmin() {
+			return nil, fmt.Errorf("remote tunnels are limited to administrators")
+		}
+	}
+
+	return connectHost(h.db, h.config, t.UserID, &host)
+}
+
+// RestoreAutoStart starts every tunnel marked auto_start, called once at server startup
+func (h *TunnelHandler) RestoreAutoStart() {
+	var tunnels []models.Tunnel
+	if err := h.db.Where("auto_start = ?", true).Find(&tunnels).Error; err != nil {
+		log.Printf("Warning: Failed to load auto-start tunnels: %v", err)
+		return
+	}
+
+	for _, t := range tunnels {
+		h.manager.Start(t)
+	}
+	if len(tunnels) > 0 {
+		log.Printf("Started %d auto-start tunnel(s)", len(tunnels))
+	}
+}
+
+// List returns the current user's tunnels with their status and byte counters
+func (h *TunnelHandler) List(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var tunnels []models.Tunnel
+	if err := h.db.Where("user_id = ?", userID).Preload("SSHHost").Order("id asc").Find(&tunnels).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to fetch tunnels")
+		return
+	}
+
+	result := make([]TunnelResponse, 0, len(tunnels))
+	for _, t := range tunnels {
+		result = append(result, TunnelResponse{Tunnel: t, Status: h.manager.Status(t.ID)})
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, result)
+}
+
+// Create saves a new tunnel and optionally starts it
+func (h *TunnelHandler) Create(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+
+	var req CreateTunnelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	if req.ListenHost == "" {
+		req.ListenHost = "127.0.0.1"
+	}
+	if req.Type != "dynamic" && (req.TargetHost == "" || req.TargetPort == 0) {
+		utils.ErrorResponse(c, http.StatusBadRequest, "target host and port are required")
+		return
+	}
+
+	// Remote tunnels make this server dial the target, which reaches its loopback and internal network
+	if req.Type == "remote" && middleware.GetRole(c) != "admin" {
+		utils.ErrorResponse(c, http.StatusForbidden, "only administrators may create remote tunnels")
+		return
+	}
+
+	// Local and dynamic tunnels listen on this server; only admins may expose them beyond loopback
+	if req.Type != "remote" && middleware.GetRole(c) != "admin" {
+		if ip := net.ParseIP(req.ListenHost); req.ListenHost != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			utils.ErrorResponse(c, http.StatusForbidden, "only loopback listen addresses are allowed")
+			return
+		}
+	}
+
+	var host models.SSHHost
+	if err := h.db.Where("id = ? AND user_id = ?", req.SSHHostID, userID).First(&host).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "host not found")
+		return
+	}
+
+	if req.Type != "remote" {
+		var count int64
+		h.db.Model(&models.Tunnel{}).
+			Where("type <> ? AND listen_host = ? AND listen_port = ?", "remote", req.ListenHost, req.ListenPort).
+			Count(&count)
+		if count > 0 {
+			utils.ErrorResponse(c, http.StatusConflict, "listen address is already used by another tunnel")
+			return
+		}
+	}
+
+	t := &models.Tunnel{
+		UserID:     userID,
+		SSHHostID:  host.ID,
+		Name:       req.Name,
+		Type:       req.Type,
+		ListenHost: req.ListenHost,
+		ListenPort: req.ListenPort,
+		TargetHost: req.TargetHost,
+		TargetPort: req.TargetPort,
+		AutoStart:  req.AutoStart,
+	}
+	if req.Type == "dynamic" {
+		t.TargetHost, t.TargetPort = "", 0
+	}
+
+	if err := h.db.Create(t).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to create tunnel")
+		return
+	}
+
+	if req.Start {
+		h.manager.Start(*t)
+	}
+
+	utils.SuccessResponse(c, http.StatusCreated, TunnelResponse{Tunnel: *t, Status: h.manager.Status(t.ID)})
+}
+
+// Delete stops and deletes a tunnel
+func (h *TunnelHandler) Delete(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	id := c.Param("id")
+
+	var t models.Tunnel
+	if err := h.db.Where("id = ? AND user_id = ?", id, userID).First(&t).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "tunnel not found")
+		return
+	}
+
+	h.manager.Stop(t.ID)
+
+	if err := h.db.Delete(&t).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, "failed to delete tunnel")
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, gin.H{"message": "tunnel deleted successfully"})
+}
+
+// Start starts a saved tunnel
+func (h *TunnelHandler) Start(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	id := c.Param("id")
+
+	var t models.Tunnel
+	if err := h.db.Where("id = ? AND user_id = ?", id, userID).First(&t).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "tunnel not found")
+		return
+	}
+
+	h.manager.Start(t)
+
+	utils.SuccessResponse(c, http.StatusOK, TunnelResponse{Tunnel: t, Status: h.manager.Status(t.ID)})
+}
+
+// Stop stops a running tunnel
+func (h *TunnelHandler) Stop(c *gin.Context) {
+	userID := middleware.GetUserID(c)
+	id := c.Param("id")
+
+	var t models.Tunnel
+	if err := h.db.Where("id = ? AND user_id = ?", id, userID).First(&t).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "tunnel not found")
+		return
+	}
+
+	h.manager.Stop(t.ID)
+
+	utils.SuccessResponse(c, http.StatusOK, TunnelResponse{Tunnel: t, Status: h.manager.Status(t.ID)})
+}
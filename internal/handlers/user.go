@@ -33,6 +33,8 @@ type UpdateUserRequest struct {
 	Role        string `json:"role" binding:"omitempty,oneof=admin user"`
 	Status      string `json:"status" binding:"omitempty,oneof=active disabled"`
 	Password    string `json:"password" binding:"omitempty,min=8"`
+	// Concurrent connection limit: nil keeps it, 0 uses the global limit, -1 is unlimited
+	MaxConnections *int `json:"max_connections" binding:"omitempty,min=-1"`
 }
 
 // GetUsers returns a list of users (admin only)
@@ -131,6 +133,9 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 	if req.Status != "" {
 		user.Status = req.Status
 	}
+	if req.MaxConnections != nil {
+		user.MaxConnections = *req.MaxConnections
+	}
 	if req.Password != "" {
 		if err := user.SetPassword(req.Password); err != nil {
 			utils.ErrorResponse(c, http.StatusInternalServerError, "failed to hash password")
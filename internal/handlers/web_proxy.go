@@ -0,0 +1,480 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ihxw/termiscope/internal/config"
+	"github.com/ihxw/termiscope/internal/middleware"
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/ssh"
+	"github.com/ihxw/termiscope/internal/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// webProxyCookie carries the proxy session for follow-up requests (assets, XHR) of a proxied page
+	webProxyCookie = "termiscope_proxy"
+	// webProxyIdleTimeout closes SSH connections that have not served a request for a while
+	webProxyIdleTimeout = 5 * time.Minute
+	// webProxyTicketTTL is how long the link returned by Launch can be opened
+	webProxyTicketTTL = 30 * time.Second
+	// webProxySessionIdle ends a proxy session that has not been used for a while; every request renews it
+	webProxySessionIdle = 30 * time.Minute
+	// webProxySessionMaxAge ends a proxy session regardless of use, so access is rechecked by a new Launch
+	webProxySessionMaxAge = 12 * time.Hour
+)
+
+// WebProxyHandler proxies HTTP and WebSocket traffic to services listening on a host, over SSH.
+// Proxied services are served from their own origin (server.web_proxy_origin), so their scripts
+// cannot reach the tokens or API of the TermiScope UI. They authenticate with proxy-only
+// sessions bound to one host and port, never with the user's access token.
+type WebProxyHandler struct {
+	db     *gorm.DB
+	config *config.Config
+	origin *url.URL // nil when the web proxy is not configured
+
+	mu    sync.Mutex
+	conns map[string]*webProxyConn // keyed by user ID and host ID
+
+	tokensMu sync.Mutex
+	tokens   map[string]*webProxyToken // launch tickets and sessions, keyed by their random value
+}
+
+// webProxyToken grants one user access to one port of one host through the proxy.
+// A ticket is put in the link that opens the proxy and is exchanged for a session cookie.
+type webProxyToken struct {
+	userID  uint
+	hostID  uint
+	port    int
+	ticket  bool
+	created time.Time
+	expires time.Time
+}
+
+// webProxyConn is a cached SSH connection with its own HTTP transport.
+// Transports are never shared between hosts, since every target is "localhost" on its host.
+// The first request for a host dials; the ones arriving meanwhile wait for ready.
+type webProxyConn struct {
+	ready     chan struct{} // Closed once client and transport, or err, are set
+	client    *ssh.SSHClient
+	transport *http.Transport
+	err       error
+	lastUsed  time.Time // Guarded by WebProxyHandler.mu
+}
+
+func NewWebProxyHandler(db *gorm.DB, cfg *config.Config) *WebProxyHandler {
+	h := &WebProxyHandler{
+		db:     db,
+		config: cfg,
+		conns:  make(map[string]*webProxyConn),
+		tokens: make(map[string]*webProxyToken),
+	}
+	if cfg.Server.WebProxyOrigin != "" {
+		origin, err := url.Parse(strings.TrimSuffix(cfg.Server.WebProxyOrigin, "/"))
+		if err != nil || (origin.Scheme != "http" && origin.Scheme != "https") || origin.Host == "" || origin.Path != "" {
+			log.Printf("Web proxy disabled: invalid server.web_proxy_origin %q", cfg.Server.WebProxyOrigin)
+		} else {
+			h.origin = origin
+		}
+	}
+	go h.reapIdle()
+	return h
+}
+
+// isProxyOrigin reports whether a request was made to the web proxy origin
+func (h *WebProxyHandler) isProxyOrigin(r *http.Request) bool {
+	return h.origin != nil && strings.EqualFold(r.Host, h.origin.Host)
+}
+
+// SeparateOrigin keeps the web proxy and the UI apart: on the proxy origin only /proxy/ is
+// served, and /proxy/ is never served on the UI origin
+func (h *WebProxyHandler) SeparateOrigin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.isProxyOrigin(c.Request) != strings.HasPrefix(c.Request.URL.Path, "/proxy/") {
+			c.String(http.StatusNotFound, "Not Found")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// normalizeWebProxyPorts validates a comma-separated port allow-list
+func normalizeWebProxyPorts(value string) (string, error) {
+	var ports []string
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		port, err := strconv.Atoi(part)
+		if err != nil || port < 1 || port > 65535 {
+			return "", fmt.Errorf("invalid web proxy port: %s", part)
+		}
+		if !seen[port] {
+			seen[port] = true
+			ports = append(ports, strconv.Itoa(port))
+		}
+	}
+	return strings.Join(ports, ","), nil
+}
+
+// webProxyPortAllowed reports whether port is in the host's allow-list
+func webProxyPortAllowed(host *models.SSHHost, port int) bool {
+	for _, part := range strings.Split(host.WebProxyPorts, ",") {
+		if p, err := strconv.Atoi(strings.TrimSpace(part)); err == nil && p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// Launch handles POST /ssh-hosts/:id/web-proxy/:port and returns a link that opens the
+// service on the proxy origin. The link holds a one-time ticket that is only valid briefly.
+func (h *WebProxyHandler) Launch(c *gin.Context) {
+	if h.origin == nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "web proxy is not configured (server.web_proxy_origin)")
+		return
+	}
+	userID := middleware.GetUserID(c)
+
+	port, err := strconv.Atoi(c.Param("port"))
+	if err != nil || port < 1 || port > 65535 {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid port")
+		return
+	}
+
+	var host models.SSHHost
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&host).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "host not found")
+		return
+	}
+	if !webProxyPortAllowed(&host, port) {
+		utils.ErrorResponse(c, http.StatusForbidden, "port is not allowed for web proxy on this host")
+		return
+	}
+
+	ticket := h.issueToken(userID, host.ID, port, true, webProxyTicketTTL)
+	utils.SuccessResponse(c, http.StatusOK, gin.H{
+		"url": fmt.Sprintf("%s/proxy/%d/%d/?ticket=%s", h.origin, host.ID, port, ticket),
+	})
+}
+
+// issueToken stores a new ticket or session and returns its value
+func (h *WebProxyHandler) issueToken(userID, hostID uint, port int, ticket bool, ttl time.Duration) string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	value := hex.EncodeToString(b)
+
+	now := time.Now()
+	h.tokensMu.Lock()
+	h.tokens[value] = &webProxyToken{
+		userID:  userID,
+		hostID:  hostID,
+		port:    port,
+		ticket:  ticket,
+		created: now,
+		expires: now.Add(ttl),
+	}
+	h.tokensMu.Unlock()
+	return value
+}
+
+// redeemTicket consumes a launch ticket for the given target
+func (h *WebProxyHandler) redeemTicket(value string, hostID uint, port int) (*webProxyToken, bool) {
+	h.tokensMu.Lock()
+	defer h.tokensMu.Unlock()
+
+	t, ok := h.tokens[value]
+	if !ok || !t.ticket {
+		return nil, false
+	}
+	delete(h.tokens, value)
+	if time.Now().After(t.expires) || t.hostID != hostID || t.port != port {
+		return nil, false
+	}
+	return t, true
+}
+
+// session looks up a proxy session for the given target and renews it
+func (h *WebProxyHandler) session(value string, hostID uint, port int) (*webProxyToken, bool) {
+	h.tokensMu.Lock()
+	defer h.tokensMu.Unlock()
+
+	t, ok := h.tokens[value]
+	if !ok || t.ticket || t.hostID != hostID || t.port != port {
+		return nil, false
+	}
+	now := time.Now()
+	if now.After(t.expires) {
+		delete(h.tokens, value)
+		return nil, false
+	}
+	t.expires = now.Add(webProxySessionIdle)
+	if limit := t.created.Add(webProxySessionMaxAge); t.expires.After(limit) {
+		t.expires = limit
+	}
+	return t, true
+}
+
+// Proxy handles ANY /proxy/:hostId/:port/*path on the proxy origin
+func (h *WebProxyHandler) Proxy(c *gin.Context) {
+	if h.origin == nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "web proxy is not configured")
+		return
+	}
+
+	hostID, err := strconv.ParseUint(c.Param("hostId"), 10, 64)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid host")
+		return
+	}
+	port, err := strconv.Atoi(c.Param("port"))
+	if err != nil || port < 1 || port > 65535 {
+		utils.ErrorResponse(c, http.StatusBadRequest, "invalid port")
+		return
+	}
+	prefix := fmt.Sprintf("/proxy/%d/%d", hostID, port)
+
+	// First request of a page: exchange the ticket for a session cookie scoped to this target,
+	// then load the page again without the ticket in its URL
+	if ticket := c.Query("ticket"); ticket != "" {
+		t, ok := h.redeemTicket(ticket, uint(hostID), port)
+		if !ok {
+			utils.ErrorResponse(c, http.StatusUnauthorized, "invalid or expired web proxy link")
+			return
+		}
+		http.SetCookie(c.Writer, &http.Cookie{
+			Name:     webProxyCookie,
+			Value:    h.issueToken(t.userID, t.hostID, t.port, false, webProxySessionIdle),
+			Path:     prefix + "/",
+			HttpOnly: true,
+			Secure:   h.origin.Scheme == "https",
+			SameSite: http.SameSiteLaxMode,
+		})
+		location := *c.Request.URL
+		query := location.Query()
+		query.Del("ticket")
+		location.RawQuery = query.Encode()
+		c.Redirect(http.StatusFound, location.RequestURI())
+		return
+	}
+
+	value, _ := c.Cookie(webProxyCookie)
+	sess, ok := h.session(value, uint(hostID), port)
+	if !ok {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "web proxy session expired, open it again from TermiScope")
+		return
+	}
+	userID := sess.userID
+
+	var host models.SSHHost
+	if err := h.db.Where("id = ? AND user_id = ?", hostID, userID).First(&host).Error; err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, "host not found")
+		return
+	}
+
+	if !webProxyPortAllowed(&host, port) {
+		utils.ErrorResponse(c, http.StatusForbidden, "port is not allowed for web proxy on this host")
+		return
+	}
+
+	transport, err := h.getTransport(userID, &host, c.ClientIP())
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	targetHost := net.JoinHostPort("localhost", strconv.Itoa(port))
+	proxy := &httputil.ReverseProxy{
+		Transport: transport,
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL.Scheme = "http"
+			r.Out.URL.Host = targetHost
+			r.Out.Host = targetHost
+			r.Out.URL.Path = "/" + strings.TrimPrefix(c.Param("path"), "/")
+			r.Out.URL.RawPath = ""
+
+			// Never leak the proxy session to the proxied service
+			removeCookie(r.Out, webProxyCookie)
+
+			r.SetXForwarded()
+			r.Out.Header.Set("X-Forwarded-Prefix", prefix)
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			if location := resp.Header.Get("Location"); location != "" {
+				resp.Header.Set("Location", rewriteLocation(location, prefix, port))
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Web proxy %s: %v", prefix, err)
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("Bad Gateway: " + err.Error()))
+		},
+	}
+
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
+
+// getTransport returns the cached transport for a host, connecting over SSH if needed.
+// The lock is only held to look the connection up: dialing one host holds up nobody else.
+func (h *WebProxyHandler) getTransport(userID uint, host *models.SSHHost, clientIP string) (*http.Transport, error) {
+	key := fmt.Sprintf("%d:%d", userID, host.ID)
+
+	h.mu.Lock()
+	conn, ok := h.conns[key]
+	if !ok {
+		conn = &webProxyConn{ready: make(chan struct{})}
+		h.conns[key] = conn
+	}
+	conn.lastUsed = time.Now()
+	h.mu.Unlock()
+
+	if !ok {
+		h.dial(key, conn, userID, host, clientIP)
+	}
+	<-conn.ready
+	return conn.transport, conn.err
+}
+
+// dial connects conn and makes it ready. A failed connection is forgotten so the next
+// request tries again; a live one is evicted when its SSH connection closes.
+func (h *WebProxyHandler) dial(key string, conn *webProxyConn, userID uint, host *models.SSHHost, clientIP string) {
+	defer close(conn.ready)
+
+	sshClient, err := connectHost(h.db, h.config, userID, host)
+	if err != nil {
+		conn.err = err
+		h.evict(key, conn)
+		return
+	}
+
+	hostID := host.ID
+	recordAudit(h.db, &models.AuditLog{
+		UserID:    userID,
+		SSHHostID: &hostID,
+		Action:    "web_proxy_connect",
+		Detail:    fmt.Sprintf("allowed ports: %s", host.WebProxyPorts),
+		ClientIP:  clientIP,
+	})
+
+	raw := sshClient.GetRawClient()
+	conn.client = sshClient
+	conn.transport = &http.Transport{
+		// Every dial opens a direct-tcpip channel to the address as seen from the host
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return raw.DialContext(ctx, network, addr)
+		},
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	sshClient.OnClose(func() {
+		h.evict(key, conn)
+		conn.transport.CloseIdleConnections()
+	})
+	// The only full Close: it runs once the connection drops or is closed by reapIdle
+	go func() {
+		raw.Wait()
+		sshClient.Close()
+	}()
+}
+
+// evict forgets conn, unless it was already replaced
+func (h *WebProxyHandler) evict(key string, conn *webProxyConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conns[key] == conn {
+		delete(h.conns, key)
+	}
+}
+
+// reapIdle closes connections that have been idle for longer than webProxyIdleTimeout
+func (h *WebProxyHandler) reapIdle() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		var idle []*webProxyConn
+		h.mu.Lock()
+		for key, conn := range h.conns {
+			select {
+			case <-conn.ready:
+			default:
+				continue // Still dialing
+			}
+			if conn.err == nil && time.Since(conn.lastUsed) > webProxyIdleTimeout {
+				delete(h.conns, key)
+				idle = append(idle, conn)
+			}
+		}
+		h.mu.Unlock()
+		// The connection's watcher finishes closing it, see dial
+		for _, conn := range idle {
+			conn.client.GetRawClient().Close()
+		}
+
+		now := time.Now()
+		h.tokensMu.Lock()
+		for value, t := range h.tokens {
+			if now.After(t.expires) {
+				delete(h.tokens, value)
+			}
+		}
+		h.tokensMu.Unlock()
+	}
+}
+
+// rewriteLocation maps redirects issued by the proxied service back under the proxy prefix
+func rewriteLocation(location, prefix string, port int) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+
+	if u.IsAbs() {
+		// Only rewrite redirects that point back at the service itself
+		hostname := u.Hostname()
+		if hostname != "localhost" && hostname != "127.0.0.1" && hostname != "::1" {
+			return location
+		}
+		if p := u.Port(); p != "" && p != strconv.Itoa(port) {
+			return location
+		}
+		u.Scheme, u.Host = "", ""
+	} else if !strings.HasPrefix(u.Path, "/") {
+		// Relative redirects already resolve under the prefix
+		return location
+	}
+
+	if !strings.HasPrefix(u.Path, prefix+"/") {
+		u.Path = prefix + u.Path
+		u.RawPath = ""
+	}
+	return u.String()
+}
+
+// removeCookie drops one cookie from a request while keeping the rest
+func removeCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			r.AddCookie(cookie)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/ihxw/termiscope/internal/terminal"
+)
+
+// Terminal WebSocket subprotocols. Clients that offer neither get the JSON protocol.
+const (
+	// SubprotocolBinary frames every message as [1 byte type][payload]
+	SubprotocolBinary = "termiscope.v1"
+	// SubprotocolJSON is the original protocol: raw text output and JSON WSMessage input
+	SubprotocolJSON = "termiscope.json"
+)
+
+// Binary frame types (termiscope.v1)
+const (
+	FrameData    byte = 0x01 // Both ways: terminal output / keyboard input, raw bytes
+	FrameResize  byte = 0x02 // Client -> server: uint16 cols, uint16 rows (big endian)
+	FramePing    byte = 0x03 // Both ways: opaque payload, answered with a pong carrying the same payload
+	FramePong    byte = 0x04 // Both ways: reply to a ping
+	FrameExit    byte = 0x05 // Server -> client: int32 exit code (-1 if unknown), uint8 signal length, signal, UTF-8 reason
+	FrameAck     byte = 0x06 // Client -> server: uint32 number of data bytes processed since the last ack
+	FrameControl byte = 0x07 // Both ways: a JSON WSMessage (auth prompts, errors, viewer events, ...)
+	FrameZmodem  byte = 0x08 // Both ways: raw ZMODEM bytes while a transfer runs
+)
+
+// wsWriteTimeout gives up on a write to a WebSocket that stopped reading
+const wsWriteTimeout = 10 * time.Second
+
+// flowWindow is how many output bytes may be unacknowledged before the output pump pauses
+const flowWindow = 256 * 1024
+
+// wsClient is a WebSocket attached to a terminal session, speaking either protocol
+type wsClient struct {
+	ws     *websocket.Conn
+	mu     sync.Mutex // Serializes writes to ws
+	binary bool
+
+	carry []byte // JSON protocol: incomplete UTF-8 sequence held back from the last output
+
+	flowMu   sync.Mutex
+	flowCond *sync.Cond
+	unacked  int
+	closed   bool
+	latency  time.Duration
+}
+
+func newWSClient(ws *websocket.Conn) *wsClient {
+	c := &wsClient{
+		ws:     ws,
+		binary: ws.Subprotocol() == SubprotocolBinary,
+	}
+	c.flowCond = sync.NewCond(&c.flowMu)
+	return c
+}
+
+// inbound is a decoded client message
+type inbound struct {
+	Type   string // input, resize, or the type of a control message
+	Input  []byte
+	Resize ResizeData
+	Msg    WSMessage
+}
+
+// Read returns the next client message. Pings, pongs and acks are handled internally.
+func (c *wsClient) Read() (inbound, error) {
+	for {
+		messageType, message, err := c.ws.ReadMessage()
+		if err != nil {
+			return inbound{}, err
+		}
+
+		switch messageType {
+		case websocket.TextMessage:
+			if in, ok := decodeJSONMessage(message); ok {
+				return in, nil
+			}
+		case websocket.BinaryMessage:
+			if !c.binary || len(message) == 0 {
+				continue
+			}
+			if in, ok := c.decodeFrame(message[0], message[1:]); ok {
+				return in, nil
+			}
+		}
+	}
+}
+
+// decodeJSONMessage parses a JSON protocol message; anything that is not JSON is keyboard input
+func decodeJSONMessage(message []byte) (inbound, bool) {
+	var wsMsg WSMessage
+	if err := json.Unmarshal(message, &wsMsg); err != nil {
+		return inbound{Type: "input", Input: message}, true
+	}
+
+	switch wsMsg.Type {
+	case "input":
+		data, ok := wsMsg.Data.(string)
+		if !ok {
+			return inbound{}, false
+		}
+		return inbound{Type: "input", Input: []byte(data)}, true
+	case "resize":
+		var resizeData ResizeData
+		dataBytes, _ := json.Marshal(wsMsg.Data)
+		if err := json.Unmarshal(dataBytes, &resizeData); err != nil {
+			return inbound{}, false
+		}
+		return inbound{Type: "resize", Resize: resizeData}, true
+	case "zmodem":
+		// ZMODEM bytes are base64 encoded in the JSON protocol
+		data, ok := wsMsg.Data.(string)
+		if !ok {
+			return inbound{}, false
+		}
+		raw, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return inbound{}, false
+		}
+		return inbound{Type: "zmodem", Input: raw}, true
+	default:
+		return inbound{Type: wsMsg.Type, Msg: wsMsg}, true
+	}
+}
+
+// decodeFrame handles one binary frame, returning a message for the caller if there is one
+func (c *wsClient) decodeFrame(frameType byte, payload []byte) (inbound, bool) {
+	switch frameType {
+	case FrameData:
+		return inbound{Type: "input", Input: payload}, true
+	case FrameResize:
+		if len(payload) < 4 {
+			return inbound{}, false
+		}
+		return inbound{Type: "resize", Resize: ResizeData{
+			Cols: int(binary.BigEndian.Uint16(payload[0:2])),
+			Rows: int(binary.BigEndian.Uint16(payload[2:4])),
+		}}, true
+	case FramePing:
+		c.writeFrame(FramePong, payload)
+	case FramePong:
+		// Our pings carry the send time in Unix nanoseconds
+		if len(payload) == 8 {
+			sent := time.Unix(0, int64(binary.BigEndian.Uint64(payload)))
+			rtt := time.Since(sent)
+			c.flowMu.Lock()
+			c.latency = rtt
+			c.flowMu.Unlock()
+			c.Send("latency", rtt.Milliseconds())
+		}
+	case FrameAck:
+		if len(payload) == 4 {
+			c.ack(int(binary.BigEndian.Uint32(payload)))
+		}
+	case FrameControl:
+		return decodeJSONMessage(payload)
+	case FrameZmodem:
+		return inbound{Type: "zmodem", Input: payload}, true
+	}
+	return inbound{}, false
+}
+
+func (c *wsClient) writeFrame(frameType byte, payload []byte) error {
+	frame := make([]byte, 1+len(payload))
+	frame[0] = frameType
+	copy(frame[1:], payload)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return c.ws.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+// Output sends terminal output
+func (c *wsClient) Output(data []byte) error {
+	if c.binary {
+		c.flowMu.Lock()
+		c.unacked += len(data)
+		c.flowMu.Unlock()
+		return c.writeFrame(FrameData, data)
+	}
+
+	// Text frames must be valid UTF-8: hold back a sequence split across reads
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.carry) > 0 {
+		data = append(c.carry, data...)
+		c.carry = nil
+	}
+	if cut := incompleteUTF8Suffix(data); cut > 0 {
+		c.carry = append([]byte(nil), data[len(data)-cut:]...)
+		data = data[:len(data)-cut]
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	c.ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return c.ws.WriteMessage(websocket.TextMessage, data)
+}
+
+// TransferData sends raw ZMODEM bytes, which count against the flow control window like output
+func (c *wsClient) TransferData(data []byte) error {
+	if c.binary {
+		c.flowMu.Lock()
+		c.unacked += len(data)
+		c.flowMu.Unlock()
+		return c.writeFrame(FrameZmodem, data)
+	}
+	return c.WriteJSON(gin.H{"type": "zmodem", "data": data})
+}
+
+// incompleteUTF8Suffix returns the length of a truncated UTF-8 sequence at the end of data
+func incompleteUTF8Suffix(data []byte) int {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(data); i++ {
+		b := data[len(data)-i]
+		if !utf8.RuneStart(b) {
+			continue
+		}
+		// Found the lead byte: is its sequence complete?
+		if !utf8.FullRune(data[len(data)-i:]) {
+			return i
+		}
+		return 0
+	}
+	return 0
+}
+
+// WaitWritable blocks while the client has too much unacknowledged output.
+// Only the binary protocol acknowledges output; JSON clients never block.
+func (c *wsClient) WaitWritable() {
+	if !c.binary {
+		return
+	}
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+	for c.unacked >= flowWindow && !c.closed {
+		c.flowCond.Wait()
+	}
+}
+
+func (c *wsClient) ack(n int) {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+	c.unacked -= n
+	if c.unacked < 0 {
+		c.unacked = 0
+	}
+	c.flowCond.Broadcast()
+}
+
+// markClosed releases anything waiting on the flow control window
+func (c *wsClient) markClosed() {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+	c.closed = true
+	c.flowCond.Broadcast()
+}
+
+// WriteJSON sends a control message
+func (c *wsClient) WriteJSON(v interface{}) error {
+	if c.binary {
+		payload, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		return c.writeFrame(FrameControl, payload)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return c.ws.WriteJSON(v)
+}
+
+// Send sends a typed control message
+func (c *wsClient) Send(msgType string, data interface{}) error {
+	return c.WriteJSON(gin.H{"type": msgType, "data": data})
+}
+
+// Ping sends a WebSocket ping, plus a latency probe on the binary protocol
+func (c *wsClient) Ping() error {
+	c.mu.Lock()
+	c.ws.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	err := c.ws.WriteMessage(websocket.PingMessage, []byte{})
+	c.mu.Unlock()
+	if err != nil || !c.binary {
+		return err
+	}
+
+	payload := make([]byte, 8)
+	binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+	return c.writeFrame(FramePing, payload)
+}
+
+// Latency returns the last measured round trip time (binary protocol only)
+func (c *wsClient) Latency() time.Duration {
+	c.flowMu.Lock()
+	defer c.flowMu.Unlock()
+	return c.latency
+}
+
+// Close tells the browser why the session went away and closes the WebSocket
+func (c *wsClient) Close(reason string) {
+	c.Exit(terminal.ExitStatus{Reason: reason})
+}
+
+// Exit sends the final session status and closes the WebSocket
+func (c *wsClient) Exit(status terminal.ExitStatus) {
+	if c.binary {
+		c.writeFrame(FrameExit, exitPayload(status))
+	} else {
+		c.WriteJSON(gin.H{"type": "closed", "data": status.String(), "exit": status})
+	}
+	c.markClosed()
+	c.ws.Close()
+}
+
+// exitPayload encodes an exit frame
+func exitPayload(status terminal.ExitStatus) []byte {
+	code := -1
+	if status.Code != nil {
+		code = *status.Code
+	}
+	signal := status.Signal
+	if len(signal) > 255 {
+		signal = signal[:255]
+	}
+
+	payload := make([]byte, 5, 5+len(signal)+len(status.Reason))
+	binary.BigEndian.PutUint32(payload, uint32(int32(code)))
+	payload[4] = byte(len(signal))
+	payload = append(payload, signal...)
+	return append(payload, status.Reason...)
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+)
+
+// AuditLog records security-relevant actions taken during or around SSH sessions
+type AuditLog struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	UserID          uint      `gorm:"not null;index" json:"user_id"`
+	SSHHostID       *uint     `gorm:"index" json:"ssh_host_id"`
+	ConnectionLogID *uint     `gorm:"index" json:"connection_log_id,omitempty"`
+	Action          string    `gorm:"size:50;not null;index" json:"action"` // e.g. agent_sign
+	Detail          string    `gorm:"type:text" json:"detail"`
+	ClientIP        string    `gorm:"size:64" json:"client_ip"`
+	CreatedAt       time.Time `gorm:"index" json:"created_at"`
+
+	// Relations
+	User    User     `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	SSHHost *SSHHost `gorm:"foreignKey:SSHHostID" json:"ssh_host,omitempty"`
+}
+
+// TableName specifies the table name
+func (AuditLog) TableName() string {
+	return "audit_logs"
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// CommandAudit is a command run in a terminal session, extracted from its input and output
+type CommandAudit struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	ConnectionLogID uint       `gorm:"not null;index" json:"connection_log_id"`
+	UserID          uint       `gorm:"not null;index" json:"user_id"`
+	SSHHostID       *uint      `gorm:"index" json:"ssh_host_id"`
+	SessionID       string     `gorm:"size:64;index" json:"session_id"`
+	Command         string     `gorm:"type:text;not null" json:"command"`
+	WorkingDir      string     `gorm:"size:1024" json:"working_dir,omitempty"`
+	ExitCode        *int       `json:"exit_code,omitempty"`   // Only known with shell integration (OSC 133)
+	Source          string     `gorm:"size:20" json:"source"` // osc133 or prompt
+	StartedAt       time.Time  `gorm:"not null;index" json:"started_at"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+
+	// Relations
+	User    User     `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	SSHHost *SSHHost `gorm:"foreignKey:SSHHostID" json:"ssh_host,omitempty"`
+}
+
+// TableName specifies the table name
+func (CommandAudit) TableName() string {
+	return "command_audits"
+}
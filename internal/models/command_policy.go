@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// CommandPolicy is an admin-defined guardrail evaluated on command lines before they reach the shell
+type CommandPolicy struct {
+	ID          uint           `gorm:"primaryKey" json:"id"`
+	Name        string         `gorm:"size:100;not null" json:"name"`
+	Description string         `gorm:"type:text" json:"description"`
+	MatchType   string         `gorm:"size:10;not null;default:prefix" json:"match_type"` // prefix or regex
+	Pattern     string         `gorm:"size:500;not null" json:"pattern"`
+	Action      string         `gorm:"size:10;not null" json:"action"` // block, confirm or notify
+	Enabled     bool           `gorm:"default:true" json:"enabled"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+	PolicyScope                // Hosts and roles the policy applies to
+}
+
+// TableName specifies the table name
+func (CommandPolicy) TableName() string {
+	return "command_policies"
+}
@@ -10,22 +10,43 @@ type ConnectionLog struct {
 	ID             uint           `gorm:"primaryKey" json:"id"`
 	UserID         uint           `gorm:"not null;index" json:"user_id"`
 	SSHHostID      *uint          `gorm:"index" json:"ssh_host_id"`
+	ParentLogID    *uint          `gorm:"index" json:"parent_log_id,omitempty"`      // Set on jump host hops, points at the target session
+	SessionID      string         `gorm:"size:64;index" json:"session_id,omitempty"` // Terminal session, for detach/reattach
 	Host           string         `gorm:"size:255;not null" json:"host"`
 	Port           int            `gorm:"not null" json:"port"`
 	Username       string         `gorm:"size:100;not null" json:"username"`
-	Status         string         `gorm:"size:20;not null" json:"status"` // success, failed, disconnected
+	Status         string         `gorm:"size:20;not null" json:"status"` // success, failed, detached, disconnected, terminated
 	ErrorMessage   string         `gorm:"type:text" json:"error_message,omitempty"`
+	ExitCode       *int           `gorm:"index" json:"exit_code,omitempty"`       // Exit code of the remote shell, if it reported one
+	ExitSignal     string         `gorm:"size:20" json:"exit_signal,omitempty"`   // Signal that killed the remote shell
+	Reason         string         `gorm:"size:255;index" json:"reason,omitempty"` // Why the session ended, e.g. "idle timeout"
 	ConnectedAt    time.Time      `gorm:"not null" json:"connected_at"`
 	DisconnectedAt *time.Time     `json:"disconnected_at,omitempty"`
 	Duration       int            `json:"duration"` // in seconds
 	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
 
 	// Relations
-	User    User     `gorm:"foreignKey:UserID" json:"user,omitempty"`
-	SSHHost *SSHHost `gorm:"foreignKey:SSHHostID" json:"ssh_host,omitempty"`
+	User    User                 `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	SSHHost *SSHHost             `gorm:"foreignKey:SSHHostID" json:"ssh_host,omitempty"`
+	Events  []ConnectionLogEvent `gorm:"foreignKey:ConnectionLogID" json:"events,omitempty"`
 }
 
 // TableName specifies the table name
 func (ConnectionLog) TableName() string {
 	return "connection_logs"
 }
+
+// ConnectionLogEvent is something that happened during a connection, such as a detach or reattach
+type ConnectionLogEvent struct {
+	ID              uint      `gorm:"primaryKey" json:"id"`
+	ConnectionLogID uint      `gorm:"not null;index" json:"connection_log_id"`
+	Event           string    `gorm:"size:30;not null" json:"event"` // detach, reattach, expire, kill
+	ClientIP        string    `gorm:"size:45" json:"client_ip"`
+	Detail          string    `gorm:"type:text" json:"detail,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// TableName specifies the table name
+func (ConnectionLogEvent) TableName() string {
+	return "connection_log_events"
+}
@@ -0,0 +1,53 @@
+package models
+
+import "strings"
+
+// PolicyScope limits a policy to some hosts and users. Each field is a comma separated
+// list; an empty list matches everything.
+type PolicyScope struct {
+	HostGroups string `gorm:"size:255" json:"host_groups"` // Host group names
+	HostTags   string `gorm:"size:255" json:"host_tags"`   // Host tags, any one of them matches
+	Roles      string `gorm:"size:100" json:"roles"`       // User roles
+}
+
+// Covers reports whether the scope includes a host and a user role
+func (s PolicyScope) Covers(host SSHHost, role string) bool {
+	if groups := splitList(s.HostGroups); len(groups) > 0 && !containsFold(groups, host.GroupName) {
+		return false
+	}
+	if tags := splitList(s.HostTags); len(tags) > 0 {
+		found := false
+		for _, tag := range splitList(host.Tags) {
+			if containsFold(tags, tag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if roles := splitList(s.Roles); len(roles) > 0 && !containsFold(roles, role) {
+		return false
+	}
+	return true
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
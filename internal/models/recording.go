@@ -7,20 +7,56 @@ import (
 )
 
 type TerminalRecording struct {
-	ID        uint           `gorm:"primaryKey" json:"id"`
-	UserID    uint           `gorm:"index;not null" json:"user_id"`
-	SSHHostID uint           `gorm:"index;not null" json:"ssh_host_id"`
-	Host      string         `json:"host"`
-	Username  string         `json:"username"`
-	FilePath  string         `gorm:"size:255;not null" json:"file_path"`
-	Duration  int            `json:"duration"` // in seconds
-	StartTime time.Time      `json:"start_time"`
-	EndTime   *time.Time     `json:"end_time"`
-	CreatedAt time.Time      `json:"created_at"`
-	UpdatedAt time.Time      `json:"updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+	ID         uint           `gorm:"primaryKey" json:"id"`
+	UserID     uint           `gorm:"index;not null" json:"user_id"`
+	SSHHostID  uint           `gorm:"index;not null" json:"ssh_host_id"`
+	Host       string         `json:"host"`
+	Username   string         `json:"username"`
+	FilePath   string         `gorm:"size:255;not null" json:"file_path"` // Storage key, e.g. recordings/1-2-1700000000000000000.cast.gz
+	Duration   int            `json:"duration"`                           // in seconds
+	StartTime  time.Time      `json:"start_time"`
+	EndTime    *time.Time     `json:"end_time"`
+	IndexedAt  *time.Time     `json:"indexed_at"`                            // When the text was extracted for search
+	Size       int64          `json:"size"`                                  // Bytes in storage, set when the recording ends
+	LegalHold  bool           `gorm:"default:false;index" json:"legal_hold"` // Kept regardless of retention and deletion requests
+	HoldNote   string         `gorm:"size:255" json:"hold_note"`
+	Required   bool           `gorm:"default:false" json:"required"` // Made because a policy requires it; only administrators and retention remove it
+	Digest     string         `gorm:"size:64" json:"digest"`         // Signed hash chain over the finished recording, see package recsign
+	EventCount int            `json:"event_count"`
+	Signature  string         `gorm:"size:128" json:"signature"`
+	SigningKey string         `gorm:"size:64" json:"signing_key"`
+	SignedAt   *time.Time     `json:"signed_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 func (TerminalRecording) TableName() string {
 	return "terminal_recordings"
 }
+
+// RecordingText is a line of text from a terminal recording, kept for full-text search
+type RecordingText struct {
+	ID          uint    `gorm:"primaryKey" json:"id"`
+	RecordingID uint    `gorm:"not null;index" json:"recording_id"`
+	TimeOffset  float64 `gorm:"not null" json:"time_offset"` // Seconds from the start of the recording
+	Kind        string  `gorm:"size:1;not null" json:"kind"` // o for output, i for input
+	Text        string  `gorm:"type:text;not null" json:"text"`
+}
+
+func (RecordingText) TableName() string {
+	return "recording_texts"
+}
+
+// RecordingSigningKey is a public key whose recording signatures are trusted. The key in
+// use is added when the server starts, so signatures stay verifiable after it is replaced.
+type RecordingSigningKey struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	PublicKey string    `gorm:"size:64;uniqueIndex;not null" json:"public_key"` // Base64 Ed25519 key
+	Origin    string    `gorm:"size:20;not null" json:"origin"`                 // file, or legacy for the key derived from the encryption key
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (RecordingSigningKey) TableName() string {
+	return "recording_signing_keys"
+}
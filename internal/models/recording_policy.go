@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Recording modes
+const (
+	RecordingAlways     = "always"      // Sessions are recorded whatever the client asks for
+	RecordingNever      = "never"       // Sessions are never recorded
+	RecordingUserChoice = "user_choice" // The client decides with the record parameter
+)
+
+// RecordingPolicy decides server-side whether terminal sessions in its scope are recorded
+type RecordingPolicy struct {
+	ID          uint           `gorm:"primaryKey" json:"id"`
+	Name        string         `gorm:"size:100;not null" json:"name"`
+	Description string         `gorm:"type:text" json:"description"`
+	Mode        string         `gorm:"size:20;not null" json:"mode"`      // always, never or user_choice
+	RecordInput bool           `gorm:"default:false" json:"record_input"` // Also record keystrokes, including anything typed at password prompts
+	Enabled     bool           `gorm:"default:true" json:"enabled"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+	PolicyScope                // Hosts and roles the policy applies to
+}
+
+// TableName specifies the table name
+func (RecordingPolicy) TableName() string {
+	return "recording_policies"
+}
@@ -13,16 +13,28 @@ type SSHHost struct {
 	Host                string `gorm:"size:255;not null" json:"host"`
 	Port                int    `gorm:"default:22" json:"port"`
 	Username            string `gorm:"size:100;not null" json:"username"`
-	AuthType            string `gorm:"size:20;not null" json:"auth_type"` // password or key
-	Fingerprint         string `gorm:"size:255" json:"fingerprint"`       // SSH Host Key Fingerprint (TOFU)
+	AuthType            string `gorm:"size:20;not null" json:"auth_type"`  // password or key
+	Fingerprint         string `gorm:"size:255" json:"fingerprint"`        // SSH Host Key Fingerprint (TOFU)
+	JumpHostIDs         string `gorm:"size:255" json:"jump_host_ids"`      // Ordered, comma-separated bastion host IDs
+	ForwardAgent        bool   `gorm:"default:false" json:"forward_agent"` // Forward the user's server-side key agent
+	WebProxyPorts       string `gorm:"size:255" json:"web_proxy_ports"`    // Comma-separated remote ports reachable via /proxy
+	MaxConnections      int    `gorm:"default:0" json:"max_connections"`   // Concurrent terminal/SFTP connections, 0 is unlimited
 	PasswordEncrypted   string `gorm:"type:text" json:"-"`
 	PrivateKeyEncrypted string `gorm:"type:text" json:"-"`
-	GroupName           string `gorm:"size:50" json:"group_name"`
-	Tags                string `gorm:"size:255" json:"tags"`
-	MonitorEnabled      bool   `gorm:"default:false" json:"monitor_enabled"`
-	MonitorSecret       string `gorm:"size:64" json:"-"`
-	Description         string `gorm:"type:text" json:"description"`
-	SortOrder           int    `gorm:"default:0" json:"sort_order"`
+	PassphraseEncrypted string `gorm:"type:text" json:"-"`           // Private key passphrase
+	Certificate         string `gorm:"type:text" json:"certificate"` // OpenSSH user certificate (*-cert.pub)
+	// Outbound proxy: empty inherits the global setting, "none" dials directly
+	ProxyType              string `gorm:"size:20" json:"proxy_type"` // "", none, socks5, http
+	ProxyHost              string `gorm:"size:255" json:"proxy_host"`
+	ProxyPort              int    `json:"proxy_port"`
+	ProxyUsername          string `gorm:"size:100" json:"proxy_username"`
+	ProxyPasswordEncrypted string `gorm:"type:text" json:"-"`
+	GroupName              string `gorm:"size:50" json:"group_name"`
+	Tags                   string `gorm:"size:255" json:"tags"`
+	MonitorEnabled         bool   `gorm:"default:false" json:"monitor_enabled"`
+	MonitorSecret          string `gorm:"size:64" json:"-"`
+	Description            string `gorm:"type:text" json:"description"`
+	SortOrder              int    `gorm:"default:0" json:"sort_order"`
 	// Network Config
 	NetInterface string `json:"net_interface" gorm:"default:'auto'"` // Selected interface
 	NetResetDay  int    `json:"net_reset_day" gorm:"default:1"`      // Day of month to reset
@@ -57,8 +69,10 @@ type SSHHost struct {
 	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
 
 	// Transient fields (not stored in database)
-	Password   string `gorm:"-" json:"password,omitempty"`
-	PrivateKey string `gorm:"-" json:"private_key,omitempty"`
+	Password      string `gorm:"-" json:"password,omitempty"`
+	PrivateKey    string `gorm:"-" json:"private_key,omitempty"`
+	Passphrase    string `gorm:"-" json:"passphrase,omitempty"`
+	ProxyPassword string `gorm:"-" json:"proxy_password,omitempty"`
 }
 
 // TableName specifies the table name
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Tunnel is a saved port forward (ssh -L / -R / -D) over one of the user's SSH hosts
+type Tunnel struct {
+	ID         uint           `gorm:"primaryKey" json:"id"`
+	UserID     uint           `gorm:"not null;index" json:"user_id"`
+	SSHHostID  uint           `gorm:"not null;index" json:"ssh_host_id"`
+	Name       string         `gorm:"size:100;not null" json:"name"`
+	Type       string         `gorm:"size:20;not null" json:"type"` // local, remote, dynamic
+	ListenHost string         `gorm:"size:255;default:'127.0.0.1'" json:"listen_host"`
+	ListenPort int            `gorm:"not null" json:"listen_port"`
+	TargetHost string         `gorm:"size:255" json:"target_host"` // Unused for dynamic tunnels
+	TargetPort int            `json:"target_port"`
+	AutoStart  bool           `gorm:"default:false" json:"auto_start"` // Start when the server starts
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// Relations
+	SSHHost *SSHHost `gorm:"foreignKey:SSHHostID" json:"ssh_host,omitempty"`
+}
+
+// TableName specifies the table name
+func (Tunnel) TableName() string {
+	return "tunnels"
+}
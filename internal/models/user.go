@@ -15,6 +15,7 @@ type User struct {
 	DisplayName      string         `gorm:"size:100" json:"display_name"`
 	Role             string         `gorm:"size:20;default:user" json:"role"`     // admin or user
 	Status           string         `gorm:"size:20;default:active" json:"status"` // active or disabled
+	MaxConnections   int            `gorm:"default:0" json:"max_connections"`     // 0 uses the global limit, -1 is unlimited
 	TwoFactorEnabled bool           `gorm:"default:false" json:"two_factor_enabled"`
 	TwoFactorSecret  string         `gorm:"size:255" json:"-"`  // Encrypted TOTP secret
 	BackupCodes      string         `gorm:"type:text" json:"-"` // Encrypted backup codes (JSON array)
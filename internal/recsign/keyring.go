@@ -0,0 +1,113 @@
+package recsign
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// LoadKey reads the signing key from a PEM file (PKCS #8, as openssl writes it). If the
+// file does not exist a new random key is written to it; created reports that.
+func LoadKey(path string) (key ed25519.PrivateKey, created bool, err error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, err = createKey(path)
+		return key, err == nil, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, false, fmt.Errorf("%s: no PRIVATE KEY block", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", path, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, false, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return key, false, nil
+}
+
+func createKey(path string) (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return key, nil
+}
+
+// Keyring holds the key new recordings are signed with and the public keys whose
+// signatures are trusted, which include keys used before the current one
+type Keyring struct {
+	signing ed25519.PrivateKey
+	trusted map[string]ed25519.PublicKey
+}
+
+// NewKeyring returns a keyring that signs with signing and trusts it and the given keys
+func NewKeyring(signing ed25519.PrivateKey, trusted ...ed25519.PublicKey) *Keyring {
+	k := &Keyring{signing: signing, trusted: make(map[string]ed25519.PublicKey)}
+	for _, pub := range append(trusted, k.Public()) {
+		k.trusted[EncodeKey(pub)] = pub
+	}
+	return k
+}
+
+// Signing returns the key new recordings are signed with
+func (k *Keyring) Signing() ed25519.PrivateKey {
+	return k.signing
+}
+
+// Public returns the public half of the signing key
+func (k *Keyring) Public() ed25519.PublicKey {
+	return k.signing.Public().(ed25519.PublicKey)
+}
+
+// Verify checks a manifest against the trusted key it names, see Verify
+func (k *Keyring) Verify(m Manifest, src io.ReadSeeker) error {
+	pub, ok := k.trusted[m.PublicKey]
+	if !ok {
+		return ErrWrongKey
+	}
+	return Verify(pub, m, src)
+}
+
+// Trusted returns the base64 forms of the trusted public keys
+func (k *Keyring) Trusted() []string {
+	keys := make([]string, 0, len(k.trusted))
+	for encoded := range k.trusted {
+		keys = append(keys, encoded)
+	}
+	sort.Strings(keys)
+	return keys
+}
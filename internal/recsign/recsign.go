@@ -0,0 +1,111 @@
+// Package recsign makes terminal recordings tamper-evident. When a recording ends, a hash
+// chain over its lines is signed with the server's Ed25519 key together with who recorded
+// what and when. Anyone with the server's public key can check a recording later, online
+// or offline, without trusting the database it is listed in.
+package recsign
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ihxw/termiscope/internal/asciicast"
+)
+
+// Scheme names the chain and message format; it seeds the hash chain
+const Scheme = "termiscope-recording-v1"
+
+// Verification errors
+var (
+	ErrBadSignature = errors.New("signature does not match the manifest")
+	ErrWrongKey     = errors.New("recording was signed with a different key")
+	ErrModified     = errors.New("recording content does not match its signed digest")
+)
+
+// Manifest describes a signed recording. Everything but the signature is signed.
+type Manifest struct {
+	Scheme      string `json:"scheme"`
+	RecordingID uint   `json:"recording_id"`
+	UserID      uint   `json:"user_id"`
+	HostID      uint   `json:"host_id"`
+	StartTime   int64  `json:"start_time"` // Unix seconds
+	Events      int    `json:"events"`
+	Digest      string `json:"digest"`     // Hex SHA-256 of the last link of the chain
+	PublicKey   string `json:"public_key"` // Base64 Ed25519 key that made the signature
+	Signature   string `json:"signature"`  // Base64 Ed25519 signature
+}
+
+// message is the signed form of the manifest, one field per line in a fixed order
+func (m Manifest) message() []byte {
+	return []byte(fmt.Sprintf("%s\nrecording=%d\nuser=%d\nhost=%d\nstart=%d\nevents=%d\ndigest=%s\n",
+		m.Scheme, m.RecordingID, m.UserID, m.HostID, m.StartTime, m.Events, m.Digest))
+}
+
+// KeyFromSecret derives a key from a server secret. Recordings were signed with the key
+// derived from the encryption key before the signing key got a file of its own; it is
+// only needed to keep trusting those signatures.
+func KeyFromSecret(secret string) ed25519.PrivateKey {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(Scheme))
+	return ed25519.NewKeyFromSeed(mac.Sum(nil))
+}
+
+// EncodeKey returns the base64 form of a public key used in manifests
+func EncodeKey(pub ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(pub)
+}
+
+// ParseKey decodes a base64 public key
+func ParseKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key: %d bytes, want %d", len(b), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// Sign hashes the recording in src and signs it. The manifest's RecordingID, UserID,
+// HostID and StartTime must be set; the rest is filled in.
+func Sign(key ed25519.PrivateKey, m Manifest, src io.ReadSeeker) (Manifest, error) {
+	chain, err := asciicast.HashChain(src, Scheme)
+	if err != nil {
+		return m, err
+	}
+	m.Scheme = Scheme
+	m.Events = chain.Events
+	m.Digest = hex.EncodeToString(chain.Digest)
+	m.PublicKey = EncodeKey(key.Public().(ed25519.PublicKey))
+	m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, m.message()))
+	return m, nil
+}
+
+// Verify checks that the manifest was signed by pub and that src still hashes to its digest
+func Verify(pub ed25519.PublicKey, m Manifest, src io.ReadSeeker) error {
+	if m.Scheme != Scheme {
+		return fmt.Errorf("unsupported scheme %q", m.Scheme)
+	}
+	if m.PublicKey != EncodeKey(pub) {
+		return ErrWrongKey
+	}
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil || !ed25519.Verify(pub, m.message(), sig) {
+		return ErrBadSignature
+	}
+
+	chain, err := asciicast.HashChain(src, Scheme)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrModified, err)
+	}
+	if hex.EncodeToString(chain.Digest) != m.Digest || chain.Events != m.Events {
+		return ErrModified
+	}
+	return nil
+}
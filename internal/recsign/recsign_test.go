@@ -0,0 +1,165 @@
+package recsign
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const cast = `{"version":2,"width":80,"height":24,"timestamp":1700000000}
+[0.1,"o","$ "]
+[0.5,"i","ls\r"]
+[0.6,"o","ls\r\nfile.txt\r\n"]
+[0.7,"o","$ "]
+`
+
+func newKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestVerify(t *testing.T) {
+	current := newKey(t)
+	legacy := KeyFromSecret("encryption-key")
+	other := newKey(t)
+
+	tests := []struct {
+		name    string
+		signer  ed25519.PrivateKey
+		keyring *Keyring
+		content string
+		edit    func(m *Manifest)
+		want    error
+	}{
+		{
+			name:    "unchanged",
+			signer:  current,
+			keyring: NewKeyring(current),
+			content: cast,
+		},
+		{
+			name:    "modified line",
+			signer:  current,
+			keyring: NewKeyring(current),
+			content: strings.Replace(cast, "file.txt", "file.bak", 1),
+			want:    ErrModified,
+		},
+		{
+			name:    "reordered lines",
+			signer:  current,
+			keyring: NewKeyring(current),
+			content: strings.Replace(cast, "[0.1,\"o\",\"$ \"]\n[0.5,\"i\",\"ls\\r\"]\n", "[0.5,\"i\",\"ls\\r\"]\n[0.1,\"o\",\"$ \"]\n", 1),
+			want:    ErrModified,
+		},
+		{
+			name:    "last line dropped",
+			signer:  current,
+			keyring: NewKeyring(current),
+			content: cast[:strings.LastIndex(cast[:len(cast)-1], "\n")+1],
+			want:    ErrModified,
+		},
+		{
+			name:    "truncated mid-line",
+			signer:  current,
+			keyring: NewKeyring(current),
+			content: cast[:len(cast)-5],
+			want:    ErrModified,
+		},
+		{
+			name:    "manifest edited",
+			signer:  current,
+			keyring: NewKeyring(current),
+			content: cast,
+			edit:    func(m *Manifest) { m.UserID++ },
+			want:    ErrBadSignature,
+		},
+		{
+			name:    "untrusted key",
+			signer:  other,
+			keyring: NewKeyring(current),
+			content: cast,
+			want:    ErrWrongKey,
+		},
+		{
+			name:    "legacy key trusted",
+			signer:  legacy,
+			keyring: NewKeyring(current, legacy.Public().(ed25519.PublicKey)),
+			content: cast,
+		},
+		{
+			name:    "legacy key not trusted",
+			signer:  legacy,
+			keyring: NewKeyring(current),
+			content: cast,
+			want:    ErrWrongKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Sign(tt.signer, Manifest{RecordingID: 1, UserID: 2, HostID: 3, StartTime: 1700000000}, strings.NewReader(cast))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Events != 4 {
+				t.Fatalf("Events = %d, want 4", m.Events)
+			}
+			if tt.edit != nil {
+				tt.edit(&m)
+			}
+			err = tt.keyring.Verify(m, strings.NewReader(tt.content))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyWrongPublicKey(t *testing.T) {
+	key := newKey(t)
+	m, err := Sign(key, Manifest{RecordingID: 1}, strings.NewReader(cast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newKey(t).Public().(ed25519.PublicKey)
+	if err := Verify(other, m, strings.NewReader(cast)); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Verify = %v, want %v", err, ErrWrongKey)
+	}
+
+	// A manifest claiming another key cannot borrow that key's trust
+	m.PublicKey = EncodeKey(other)
+	if err := Verify(other, m, strings.NewReader(cast)); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Verify = %v, want %v", err, ErrBadSignature)
+	}
+}
+
+func TestKeyFromSecretIsStable(t *testing.T) {
+	a, b := KeyFromSecret("secret"), KeyFromSecret("secret")
+	if !a.Equal(b) {
+		t.Error("KeyFromSecret gives different keys for the same secret")
+	}
+	if a.Equal(KeyFromSecret("other")) {
+		t.Error("KeyFromSecret gives the same key for different secrets")
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "signing.pem")
+	key, created, err := LoadKey(path)
+	if err != nil || !created {
+		t.Fatalf("LoadKey = %v, created %v", err, created)
+	}
+	again, created, err := LoadKey(path)
+	if err != nil || created {
+		t.Fatalf("LoadKey = %v, created %v", err, created)
+	}
+	if !key.Equal(again) {
+		t.Error("reloaded key differs from the created one")
+	}
+}
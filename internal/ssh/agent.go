@@ -0,0 +1,91 @@
+package ssh
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AgentRegistry holds one in-memory key agent per logged-in user
+type AgentRegistry struct {
+	mu     sync.Mutex
+	agents map[uint]agent.ExtendedAgent
+}
+
+// Agents is the process-wide per-user agent registry
+var Agents = NewAgentRegistry()
+
+func NewAgentRegistry() *AgentRegistry {
+	return &AgentRegistry{
+		agents: make(map[uint]agent.ExtendedAgent),
+	}
+}
+
+// Get returns the user's keyring, creating an empty one on first use
+func (r *AgentRegistry) Get(userID uint) agent.ExtendedAgent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keyring, ok := r.agents[userID]
+	if !ok {
+		keyring = agent.NewKeyring().(agent.ExtendedAgent)
+		r.agents[userID] = keyring
+	}
+	return keyring
+}
+
+// Clear drops every key loaded by the user
+func (r *AgentRegistry) Clear(userID uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if keyring, ok := r.agents[userID]; ok {
+		keyring.RemoveAll()
+		delete(r.agents, userID)
+	}
+}
+
+// SignAuditFunc is called for every signing request made through a forwarded agent
+type SignAuditFunc func(key ssh.PublicKey, err error)
+
+// forwardedAgent exposes a keyring to a remote host: it can list keys and sign,
+// every signature is reported, and the keyring cannot be modified from the remote side
+type forwardedAgent struct {
+	agent.ExtendedAgent
+	onSign SignAuditFunc
+}
+
+// NewForwardedAgent wraps a keyring for forwarding to a remote host
+func NewForwardedAgent(keyring agent.ExtendedAgent, onSign SignAuditFunc) agent.ExtendedAgent {
+	return &forwardedAgent{ExtendedAgent: keyring, onSign: onSign}
+}
+
+func (a *forwardedAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	sig, err := a.ExtendedAgent.Sign(key, data)
+	a.onSign(key, err)
+	return sig, err
+}
+
+func (a *forwardedAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	sig, err := a.ExtendedAgent.SignWithFlags(key, data, flags)
+	a.onSign(key, err)
+	return sig, err
+}
+
+var errReadOnlyAgent = fmt.Errorf("forwarded agent is read-only")
+
+func (a *forwardedAgent) Add(key agent.AddedKey) error { return errReadOnlyAgent }
+
+func (a *forwardedAgent) Remove(key ssh.PublicKey) error { return errReadOnlyAgent }
+
+func (a *forwardedAgent) RemoveAll() error { return errReadOnlyAgent }
+
+func (a *forwardedAgent) Lock(passphrase []byte) error { return errReadOnlyAgent }
+
+func (a *forwardedAgent) Unlock(passphrase []byte) error { return errReadOnlyAgent }
+
+func (a *forwardedAgent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
@@ -1,13 +1,21 @@
 package ssh
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
+// ErrHostKeyMismatch is returned (wrapped) when a host presents a key that differs from the saved fingerprint
+var ErrHostKeyMismatch = errors.New("host key fingerprint mismatch")
+
 type SSHClient struct {
 	client      *ssh.Client
 	session     *ssh.Session
@@ -15,19 +23,37 @@ type SSHClient struct {
 	host        string
 	port        int
 	fingerprint string
+	proxy       *ProxyConfig
+	jumpConfigs []*SSHConfig
+	jumps       []*SSHClient // Connected jump hosts, closed together with the client
+	onHop       HopCallback
+	closeOnce   sync.Once
+	onClose     []func()
 }
 
+// PromptFunc answers keyboard-interactive questions that could not be answered automatically
+type PromptFunc func(name, instruction string, questions []string, echos []bool) ([]string, error)
+
+// HopCallback is invoked after each jump host in the chain has been dialed.
+// fingerprint is the host key seen for that hop (may be set even when err != nil).
+type HopCallback func(index int, hop *SSHConfig, fingerprint string, err error)
+
 type SSHConfig struct {
 	Host        string
 	Port        int
 	Username    string
 	Password    string
 	PrivateKey  string
+	Passphrase  string     // Decrypts PrivateKey when it is encrypted
+	Certificate string     // OpenSSH user certificate (*-cert.pub) for PrivateKey
+	Prompt      PromptFunc // Keyboard-interactive prompts (OTP etc.); nil disables interactive answers
 	Timeout     time.Duration
-	Fingerprint string // Expected fingerprint (empty for TOFU)
+	Fingerprint string       // Expected fingerprint (empty for TOFU)
+	Proxy       *ProxyConfig // Outbound proxy, only used when this host is dialed directly
+	JumpHosts   []*SSHConfig // Ordered bastion chain; the first entry is dialed directly
+	OnHop       HopCallback
 }
 
-// NewSSHClient creates a new SSH client
 // NewSSHClient creates a new SSH client
 func NewSSHClient(cfg *SSHConfig) (*SSHClient, error) {
 	var authMethods []ssh.AuthMethod
@@ -39,20 +65,28 @@ func NewSSHClient(cfg *SSHConfig) (*SSHClient, error) {
 
 	// Add key authentication
 	if cfg.PrivateKey != "" {
-		signer, err := ssh.ParsePrivateKey([]byte(cfg.PrivateKey))
+		signer, err := ParseSigner(cfg.PrivateKey, cfg.Passphrase, cfg.Certificate)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse private key: %w", err)
+			return nil, err
 		}
 		authMethods = append(authMethods, ssh.PublicKeys(signer))
 	}
 
+	// Add keyboard-interactive authentication (PAM, OTP)
+	if cfg.Password != "" || cfg.Prompt != nil {
+		authMethods = append(authMethods, ssh.KeyboardInteractive(keyboardInteractive(cfg.Password, cfg.Prompt)))
+	}
+
 	if len(authMethods) == 0 {
 		return nil, fmt.Errorf("no authentication method provided")
 	}
 
 	client := &SSHClient{
-		host: cfg.Host,
-		port: cfg.Port,
+		host:        cfg.Host,
+		port:        cfg.Port,
+		proxy:       cfg.Proxy,
+		jumpConfigs: cfg.JumpHosts,
+		onHop:       cfg.OnHop,
 	}
 
 	// Host Key Verification Callback
@@ -67,7 +101,7 @@ func NewSSHClient(cfg *SSHConfig) (*SSHClient, error) {
 		}
 
 		if fp != cfg.Fingerprint {
-			return fmt.Errorf("host key fingerprint mismatch: anticipated %s, got %s", cfg.Fingerprint, fp)
+			return fmt.Errorf("%w: anticipated %s, got %s", ErrHostKeyMismatch, cfg.Fingerprint, fp)
 		}
 
 		return nil
@@ -84,18 +118,120 @@ func NewSSHClient(cfg *SSHConfig) (*SSHClient, error) {
 	return client, nil
 }
 
-// Connect establishes the SSH connection
+// keyboardInteractive answers plain password prompts with the saved password (once)
+// and forwards every other question to prompt
+func keyboardInteractive(password string, prompt PromptFunc) ssh.KeyboardInteractiveChallenge {
+	passwordUsed := false
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		var pending []int
+		for i, q := range questions {
+			if password != "" && !passwordUsed && !echos[i] && isPasswordPrompt(q) {
+				answers[i] = password
+				continue
+			}
+			pending = append(pending, i)
+		}
+		if password != "" && len(pending) < len(questions) {
+			passwordUsed = true
+		}
+
+		if len(pending) == 0 {
+			return answers, nil
+		}
+		if prompt == nil {
+			return nil, fmt.Errorf("keyboard-interactive authentication requires user input")
+		}
+
+		pendingQuestions := make([]string, len(pending))
+		pendingEchos := make([]bool, len(pending))
+		for j, i := range pending {
+			pendingQuestions[j] = questions[i]
+			pendingEchos[j] = echos[i]
+		}
+
+		replies, err := prompt(name, instruction, pendingQuestions, pendingEchos)
+		if err != nil {
+			return nil, err
+		}
+		if len(replies) != len(pending) {
+			return nil, fmt.Errorf("expected %d answers, got %d", len(pending), len(replies))
+		}
+		for j, i := range pending {
+			answers[i] = replies[j]
+		}
+		return answers, nil
+	}
+}
+
+// isPasswordPrompt reports whether a question is a plain "Password:" prompt
+func isPasswordPrompt(question string) bool {
+	q := strings.ToLower(strings.TrimSpace(question))
+	return q == "password:" || strings.HasSuffix(q, "'s password:")
+}
+
+// Connect establishes the SSH connection, tunnelling through any jump hosts
 func (c *SSHClient) Connect() error {
-	addr := fmt.Sprintf("%s:%d", c.host, c.port)
-	client, err := ssh.Dial("tcp", addr, c.config)
+	var via *ssh.Client
+	for i, hopCfg := range c.jumpConfigs {
+		hop, err := NewSSHClient(hopCfg)
+		if err == nil {
+			err = hop.dial(via)
+		}
+		if c.onHop != nil {
+			fp := ""
+			if hop != nil {
+				fp = hop.fingerprint
+			}
+			c.onHop(i, hopCfg, fp, err)
+		}
+		if err != nil {
+			c.closeJumps()
+			return fmt.Errorf("jump host %s: %w", net.JoinHostPort(hopCfg.Host, strconv.Itoa(hopCfg.Port)), err)
+		}
+		c.jumps = append(c.jumps, hop)
+		via = hop.client
+	}
+
+	if err := c.dial(via); err != nil {
+		c.closeJumps()
+		return err
+	}
+	return nil
+}
+
+// dial connects to the target either directly (optionally via the proxy) or through an established client
+func (c *SSHClient) dial(via *ssh.Client) error {
+	addr := net.JoinHostPort(c.host, strconv.Itoa(c.port))
+
+	var conn net.Conn
+	var err error
+	if via == nil {
+		conn, err = DialTCP(c.proxy, addr, c.config.Timeout)
+	} else {
+		conn, err = via.Dial("tcp", addr)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to dial: %w", err)
 	}
 
-	c.client = client
+	ncc, chans, reqs, err := ssh.NewClientConn(conn, addr, c.config)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to dial: %w", err)
+	}
+	c.client = ssh.NewClient(ncc, chans, reqs)
 	return nil
 }
 
+// closeJumps tears down the jump host chain in reverse order
+func (c *SSHClient) closeJumps() {
+	for i := len(c.jumps) - 1; i >= 0; i-- {
+		c.jumps[i].Close()
+	}
+	c.jumps = nil
+}
+
 // NewSession creates a new SSH session
 func (c *SSHClient) NewSession() error {
 	if c.client == nil {
@@ -111,6 +247,21 @@ func (c *SSHClient) NewSession() error {
 	return nil
 }
 
+// ForwardAgent serves keyring to the remote host and enables forwarding on the current session
+func (c *SSHClient) ForwardAgent(keyring agent.Agent) error {
+	if c.session == nil {
+		return fmt.Errorf("no session")
+	}
+
+	if err := agent.ForwardToAgent(c.client, keyring); err != nil {
+		return fmt.Errorf("failed to serve agent: %w", err)
+	}
+	if err := agent.RequestAgentForwarding(c.session); err != nil {
+		return fmt.Errorf("failed to request agent forwarding: %w", err)
+	}
+	return nil
+}
+
 // RequestPTY requests a pseudo-terminal
 func (c *SSHClient) RequestPTY(term string, height, width int) error {
 	if c.session == nil {
@@ -157,10 +308,22 @@ func (c *SSHClient) Close() error {
 	if c.session != nil {
 		c.session.Close()
 	}
+	var err error
 	if c.client != nil {
-		return c.client.Close()
+		err = c.client.Close()
 	}
-	return nil
+	c.closeJumps()
+	c.closeOnce.Do(func() {
+		for _, fn := range c.onClose {
+			fn()
+		}
+	})
+	return err
+}
+
+// OnClose registers fn to run once when the client is closed, e.g. to release a connection slot
+func (c *SSHClient) OnClose(fn func()) {
+	c.onClose = append(c.onClose, fn)
 }
 
 // GetSession returns the SSH session
@@ -0,0 +1,91 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ParseRawKey parses a PEM/OpenSSH private key, decrypting it with passphrase when it is encrypted
+func ParseRawKey(privateKey, passphrase string) (interface{}, error) {
+	key, err := ssh.ParseRawPrivateKey([]byte(privateKey))
+	if err == nil {
+		return key, nil
+	}
+
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("private key is encrypted, a passphrase is required")
+	}
+	key, err = ssh.ParseRawPrivateKeyWithPassphrase([]byte(privateKey), []byte(passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt private key: %w", err)
+	}
+	return key, nil
+}
+
+// ParseSigner parses a private key (see ParseRawKey) into a signer.
+// When certificate (the contents of a *-cert.pub file) is set, the returned signer authenticates
+// with that OpenSSH user certificate instead of the bare public key.
+func ParseSigner(privateKey, passphrase, certificate string) (ssh.Signer, error) {
+	key, err := ParseRawKey(privateKey, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	if strings.TrimSpace(certificate) == "" {
+		return signer, nil
+	}
+
+	cert, err := ParseUserCertificate(certificate)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(cert.Key.Marshal(), signer.PublicKey().Marshal()) {
+		return nil, fmt.Errorf("certificate does not match the private key")
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate signer: %w", err)
+	}
+	return certSigner, nil
+}
+
+// ParseUserCertificate parses an OpenSSH user certificate in authorized_keys format
+// and checks that it is currently valid
+func ParseUserCertificate(certificate string) (*ssh.Certificate, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certificate))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("not an OpenSSH certificate")
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("not a user certificate")
+	}
+
+	now := uint64(time.Now().Unix())
+	if now < cert.ValidAfter {
+		return nil, fmt.Errorf("certificate is not yet valid")
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && now >= cert.ValidBefore {
+		return nil, fmt.Errorf("certificate has expired")
+	}
+
+	return cert, nil
+}
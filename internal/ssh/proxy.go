@@ -0,0 +1,125 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// ProxyConfig describes an outbound proxy used to reach the first SSH hop
+type ProxyConfig struct {
+	Type     string // socks5 or http
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// Address returns the proxy host:port
+func (p *ProxyConfig) Address() string {
+	return net.JoinHostPort(p.Host, strconv.Itoa(p.Port))
+}
+
+// DialTCP opens a TCP connection to addr, through the proxy if one is configured
+func DialTCP(p *ProxyConfig, addr string, timeout time.Duration) (net.Conn, error) {
+	if p == nil {
+		return net.DialTimeout("tcp", addr, timeout)
+	}
+
+	switch p.Type {
+	case "socks5":
+		return dialSOCKS5(p, addr, timeout)
+	case "http":
+		return dialHTTPConnect(p, addr, timeout)
+	default:
+		return nil, fmt.Errorf("unsupported proxy type: %s", p.Type)
+	}
+}
+
+// dialSOCKS5 connects through a SOCKS5 proxy, with optional username/password auth
+func dialSOCKS5(p *ProxyConfig, addr string, timeout time.Duration) (net.Conn, error) {
+	var auth *proxy.Auth
+	if p.Username != "" {
+		auth = &proxy.Auth{User: p.Username, Password: p.Password}
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", p.Address(), auth, &net.Dialer{Timeout: timeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SOCKS5 dialer: %w", err)
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("SOCKS5 proxy %s: %w", p.Address(), err)
+	}
+	return conn, nil
+}
+
+// dialHTTPConnect tunnels through an HTTP proxy using the CONNECT method
+func dialHTTPConnect(p *ProxyConfig, addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", p.Address(), timeout)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP proxy %s: %w", p.Address(), err)
+	}
+
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	req := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if p.Username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(p.Username + ":" + p.Password))
+		req += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	req += "\r\n"
+
+	if _, err := conn.Write([]byte(req)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("HTTP proxy %s: %w", p.Address(), err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("HTTP proxy %s: %w", p.Address(), err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("HTTP proxy %s refused CONNECT: %s", p.Address(), resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	// The proxy may have sent bytes from the remote end along with its response
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn drains bytes read ahead by the CONNECT handshake before reading from the socket
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,541 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ihxw/termiscope/internal/config"
+)
+
+// spoolPrefix starts the names of spooled objects; the rest is the escaped key
+const spoolPrefix = "pending-"
+
+// emptySHA256 is the payload hash of a request without body
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3 stores objects in a bucket of an S3-compatible object store, signing requests with
+// AWS Signature Version 4. Objects being created are spooled to a local file and
+// uploaded when closed, since objects cannot be appended to.
+type S3 struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	spool     string
+	client    *http.Client
+
+	mu        sync.Mutex
+	uploading map[string]bool // Keys being written or uploaded by this process
+}
+
+// NewS3 returns a storage for the bucket configured in cfg
+func NewS3(cfg config.StorageConfig) (*S3, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid storage endpoint %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("storage bucket is required")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("storage access key and secret key are required")
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3{
+		endpoint:  endpoint,
+		region:    region,
+		bucket:    cfg.Bucket,
+		prefix:    cfg.Prefix,
+		accessKey: cfg.AccessKey,
+		secretKey: cfg.SecretKey,
+		pathStyle: cfg.PathStyle,
+		spool:     filepath.Join(cfg.Path, "spool"),
+		uploading: make(map[string]bool),
+		client: &http.Client{Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: 30 * time.Second,
+			IdleConnTimeout:       90 * time.Second,
+		}},
+	}, nil
+}
+
+// Create checks the key is free and spools the object until it is closed. The spooled
+// file is named after the key, so Recover can upload it if the server stops first.
+func (s *S3) Create(key string) (io.WriteCloser, error) {
+	if _, err := s.Stat(key); err == nil {
+		return nil, ErrExist
+	} else if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.spool, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(s.spool, spoolPrefix+url.PathEscape(key)), os.O_CREATE|os.O_RDWR|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil, ErrExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.uploading[key] = true
+	s.mu.Unlock()
+	return &s3Upload{s: s, key: key, file: f}, nil
+}
+
+// Recover uploads spooled objects that are not being written by this process
+func (s *S3) Recover() ([]string, error) {
+	entries, err := os.ReadDir(s.spool)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), spoolPrefix)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		key, err := url.PathUnescape(name)
+		if err != nil || checkKey(key) != nil {
+			continue
+		}
+		s.mu.Lock()
+		busy := s.uploading[key]
+		s.mu.Unlock()
+		if busy {
+			continue
+		}
+
+		path := filepath.Join(s.spool, entry.Name())
+		if err := s.putFile(key, path); err != nil {
+			return keys, fmt.Errorf("recover %s: %w", key, err)
+		}
+		if err := os.Remove(path); err != nil {
+			return keys, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// putFile uploads a local file
+func (s *S3) putFile(key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return s.Put(key, f, stat.Size())
+}
+
+// Put signs the SHA-256 of the body, so it reads the body twice; bodies that cannot seek
+// are copied to a temporary file first
+func (s *S3) Put(key string, r io.Reader, size int64) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	var body io.ReadSeeker
+	if size > 0 {
+		rs, ok := r.(io.ReadSeeker)
+		if !ok {
+			f, err := os.CreateTemp("", "s3-put-*")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+			if _, err := io.CopyN(f, r, size); err != nil {
+				return err
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			rs = f
+		}
+		body = rs
+	}
+	resp, err := s.do(http.MethodPut, key, nil, nil, body, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Open reads the object with ranged requests, so seeking does not download what is skipped
+func (s *S3) Open(key string) (Object, error) {
+	info, err := s.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Object{s: s, info: info}, nil
+}
+
+func (s *S3) Stat(key string) (Info, error) {
+	if err := checkKey(key); err != nil {
+		return Info{}, err
+	}
+	resp, err := s.do(http.MethodHead, key, nil, nil, nil, 0)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+	info := Info{Key: key, Size: resp.ContentLength}
+	info.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info, nil
+}
+
+func (s *S3) Delete(key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodDelete, key, nil, nil, nil, 0)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List pages through ListObjectsV2
+func (s *S3) List(prefix string) ([]Info, error) {
+	var infos []Info
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(http.MethodGet, "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list objects: %w", err)
+		}
+
+		for _, c := range result.Contents {
+			infos = append(infos, Info{Key: strings.TrimPrefix(c.Key, s.prefix), Size: c.Size, ModTime: c.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos, nil
+}
+
+// do sends a signed request for key, or for the bucket if key is empty. Error responses
+// are returned as errors, ErrNotFound for a missing object. The next size bytes of body
+// are sent, and hashed for the signature first.
+func (s *S3) do(method, key string, query url.Values, header http.Header, body io.ReadSeeker, size int64) (*http.Response, error) {
+	u := *s.endpoint
+	objectPath := ""
+	if key != "" {
+		objectPath = "/" + s.prefix + key
+	}
+	if s.pathStyle {
+		u.Path = u.Path + "/" + s.bucket + objectPath
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = u.Path + objectPath
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	var reqBody io.Reader
+	payloadHash := emptySHA256
+	if body != nil {
+		var err error
+		if payloadHash, err = payloadSHA256(body, size); err != nil {
+			return nil, err
+		}
+		reqBody = io.LimitReader(body, size)
+	}
+	req, err := http.NewRequest(method, u.String(), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	s.sign(req, payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && key != "" {
+		return nil, ErrNotFound
+	}
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+	if e.Code == "" {
+		e.Code = resp.Status
+	}
+	return nil, fmt.Errorf("s3 %s %s: %s %s", method, key, e.Code, e.Message)
+}
+
+// sign adds the Signature Version 4 authorization of req. Host and every header already
+// set are signed.
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashHex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// payloadSHA256 hashes the next size bytes of body and seeks back to them
+func payloadSHA256(body io.ReadSeeker, size int64) (string, error) {
+	start, err := body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, body, size); err != nil {
+		return "", err
+	}
+	if _, err := body.Seek(start, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// uriEncode percent-encodes everything but the unreserved characters, as Signature
+// Version 4 requires; slashes are kept unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery encodes query sorted by name, the same way it is signed
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(name, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Upload spools an object being created and uploads it on Close
+type s3Upload struct {
+	s    *S3
+	key  string
+	file *os.File
+}
+
+func (u *s3Upload) Write(p []byte) (int, error) {
+	return u.file.Write(p)
+}
+
+// Close uploads the spooled file. If the upload fails the file is kept for Recover.
+func (u *s3Upload) Close() error {
+	defer func() {
+		u.s.mu.Lock()
+		delete(u.s.uploading, u.key)
+		u.s.mu.Unlock()
+	}()
+
+	size, err := u.file.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = u.file.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		err = u.s.Put(u.key, u.file, size)
+	}
+	name := u.file.Name()
+	u.file.Close()
+	if err != nil {
+		return fmt.Errorf("upload %s failed, kept in %s: %w", u.key, name, err)
+	}
+	return os.Remove(name)
+}
+
+// s3Object reads an object from the current offset with a ranged GET, issuing a new
+// request only after a seek
+type s3Object struct {
+	s       *S3
+	info    Info
+	pos     int64
+	body    io.ReadCloser
+	bodyPos int64 // Offset the body will read next
+}
+
+func (o *s3Object) Info() Info {
+	return o.info
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.pos >= o.info.Size {
+		return 0, io.EOF
+	}
+	if o.body != nil && o.bodyPos != o.pos {
+		o.body.Close()
+		o.body = nil
+	}
+	if o.body == nil {
+		header := http.Header{"Range": {"bytes=" + strconv.FormatInt(o.pos, 10) + "-"}}
+		resp, err := o.s.do(http.MethodGet, o.info.Key, nil, header, nil, 0)
+		if err != nil {
+			return 0, err
+		}
+		o.body, o.bodyPos = resp.Body, o.pos
+	}
+	n, err := o.body.Read(p)
+	o.pos += int64(n)
+	o.bodyPos += int64(n)
+	if err == io.EOF && o.pos < o.info.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.pos
+	case io.SeekEnd:
+		offset += o.info.Size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	o.pos = offset
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body != nil {
+		return o.body.Close()
+	}
+	return nil
+}
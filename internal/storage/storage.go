@@ -0,0 +1,81 @@
+// Package storage keeps recordings and backups in a local directory or an S3-compatible
+// object store. Objects are addressed by slash-separated keys such as
+// "recordings/1-2-1700000000000000000.cast.gz".
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ihxw/termiscope/internal/config"
+)
+
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrExist      = errors.New("object already exists")
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// Info describes a stored object
+type Info struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Object is an object opened for reading
+type Object interface {
+	io.ReadSeekCloser
+	Info() Info
+}
+
+// Storage stores objects by key
+type Storage interface {
+	// Create starts a new object, failing with ErrExist if the key is taken. The object
+	// is complete once the writer is closed; with object stores that is when it is uploaded.
+	Create(key string) (io.WriteCloser, error)
+	// Put stores size bytes read from r, replacing any object with the same key
+	Put(key string, r io.Reader, size int64) error
+	// Open opens an object for reading, ErrNotFound if there is none
+	Open(key string) (Object, error)
+	// Stat describes an object, ErrNotFound if there is none
+	Stat(key string) (Info, error)
+	// Delete removes an object; a missing object is not an error
+	Delete(key string) error
+	// List returns the objects whose keys start with prefix, sorted by key
+	List(prefix string) ([]Info, error)
+}
+
+// Recoverer is implemented by storages that hold new objects back, e.g. until they can be
+// uploaded. Recover stores the objects left behind by a crash or a failed upload and
+// returns their keys; objects still being written are left alone.
+type Recoverer interface {
+	Recover() ([]string, error)
+}
+
+// New returns the storage selected by cfg
+func New(cfg config.StorageConfig) (Storage, error) {
+	switch cfg.Type {
+	case "", "local":
+		return NewLocal(cfg.Path), nil
+	case "s3":
+		return NewS3(cfg)
+	}
+	return nil, fmt.Errorf("unknown storage type %q", cfg.Type)
+}
+
+// checkKey rejects keys that are empty, absolute or could escape the storage root
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package terminal
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrGroupNotFound = errors.New("broadcast group not found")
+
+// BroadcastMember is a session in a broadcast group
+type BroadcastMember struct {
+	SessionID string `json:"session_id"`
+	HostName  string `json:"host_name"`
+	Host      string `json:"host"`
+	Excluded  bool   `json:"excluded"` // Temporarily left out of broadcasts
+}
+
+// BroadcastGroup is a set of one user's sessions that receive the same input. A group
+// goes away with its last member.
+type BroadcastGroup struct {
+	ID        string            `json:"id"`
+	UserID    uint              `json:"user_id"`
+	Name      string            `json:"name"`
+	CreatedAt time.Time         `json:"created_at"`
+	Members   []BroadcastMember `json:"members"`
+}
+
+// BroadcastResult is the outcome of a broadcast
+type BroadcastResult struct {
+	Sent    []BroadcastMember `json:"sent"`
+	Skipped []BroadcastMember `json:"skipped"` // Excluded members
+	Failed  []BroadcastMember `json:"failed"`  // Members whose input could not be written
+}
+
+// Broadcasts holds the broadcast groups of all users
+type Broadcasts struct {
+	mu     sync.Mutex
+	groups map[string]*BroadcastGroup
+}
+
+// Groups is the process-wide broadcast group registry
+var Groups = &Broadcasts{groups: make(map[string]*BroadcastGroup)}
+
+// Create makes a group of sessions
+func (b *Broadcasts) Create(userID uint, name string, sessions []*Session) BroadcastGroup {
+	g := &BroadcastGroup{
+		ID:        newID(),
+		UserID:    userID,
+		Name:      name,
+		CreatedAt: time.Now(),
+		Members:   make([]BroadcastMember, 0, len(sessions)),
+	}
+	for _, s := range sessions {
+		g.Members = append(g.Members, member(s))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.groups[g.ID] = g
+	return g.copy()
+}
+
+// Get returns a group, with members whose session ended removed
+func (b *Broadcasts) Get(id string) (BroadcastGroup, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	g, ok := b.groups[id]
+	if !ok || !b.prune(g) {
+		return BroadcastGroup{}, false
+	}
+	return g.copy(), true
+}
+
+// ListByUser returns a user's groups, oldest first
+func (b *Broadcasts) ListByUser(userID uint) []BroadcastGroup {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	list := make([]BroadcastGroup, 0)
+	for _, g := range b.groups {
+		if g.UserID == userID && b.prune(g) {
+			list = append(list, g.copy())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Delete removes a group; the sessions keep running
+func (b *Broadcasts) Delete(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.groups[id]; !ok {
+		return false
+	}
+	delete(b.groups, id)
+	return true
+}
+
+// AddMember joins a session to a group
+func (b *Broadcasts) AddMember(id string, s *Session) (BroadcastGroup, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	g, ok := b.groups[id]
+	if !ok {
+		return BroadcastGroup{}, ErrGroupNotFound
+	}
+	if g.index(s.ID) < 0 {
+		g.Members = append(g.Members, member(s))
+	}
+	return g.copy(), nil
+}
+
+// RemoveMember drops a session from a group, and the group when it was the last member
+func (b *Broadcasts) RemoveMember(id, sessionID string) (BroadcastGroup, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	g, ok := b.groups[id]
+	if !ok {
+		return BroadcastGroup{}, false
+	}
+	i := g.index(sessionID)
+	if i < 0 {
+		return BroadcastGroup{}, false
+	}
+	g.Members = append(g.Members[:i], g.Members[i+1:]...)
+	if len(g.Members) == 0 {
+		delete(b.groups, id)
+	}
+	return g.copy(), true
+}
+
+// SetExcluded temporarily leaves a member out of broadcasts, or brings it back
+func (b *Broadcasts) SetExcluded(id, sessionID string, excluded bool) (BroadcastGroup, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	g, ok := b.groups[id]
+	if !ok {
+		return BroadcastGroup{}, false
+	}
+	i := g.index(sessionID)
+	if i < 0 {
+		return BroadcastGroup{}, false
+	}
+	g.Members[i].Excluded = excluded
+	return g.copy(), true
+}
+
+// Send writes data to the stdin of every member that is not excluded
+func (b *Broadcasts) Send(id string, data []byte) (BroadcastResult, error) {
+	group, ok := b.Get(id)
+	if !ok {
+		return BroadcastResult{}, ErrGroupNotFound
+	}
+
+	result := BroadcastResult{
+		Sent:    make([]BroadcastMember, 0, len(group.Members)),
+		Skipped: make([]BroadcastMember, 0),
+		Failed:  make([]BroadcastMember, 0),
+	}
+	for _, m := range group.Members {
+		if m.Excluded {
+			result.Skipped = append(result.Skipped, m)
+			continue
+		}
+		s, ok := Sessions.Get(m.SessionID)
+		if !ok || s.Input(data) != nil {
+			result.Failed = append(result.Failed, m)
+			continue
+		}
+		result.Sent = append(result.Sent, m)
+	}
+	return result, nil
+}
+
+// sessionEnded drops an ended session from every group, and groups it leaves empty
+func (b *Broadcasts) sessionEnded(sessionID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for id, g := range b.groups {
+		if i := g.index(sessionID); i >= 0 {
+			g.Members = append(g.Members[:i], g.Members[i+1:]...)
+		}
+		if len(g.Members) == 0 {
+			delete(b.groups, id)
+		}
+	}
+}
+
+// prune drops members of g whose session has ended, and g itself if none are left.
+// It reports whether g still exists.
+func (b *Broadcasts) prune(g *BroadcastGroup) bool {
+	members := g.Members[:0]
+	for _, m := range g.Members {
+		if _, ok := Sessions.Get(m.SessionID); ok {
+			members = append(members, m)
+		}
+	}
+	g.Members = members
+	if len(g.Members) == 0 {
+		delete(b.groups, g.ID)
+		return false
+	}
+	return true
+}
+
+func member(s *Session) BroadcastMember {
+	return BroadcastMember{SessionID: s.ID, HostName: s.HostName, Host: s.Host}
+}
+
+func (g *BroadcastGroup) index(sessionID string) int {
+	for i, m := range g.Members {
+		if m.SessionID == sessionID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (g *BroadcastGroup) copy() BroadcastGroup {
+	c := *g
+	c.Members = append(make([]BroadcastMember, 0, len(g.Members)), g.Members...)
+	return c
+}
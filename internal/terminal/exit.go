@@ -0,0 +1,60 @@
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// exitWaitTimeout bounds how long we wait for the exit status once stdout has ended
+const exitWaitTimeout = 5 * time.Second
+
+// ExitStatus is how a session ended
+type ExitStatus struct {
+	Code   *int   `json:"code"`             // Exit code of the remote shell, nil if it did not report one
+	Signal string `json:"signal,omitempty"` // Signal that killed the remote shell, e.g. "KILL"
+	Reason string `json:"reason"`           // Why the session ended, e.g. ReasonIdleTimeout
+}
+
+// String describes the status for the user
+func (e ExitStatus) String() string {
+	switch {
+	case e.Signal != "":
+		return fmt.Sprintf("%s (killed by signal %s)", e.Reason, e.Signal)
+	case e.Code != nil:
+		return fmt.Sprintf("%s (exit code %d)", e.Reason, *e.Code)
+	default:
+		return e.Reason
+	}
+}
+
+// waitExit collects the remote shell's exit status after its output ended
+func (s *Session) waitExit() ExitStatus {
+	status := ExitStatus{Reason: ReasonExited}
+
+	result := make(chan error, 1)
+	go func() { result <- s.client.Wait() }()
+
+	var err error
+	select {
+	case err = <-result:
+	case <-time.After(exitWaitTimeout):
+		return status
+	}
+
+	var exitErr *gossh.ExitError
+	switch {
+	case err == nil:
+		code := 0
+		status.Code = &code
+	case errors.As(err, &exitErr):
+		status.Signal = exitErr.Signal()
+		// A shell killed by a signal reports no exit code
+		if code := exitErr.ExitStatus(); code >= 0 {
+			status.Code = &code
+		}
+	}
+	return status
+}
@@ -0,0 +1,65 @@
+package terminal
+
+import (
+	"sort"
+	"sync"
+)
+
+// Registry tracks the running terminal sessions of all users
+type Registry struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// Sessions is the process-wide session registry
+var Sessions = &Registry{sessions: make(map[string]*Session)}
+
+// Add registers a session; it is removed automatically when it closes
+func (r *Registry) Add(s *Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s.registry = r
+	r.sessions[s.ID] = s
+}
+
+// Get returns a session by ID
+func (r *Registry) Get(id string) (*Session, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.sessions[id]
+	return s, ok
+}
+
+// List returns all sessions, oldest first
+func (r *Registry) List() []*Session {
+	r.mu.Lock()
+	list := make([]*Session, 0, len(r.sessions))
+	for _, s := range r.sessions {
+		list = append(list, s)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartedAt.Before(list[j].StartedAt)
+	})
+	return list
+}
+
+// ListByUser returns the sessions owned by a user, oldest first
+func (r *Registry) ListByUser(userID uint) []*Session {
+	var list []*Session
+	for _, s := range r.List() {
+		if s.UserID == userID {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+func (r *Registry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, id)
+}
@@ -0,0 +1,63 @@
+package terminal
+
+import (
+	"sync"
+	"unicode/utf8"
+)
+
+// RingBuffer keeps the most recent bytes written to it, used as session scrollback
+type RingBuffer struct {
+	mu   sync.Mutex
+	data []byte
+	pos  int // Next write position
+	full bool
+}
+
+func NewRingBuffer(size int) *RingBuffer {
+	return &RingBuffer{data: make([]byte, size)}
+}
+
+// Write appends p, overwriting the oldest bytes once the buffer is full
+func (r *RingBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := len(p)
+	size := len(r.data)
+	if n >= size {
+		copy(r.data, p[n-size:])
+		r.pos = 0
+		r.full = true
+		return n, nil
+	}
+
+	copied := copy(r.data[r.pos:], p)
+	if copied < n {
+		copy(r.data, p[copied:])
+	}
+	if r.pos+n >= size {
+		r.full = true
+	}
+	r.pos = (r.pos + n) % size
+	return n, nil
+}
+
+// Bytes returns a copy of the buffered data, oldest first
+func (r *RingBuffer) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]byte(nil), r.data[:r.pos]...)
+	}
+
+	out := make([]byte, 0, len(r.data))
+	out = append(out, r.data[r.pos:]...)
+	out = append(out, r.data[:r.pos]...)
+
+	// The oldest bytes may start in the middle of a UTF-8 sequence
+	for len(out) > 0 && !utf8.RuneStart(out[0]) {
+		out = out[1:]
+	}
+	return out
+}
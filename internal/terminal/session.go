@@ -0,0 +1,511 @@
+package terminal
+
+import (
+	"io"
+	"log"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ihxw/termiscope/internal/ssh"
+	"github.com/ihxw/termiscope/internal/utils"
+)
+
+// ScrollbackSize is how much recent output a session keeps for replay on reattach
+const ScrollbackSize = 256 * 1024
+
+// Close reasons
+const (
+	ReasonExited        = "session ended"
+	ReasonClosedByUser  = "closed by user"
+	ReasonDisconnected  = "client disconnected"
+	ReasonIdleTimeout   = "idle timeout"
+	ReasonDetachTimeout = "detach grace period expired"
+)
+
+// Client is a frontend attached to a session, usually a WebSocket
+type Client interface {
+	// Output sends terminal output to the client
+	Output(data []byte) error
+	// Send sends a control message, e.g. a viewer joining
+	Send(msgType string, data interface{}) error
+	// Close disconnects the client, telling it why
+	Close(reason string)
+	// Exit tells the client the session ended and disconnects it
+	Exit(status ExitStatus)
+}
+
+// FlowControlled is implemented by clients that can fall behind the output.
+// WaitWritable blocks until the client is ready for more output.
+type FlowControlled interface {
+	WaitWritable()
+}
+
+// InputFilter can hold back user input before it reaches the shell, e.g. to confirm a command
+type InputFilter interface {
+	// Filter returns the part of data that may be written now
+	Filter(data []byte) []byte
+	// Resolve answers a held confirmation and returns what to write
+	Resolve(id string, approve bool, outcome string) []byte
+}
+
+// Info describes a session
+type Info struct {
+	ID              string    `json:"id"`
+	UserID          uint      `json:"user_id"`
+	Username        string    `json:"username"`
+	HostID          uint      `json:"host_id"`
+	HostName        string    `json:"host_name"`
+	Host            string    `json:"host"`
+	ClientIP        string    `json:"client_ip"`
+	ConnectionLogID uint      `json:"connection_log_id"`
+	Recorded        bool      `json:"recorded"` // The session output is being recorded
+	StartedAt       time.Time `json:"started_at"`
+}
+
+// Status is a point-in-time snapshot of a session
+type Status struct {
+	Info
+	Attached   bool       `json:"attached"`
+	DetachedAt *time.Time `json:"detached_at,omitempty"`
+	Viewers    []Viewer   `json:"viewers"`
+	BytesIn    uint64     `json:"bytes_in"`  // Input written to the remote shell
+	BytesOut   uint64     `json:"bytes_out"` // Output produced by the remote shell
+}
+
+// Session is a running remote shell that outlives the WebSocket it was opened from.
+// When the client drops, the session is kept for a grace period and can be reattached.
+type Session struct {
+	Info
+
+	client     *ssh.SSHClient
+	stdin      io.Writer
+	stdinMu    sync.Mutex
+	scrollback *RingBuffer
+	grace      time.Duration
+	registry   *Registry
+
+	mu         sync.Mutex
+	attached   Client
+	detachedAt *time.Time
+	graceTimer *time.Timer
+	taps       []func(data []byte)
+	inputTaps  []func(data []byte)
+	resizeTaps []func(rows, cols int)
+	filter     InputFilter
+	onClose    []func(status ExitStatus)
+	closed     bool
+	killed     bool
+	exit       ExitStatus
+	transfer   *transfer
+	zmodemTail []byte
+	onTransfer []func(file TransferFile)
+	viewers    map[string]*viewerConn
+	shares     map[string]*Share
+
+	done     chan struct{}
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+}
+
+// NewSession wraps a started shell. grace is how long the session survives without a client;
+// zero closes it as soon as the client goes away.
+func NewSession(info Info, client *ssh.SSHClient, stdin io.Writer, grace time.Duration) *Session {
+	info.ID = newID()
+	if info.StartedAt.IsZero() {
+		info.StartedAt = time.Now()
+	}
+
+	return &Session{
+		Info:       info,
+		client:     client,
+		stdin:      stdin,
+		scrollback: NewRingBuffer(ScrollbackSize),
+		grace:      grace,
+		done:       make(chan struct{}),
+		viewers:    make(map[string]*viewerConn),
+		shares:     make(map[string]*Share),
+	}
+}
+
+// AddOutputTap registers fn to receive all output, e.g. for recording. Call before Start.
+func (s *Session) AddOutputTap(fn func(data []byte)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.taps = append(s.taps, fn)
+}
+
+// AddInputTap registers fn to see user input, e.g. for command auditing. Call before Start.
+// Taps run after the input filter, so they see only what is sent to the shell, including
+// held input once it is approved. Bytes of a ZMODEM transfer never reach the taps.
+func (s *Session) AddInputTap(fn func(data []byte)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inputTaps = append(s.inputTaps, fn)
+}
+
+// AddResizeTap registers fn to see every successful PTY resize. Call before Start.
+func (s *Session) AddResizeTap(fn func(rows, cols int)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resizeTaps = append(s.resizeTaps, fn)
+}
+
+// OnClose registers fn to run once when the session closes
+func (s *Session) OnClose(fn func(status ExitStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onClose = append(s.onClose, fn)
+}
+
+// Start pumps the shell output to the attached client. The session closes when stdout ends.
+func (s *Session) Start(stdout, stderr io.Reader) {
+	go s.pump("stdout", stdout, true)
+	go s.pump("stderr", stderr, false)
+}
+
+func (s *Session) pump(name string, r io.Reader, closeOnEOF bool) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			utils.LogError("SSH %s Loop Panic: %v\nStack: %s", name, rec, string(debug.Stack()))
+			s.Close(ReasonExited)
+		}
+	}()
+
+	buf := make([]byte, 8192)
+	for {
+		// Stop reading the channel while the client catches up, so SSH flow control
+		// pushes back on the remote program instead of output piling up here
+		s.waitForClient()
+
+		n, err := r.Read(buf)
+		if n > 0 {
+			s.output(buf[:n])
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Error reading from %s: %v", name, err)
+			}
+			if closeOnEOF {
+				if err == io.EOF {
+					s.finish(s.waitExit())
+				} else {
+					s.Close(ReasonExited)
+				}
+			}
+			return
+		}
+	}
+}
+
+// waitForClient blocks while the attached client has too much unacknowledged output
+func (s *Session) waitForClient() {
+	s.mu.Lock()
+	attached := s.attached
+	s.mu.Unlock()
+
+	if fc, ok := attached.(FlowControlled); ok {
+		fc.WaitWritable()
+	}
+}
+
+// output stores data in the scrollback and forwards it to the taps and the attached client.
+// While a ZMODEM transfer runs, output goes to the attached client only, as transfer data.
+func (s *Session) output(data []byte) {
+	s.bytesOut.Add(uint64(len(data)))
+
+	s.mu.Lock()
+	if s.transfer != nil {
+		data = s.transferOutput(data)
+	} else if s.attached != nil {
+		text, rest, started := s.detectTransfer(data)
+		if started {
+			// The text before the transfer must reach the client ahead of the transfer data
+			s.terminalOutput(text)
+			s.ownerOutput(s.attached, text)
+			data = s.transferOutput(rest)
+		}
+	}
+	s.terminalOutput(data)
+	attached := s.attached
+	s.mu.Unlock()
+
+	// Written without the lock, so a slow client does not hold up the rest of the session.
+	// Each pump writes its own output, so it still arrives in order.
+	s.ownerOutput(attached, data)
+}
+
+// ownerOutput writes terminal output to the attached client
+func (s *Session) ownerOutput(c Client, data []byte) {
+	if c == nil || len(data) == 0 {
+		return
+	}
+	if err := c.Output(data); err != nil {
+		log.Printf("Error writing to session %s client: %v", s.ID, err)
+	}
+}
+
+// terminalOutput delivers regular terminal output to everyone but the attached client.
+// Called with s.mu held.
+func (s *Session) terminalOutput(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	s.scrollback.Write(data)
+	for _, tap := range s.taps {
+		tap(data)
+	}
+	if len(s.viewers) == 0 {
+		return
+	}
+
+	// data is the pump's read buffer: viewers write later, from their own copy
+	queued := append([]byte(nil), data...)
+	for id, v := range s.viewers {
+		if v.deliver(queued) {
+			continue
+		}
+		delete(s.viewers, id)
+		v.stop(nil)
+		go v.client.Close("disconnected for falling too far behind the session")
+		if !v.Shadow && s.attached != nil {
+			go s.attached.Send("viewer_left", v.Viewer)
+		}
+	}
+}
+
+// Attach makes c the session's client, replaying the scrollback first.
+// A client that was already attached is disconnected.
+func (s *Session) Attach(c Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		c.Close(ReasonExited)
+		return
+	}
+
+	if s.graceTimer != nil {
+		s.graceTimer.Stop()
+		s.graceTimer = nil
+	}
+	if s.attached != nil && s.attached != c {
+		s.abortTransfer()
+		s.attached.Close("attached from another client")
+	}
+
+	if replay := s.scrollback.Bytes(); len(replay) > 0 {
+		c.Output(replay)
+	}
+	s.attached = c
+	s.detachedAt = nil
+}
+
+// Detach releases c if it is the attached client and starts the grace period.
+// It reports whether the session is now detached because of this call.
+func (s *Session) Detach(c Client) bool {
+	s.mu.Lock()
+	if s.closed || s.attached != c {
+		s.mu.Unlock()
+		return false
+	}
+
+	s.attached = nil
+	s.abortTransfer()
+	if s.grace <= 0 {
+		s.mu.Unlock()
+		s.Close(ReasonDisconnected)
+		return false
+	}
+
+	now := time.Now()
+	s.detachedAt = &now
+	s.graceTimer = time.AfterFunc(s.grace, func() {
+		s.Close(ReasonDetachTimeout)
+	})
+	s.mu.Unlock()
+	return true
+}
+
+// SetInputFilter installs f to check all user input. Call before Start.
+func (s *Session) SetInputFilter(f InputFilter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filter = f
+}
+
+// Input writes user input to the remote shell
+func (s *Session) Input(data []byte) error {
+	s.mu.Lock()
+	taps := s.inputTaps
+	filter := s.filter
+	s.mu.Unlock()
+
+	if filter != nil {
+		data = filter.Filter(data)
+	}
+	return s.forward(taps, data)
+}
+
+// ResolveInput answers input held back by the input filter
+func (s *Session) ResolveInput(id string, approve bool, outcome string) error {
+	s.mu.Lock()
+	taps := s.inputTaps
+	filter := s.filter
+	s.mu.Unlock()
+
+	if filter == nil {
+		return nil
+	}
+	return s.forward(taps, filter.Resolve(id, approve, outcome))
+}
+
+func (s *Session) forward(taps []func(data []byte), data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	for _, tap := range taps {
+		tap(data)
+	}
+	return s.write(data)
+}
+
+// write sends raw bytes to the remote shell
+func (s *Session) write(data []byte) error {
+	s.stdinMu.Lock()
+	defer s.stdinMu.Unlock()
+
+	n, err := s.stdin.Write(data)
+	s.bytesIn.Add(uint64(n))
+	return err
+}
+
+// Resize changes the remote PTY size
+func (s *Session) Resize(rows, cols int) error {
+	if err := s.client.Resize(rows, cols); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	taps := s.resizeTaps
+	s.mu.Unlock()
+	for _, tap := range taps {
+		tap(rows, cols)
+	}
+	return nil
+}
+
+// Close ends the session: the attached client is disconnected and the SSH connection closed
+func (s *Session) Close(reason string) {
+	s.finish(ExitStatus{Reason: reason})
+}
+
+// finish closes the session with its final status
+func (s *Session) finish(status ExitStatus) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.exit = status
+	if s.transfer != nil {
+		s.transfer.watchdog.Stop()
+		s.transfer = nil
+	}
+	if s.graceTimer != nil {
+		s.graceTimer.Stop()
+		s.graceTimer = nil
+	}
+	attached := s.attached
+	s.attached = nil
+	viewers := s.viewers
+	s.viewers = make(map[string]*viewerConn)
+	s.shares = make(map[string]*Share)
+	callbacks := s.onClose
+	s.mu.Unlock()
+
+	if attached != nil {
+		attached.Exit(status)
+	}
+	for _, v := range viewers {
+		client := v.client
+		v.stop(func() { client.Exit(status) })
+	}
+	s.client.Close()
+	close(s.done)
+
+	if s.registry != nil {
+		s.registry.remove(s.ID)
+	}
+	Groups.sessionEnded(s.ID)
+	for _, fn := range callbacks {
+		fn(status)
+	}
+}
+
+// Kill forcibly closes the session on behalf of an administrator
+func (s *Session) Kill(reason string) {
+	s.mu.Lock()
+	if !s.closed {
+		s.killed = true
+	}
+	s.mu.Unlock()
+	s.Close(reason)
+}
+
+// Killed reports whether the session was ended with Kill
+func (s *Session) Killed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.killed
+}
+
+// ExitStatus returns how the session ended; it is only meaningful once Done is closed
+func (s *Session) ExitStatus() ExitStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exit
+}
+
+// Done is closed when the session ends
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Status returns a snapshot of the session as its owner may see it, without admin shadows
+func (s *Session) Status() Status {
+	return s.status(false)
+}
+
+// MonitorStatus returns a snapshot of the session including admin shadows, for admins
+func (s *Session) MonitorStatus() Status {
+	return s.status(true)
+}
+
+func (s *Session) status(shadows bool) Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	viewers := make([]Viewer, 0, len(s.viewers))
+	for _, v := range s.viewers {
+		if v.Shadow && !shadows {
+			continue
+		}
+		viewers = append(viewers, v.Viewer)
+	}
+	sort.Slice(viewers, func(i, j int) bool {
+		return viewers[i].JoinedAt.Before(viewers[j].JoinedAt)
+	})
+
+	return Status{
+		Info:       s.Info,
+		Attached:   s.attached != nil,
+		DetachedAt: s.detachedAt,
+		Viewers:    viewers,
+		BytesIn:    s.bytesIn.Load(),
+		BytesOut:   s.bytesOut.Load(),
+	}
+}
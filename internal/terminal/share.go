@@ -0,0 +1,301 @@
+package terminal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Share modes
+const (
+	ModeReadOnly = "read-only" // Viewers only see output
+	ModeCopilot  = "copilot"   // Viewers may also type
+)
+
+var (
+	ErrShareNotFound = errors.New("share not found or expired")
+	ErrSessionClosed = errors.New("session is closed")
+)
+
+// Share is a link that lets other users join a session
+type Share struct {
+	Token     string     `json:"token"`
+	Mode      string     `json:"mode"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // nil lasts as long as the session
+}
+
+// Expired reports whether the share can no longer be used
+func (sh *Share) Expired() bool {
+	return sh.ExpiresAt != nil && time.Now().After(*sh.ExpiresAt)
+}
+
+// Viewer is a user watching (or co-piloting) a session through a share
+type Viewer struct {
+	ID         string    `json:"id"`
+	UserID     uint      `json:"user_id"`
+	Username   string    `json:"username"`
+	Mode       string    `json:"mode"`
+	ClientIP   string    `json:"client_ip"`
+	ShareToken string    `json:"-"`
+	Shadow     bool      `json:"shadow"` // Admin watching without a share; the owner is not notified
+	JoinedAt   time.Time `json:"joined_at"`
+}
+
+// A viewer is disconnected when its unwritten output exceeds viewerMaxPending bytes or
+// viewerQueueSize chunks
+const (
+	viewerMaxPending = 4 * 1024 * 1024
+	viewerQueueSize  = 4096
+)
+
+// viewerConn is a connected viewer. Output is queued and written by the viewer's own
+// goroutine, so a viewer that stops reading never holds up the session.
+type viewerConn struct {
+	Viewer
+	client  Client
+	queue   chan []byte
+	pending atomic.Int64 // Bytes queued but not yet written
+	final   func()       // Runs once the queue is drained after stop
+}
+
+func newViewerConn(viewer Viewer, c Client, replay []byte) *viewerConn {
+	v := &viewerConn{Viewer: viewer, client: c, queue: make(chan []byte, viewerQueueSize)}
+	if len(replay) > 0 {
+		v.pending.Add(int64(len(replay)))
+		v.queue <- replay
+	}
+	go v.run()
+	return v
+}
+
+func (v *viewerConn) run() {
+	for data := range v.queue {
+		// A failed write means the viewer is gone; its reader removes it
+		v.client.Output(data)
+		v.pending.Add(-int64(len(data)))
+	}
+	if v.final != nil {
+		v.final()
+	}
+}
+
+// deliver queues output without blocking and reports false if the viewer is too far behind.
+// Called with s.mu held, only for viewers still in s.viewers.
+func (v *viewerConn) deliver(data []byte) bool {
+	if v.pending.Load()+int64(len(data)) > viewerMaxPending {
+		return false
+	}
+	select {
+	case v.queue <- data:
+		v.pending.Add(int64(len(data)))
+		return true
+	default:
+		return false
+	}
+}
+
+// stop ends the writer once the queued output is written, then runs final.
+// Call once, after removing the viewer from s.viewers.
+func (v *viewerConn) stop(final func()) {
+	v.final = final
+	close(v.queue)
+}
+
+// newID returns a random hex identifier, in the style of utils.GenerateTicket
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// CreateShare mints a share token; ttl of zero keeps it valid until the session ends or it is revoked
+func (s *Session) CreateShare(mode string, ttl time.Duration) (*Share, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil, ErrSessionClosed
+	}
+
+	share := &Share{
+		Token:     newID(),
+		Mode:      mode,
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		expires := share.CreatedAt.Add(ttl)
+		share.ExpiresAt = &expires
+	}
+	s.shares[share.Token] = share
+	return share, nil
+}
+
+// Shares returns the session's active shares, oldest first
+func (s *Session) Shares() []Share {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]Share, 0, len(s.shares))
+	for token, share := range s.shares {
+		if share.Expired() {
+			delete(s.shares, token)
+			continue
+		}
+		list = append(list, *share)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.Before(list[j].CreatedAt)
+	})
+	return list
+}
+
+// RevokeShare invalidates a share and disconnects everyone who joined through it
+func (s *Session) RevokeShare(token string) bool {
+	s.mu.Lock()
+	if _, ok := s.shares[token]; !ok {
+		s.mu.Unlock()
+		return false
+	}
+	delete(s.shares, token)
+
+	var kicked []*viewerConn
+	for id, v := range s.viewers {
+		if v.ShareToken == token {
+			kicked = append(kicked, v)
+			delete(s.viewers, id)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, v := range kicked {
+		v.stop(nil)
+		go v.client.Close("access revoked by the session owner")
+		s.notifyOwner("viewer_left", v.Viewer)
+	}
+	return true
+}
+
+// share returns a valid share by token
+func (s *Session) share(token string) (*Share, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	share, ok := s.shares[token]
+	if !ok || share.Expired() {
+		return nil, false
+	}
+	return share, true
+}
+
+// AddViewer joins c to the session through a share, replaying the scrollback first
+func (s *Session) AddViewer(token string, viewer Viewer, c Client) (Viewer, error) {
+	s.mu.Lock()
+	share, ok := s.shares[token]
+	if s.closed || !ok || share.Expired() {
+		s.mu.Unlock()
+		return Viewer{}, ErrShareNotFound
+	}
+
+	viewer.ID = newID()
+	viewer.Mode = share.Mode
+	viewer.ShareToken = token
+	viewer.JoinedAt = time.Now()
+
+	s.viewers[viewer.ID] = newViewerConn(viewer, c, s.scrollback.Bytes())
+	owner := s.attached
+	s.mu.Unlock()
+
+	if owner != nil {
+		owner.Send("viewer_joined", viewer)
+	}
+	return viewer, nil
+}
+
+// AddShadow joins c as a silent read-only viewer, used by admins to monitor a session
+func (s *Session) AddShadow(viewer Viewer, c Client) (Viewer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return Viewer{}, ErrSessionClosed
+	}
+
+	viewer.ID = newID()
+	viewer.Mode = ModeReadOnly
+	viewer.Shadow = true
+	viewer.JoinedAt = time.Now()
+
+	s.viewers[viewer.ID] = newViewerConn(viewer, c, s.scrollback.Bytes())
+	return viewer, nil
+}
+
+// RemoveViewer drops a viewer that disconnected on its own
+func (s *Session) RemoveViewer(id string) {
+	s.mu.Lock()
+	v, ok := s.viewers[id]
+	delete(s.viewers, id)
+	s.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	v.stop(nil)
+	if !v.Shadow {
+		s.notifyOwner("viewer_left", v.Viewer)
+	}
+}
+
+// KickViewer disconnects a single viewer. Admin shadows are not the owner's to kick.
+func (s *Session) KickViewer(id string) bool {
+	s.mu.Lock()
+	v, ok := s.viewers[id]
+	if !ok || v.Shadow {
+		s.mu.Unlock()
+		return false
+	}
+	delete(s.viewers, id)
+	s.mu.Unlock()
+
+	v.stop(nil)
+	go v.client.Close("access revoked by the session owner")
+	s.notifyOwner("viewer_left", v.Viewer)
+	return true
+}
+
+// CanInput reports whether a viewer is still connected with input rights
+func (s *Session) CanInput(viewerID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.viewers[viewerID]
+	return ok && v.Mode == ModeCopilot
+}
+
+// Notify sends a control message to the attached client, if there is one
+func (s *Session) Notify(msgType string, data interface{}) {
+	s.notifyOwner(msgType, data)
+}
+
+func (s *Session) notifyOwner(msgType string, data interface{}) {
+	s.mu.Lock()
+	owner := s.attached
+	s.mu.Unlock()
+
+	if owner != nil {
+		owner.Send(msgType, data)
+	}
+}
+
+// FindShare returns the session a share token belongs to
+func (r *Registry) FindShare(token string) (*Session, *Share, bool) {
+	for _, s := range r.List() {
+		if share, ok := s.share(token); ok {
+			return s, share, true
+		}
+	}
+	return nil, nil, false
+}
@@ -0,0 +1,272 @@
+package terminal
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/ihxw/termiscope/internal/zmodem"
+)
+
+// ErrNoTransfer is returned for ZMODEM data from a client that is not running a transfer
+var ErrNoTransfer = errors.New("no ZMODEM transfer in progress")
+
+// transferIdleTimeout ends a ZMODEM transfer that stopped moving
+const transferIdleTimeout = time.Minute
+
+// transferProgressInterval throttles progress messages to the client
+const transferProgressInterval = 250 * time.Millisecond
+
+// TransferClient is implemented by clients that can run ZMODEM transfers in the browser
+type TransferClient interface {
+	// TransferData sends raw ZMODEM bytes from the remote side
+	TransferData(data []byte) error
+}
+
+// TransferFile is one file of a ZMODEM transfer
+type TransferFile struct {
+	TransferID string `json:"transfer_id"`
+	Direction  string `json:"direction"` // zmodem.Download or zmodem.Upload
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`   // Announced size, -1 if unknown
+	Bytes      int64  `json:"bytes"`  // Bytes transferred so far
+	Status     string `json:"status"` // transferring, complete, skipped, aborted
+}
+
+// transfer is a ZMODEM transfer in progress. The session's output goes to the
+// attached client as raw ZMODEM data instead of terminal output while it runs.
+type transfer struct {
+	id           string
+	direction    string
+	client       Client
+	remote       *zmodem.Parser // Bytes from the remote shell
+	local        *zmodem.Parser // Bytes from the browser
+	file         *TransferFile
+	remoteFin    bool
+	localFin     bool
+	awaitOO      bool // Both sides sent ZFIN, the sender still sends "OO"
+	lastProgress time.Time
+	watchdog     *time.Timer
+}
+
+// OnTransfer registers fn to run whenever a transferred file completes, is skipped or aborted
+func (s *Session) OnTransfer(fn func(file TransferFile)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onTransfer = append(s.onTransfer, fn)
+}
+
+// detectTransfer checks output for the start of a ZMODEM transfer and starts one if the
+// attached client can take it. It returns the terminal output before the transfer started.
+// Called with s.mu held.
+func (s *Session) detectTransfer(data []byte) (text, rest []byte, started bool) {
+	direction, offset, ok := zmodem.Detect(s.zmodemTail, data)
+	if !ok {
+		s.zmodemTail = zmodem.Tail(s.zmodemTail, data)
+		return data, nil, false
+	}
+	s.zmodemTail = nil
+
+	if _, ok := s.attached.(TransferClient); !ok {
+		// Nobody can answer: cancel so the remote rz/sz does not hang
+		go s.write(zmodem.CancelSequence)
+		return data, nil, false
+	}
+
+	t := &transfer{
+		id:        newID(),
+		direction: direction,
+		client:    s.attached,
+		remote:    zmodem.NewParser(),
+		local:     zmodem.NewParser(),
+	}
+	t.watchdog = time.AfterFunc(transferIdleTimeout, func() {
+		s.CancelTransfer()
+	})
+	s.transfer = t
+	t.client.Send("zmodem_start", map[string]string{"id": t.id, "direction": direction})
+	return data[:offset], data[offset:], true
+}
+
+// transferOutput forwards remote ZMODEM bytes to the client.
+// It returns any output that followed the end of the transfer. Called with s.mu held.
+func (s *Session) transferOutput(data []byte) []byte {
+	t := s.transfer
+	t.watchdog.Reset(transferIdleTimeout)
+
+	if t.awaitOO && t.direction == zmodem.Download {
+		// The sender's closing "OO" belongs to the transfer, anything after it to the terminal
+		end := 0
+		if i := bytes.Index(data, []byte("OO")); i >= 0 {
+			end = i + 2
+		}
+		if end > 0 {
+			t.client.(TransferClient).TransferData(data[:end])
+		}
+		s.endTransfer(false)
+		return data[end:]
+	}
+
+	t.client.(TransferClient).TransferData(data)
+	for _, e := range t.remote.Feed(data) {
+		s.transferEvent(e, true)
+		if s.transfer == nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// TransferInput writes browser ZMODEM bytes to the remote shell. Only the client running
+// the transfer may send them; anything else is dropped with ErrNoTransfer, since these
+// bytes bypass the input filter. The bytes are parsed as they come: the first that is not
+// ZMODEM, or a frame with a bad CRC, ends the transfer and goes to Input with the rest.
+func (s *Session) TransferInput(from Client, data []byte) error {
+	s.mu.Lock()
+	t := s.transfer
+	if t == nil || t.client != from {
+		s.mu.Unlock()
+		return ErrNoTransfer
+	}
+	t.watchdog.Reset(transferIdleTimeout)
+
+	if t.awaitOO {
+		// Upload finished: the browser's closing "OO" is all that is left of the transfer.
+		// Whatever follows is ordinary input and goes through the filter and input taps.
+		end := 0
+		if i := bytes.Index(data, []byte("OO")); i >= 0 {
+			end = i + 2
+		}
+		s.endTransfer(false)
+		s.mu.Unlock()
+		if err := s.write(data[:end]); err != nil || end == len(data) {
+			return err
+		}
+		return s.Input(data[end:])
+	}
+
+	for _, e := range t.local.Feed(data) {
+		if e.Kind == zmodem.EventInvalid {
+			s.endTransfer(true)
+			s.mu.Unlock()
+			if err := s.write(data[:e.Offset]); err != nil {
+				return err
+			}
+			return s.Input(data[e.Offset:])
+		}
+		s.transferEvent(e, false)
+		if s.transfer == nil {
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	return s.write(data)
+}
+
+// CancelTransfer aborts the running transfer on both sides
+func (s *Session) CancelTransfer() {
+	s.mu.Lock()
+	if s.transfer == nil {
+		s.mu.Unlock()
+		return
+	}
+	s.endTransfer(true)
+	s.mu.Unlock()
+
+	s.write(zmodem.CancelSequence)
+}
+
+// EndTransfer leaves transfer mode once the browser has finished
+func (s *Session) EndTransfer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.transfer != nil {
+		s.endTransfer(false)
+	}
+}
+
+// transferEvent updates the transfer from what a parser saw. Called with s.mu held.
+func (s *Session) transferEvent(e zmodem.Event, fromRemote bool) {
+	t := s.transfer
+
+	switch e.Kind {
+	case zmodem.EventFile:
+		s.finishFile("aborted")
+		t.file = &TransferFile{
+			TransferID: t.id,
+			Direction:  t.direction,
+			Name:       e.Name,
+			Size:       e.Size,
+			Status:     "transferring",
+		}
+		t.client.Send("zmodem_progress", *t.file)
+	case zmodem.EventData:
+		if t.file != nil {
+			t.file.Bytes = e.Position
+			if time.Since(t.lastProgress) >= transferProgressInterval {
+				t.lastProgress = time.Now()
+				t.client.Send("zmodem_progress", *t.file)
+			}
+		}
+	case zmodem.EventEOF:
+		if t.file != nil {
+			t.file.Bytes = e.Position
+		}
+		s.finishFile("complete")
+	case zmodem.EventSkip:
+		s.finishFile("skipped")
+	case zmodem.EventFin:
+		if fromRemote {
+			t.remoteFin = true
+		} else {
+			t.localFin = true
+		}
+		if t.remoteFin && t.localFin {
+			// The sender closes with "OO" after the receiver's ZFIN
+			t.awaitOO = true
+		}
+	case zmodem.EventAbort:
+		s.endTransfer(true)
+	}
+}
+
+// finishFile reports the current file. Called with s.mu held.
+func (s *Session) finishFile(status string) {
+	t := s.transfer
+	if t.file == nil {
+		return
+	}
+	file := *t.file
+	t.file = nil
+
+	file.Status = status
+	t.client.Send("zmodem_progress", file)
+	for _, fn := range s.onTransfer {
+		go fn(file)
+	}
+}
+
+// endTransfer leaves transfer mode. Called with s.mu held.
+func (s *Session) endTransfer(aborted bool) {
+	t := s.transfer
+	status := "complete"
+	if aborted {
+		status = "aborted"
+	}
+	s.finishFile(status)
+
+	t.watchdog.Stop()
+	s.transfer = nil
+	t.client.Send("zmodem_end", map[string]interface{}{"id": t.id, "aborted": aborted})
+}
+
+// abortTransfer cancels a transfer whose client went away. Called with s.mu held.
+func (s *Session) abortTransfer() {
+	if s.transfer == nil {
+		return
+	}
+	s.endTransfer(true)
+	go s.write(zmodem.CancelSequence)
+}
@@ -0,0 +1,273 @@
+package tunnel
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ihxw/termiscope/internal/models"
+	"github.com/ihxw/termiscope/internal/ssh"
+	"github.com/ihxw/termiscope/internal/utils"
+)
+
+const (
+	StateConnecting   = "connecting"
+	StateActive       = "active"
+	StateReconnecting = "reconnecting"
+	StateStopped      = "stopped"
+
+	maxBackoff = 1 * time.Minute
+)
+
+// ConnectFunc opens a connected SSH client for the tunnel's host
+type ConnectFunc func(t *models.Tunnel) (*ssh.SSHClient, error)
+
+// Status is a point-in-time snapshot of a tunnel
+type Status struct {
+	State         string     `json:"state"`
+	BytesSent     uint64     `json:"bytes_sent"`     // Listener side -> target
+	BytesReceived uint64     `json:"bytes_received"` // Target -> listener side
+	Connections   int64      `json:"connections"`    // Currently open forwarded connections
+	LastError     string     `json:"last_error,omitempty"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+}
+
+// Manager runs tunnels and keeps them alive across SSH disconnects
+type Manager struct {
+	mu      sync.Mutex
+	runners map[uint]*runner
+	connect ConnectFunc
+}
+
+func NewManager(connect ConnectFunc) *Manager {
+	return &Manager{
+		runners: make(map[uint]*runner),
+		connect: connect,
+	}
+}
+
+// Start starts a tunnel; starting a running tunnel is a no-op
+func (m *Manager) Start(t models.Tunnel) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.runners[t.ID]; ok {
+		return
+	}
+
+	r := &runner{
+		tunnel:    t,
+		connect:   m.connect,
+		stop:      make(chan struct{}),
+		state:     StateConnecting,
+		startedAt: time.Now(),
+	}
+	m.runners[t.ID] = r
+	go r.run()
+}
+
+// Stop stops a tunnel and closes all of its connections
+func (m *Manager) Stop(id uint) {
+	m.mu.Lock()
+	r, ok := m.runners[id]
+	delete(m.runners, id)
+	m.mu.Unlock()
+
+	if ok {
+		close(r.stop)
+	}
+}
+
+// Status returns the current status of a tunnel
+func (m *Manager) Status(id uint) Status {
+	m.mu.Lock()
+	r, ok := m.runners[id]
+	m.mu.Unlock()
+
+	if !ok {
+		return Status{State: StateStopped}
+	}
+	return r.status()
+}
+
+// runner owns one tunnel: its SSH client, listener and forwarded connections
+type runner struct {
+	tunnel  models.Tunnel
+	connect ConnectFunc
+	stop    chan struct{}
+
+	bytesSent     atomic.Uint64
+	bytesReceived atomic.Uint64
+	connections   atomic.Int64
+
+	mu        sync.Mutex
+	state     string
+	lastError string
+	startedAt time.Time
+}
+
+func (r *runner) status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	startedAt := r.startedAt
+	return Status{
+		State:         r.state,
+		BytesSent:     r.bytesSent.Load(),
+		BytesReceived: r.bytesReceived.Load(),
+		Connections:   r.connections.Load(),
+		LastError:     r.lastError,
+		StartedAt:     &startedAt,
+	}
+}
+
+func (r *runner) setState(state string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.state = state
+	if err != nil {
+		r.lastError = err.Error()
+	}
+}
+
+// run connects, serves until the SSH connection drops, and reconnects with backoff until stopped
+func (r *runner) run() {
+	defer func() {
+		if rec := recover(); rec != nil {
+			utils.LogError("Tunnel %d Panic: %v\nStack: %s", r.tunnel.ID, rec, string(debug.Stack()))
+			r.setState(StateStopped, fmt.Errorf("internal error"))
+		}
+	}()
+
+	backoff := time.Second
+	for {
+		err := r.serveOnce()
+
+		select {
+		case <-r.stop:
+			r.setState(StateStopped, nil)
+			return
+		default:
+		}
+
+		r.setState(StateReconnecting, err)
+		log.Printf("Tunnel %d (%s): connection lost, retrying in %s: %v", r.tunnel.ID, r.tunnel.Name, backoff, err)
+
+		select {
+		case <-r.stop:
+			r.setState(StateStopped, nil)
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// serveOnce runs the tunnel over a single SSH connection and returns when it ends
+func (r *runner) serveOnce() error {
+	client, err := r.connect(&r.tunnel)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	raw := client.GetRawClient()
+
+	listenAddr := net.JoinHostPort(r.tunnel.ListenHost, strconv.Itoa(r.tunnel.ListenPort))
+	var listener net.Listener
+	if r.tunnel.Type == "remote" {
+		listener, err = raw.Listen("tcp", listenAddr)
+	} else {
+		listener, err = net.Listen("tcp", listenAddr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
+	}
+	defer listener.Close()
+
+	r.setState(StateActive, nil)
+
+	// The SSH connection ending (or Stop) tears everything down
+	sshDone := make(chan error, 1)
+	go func() { sshDone <- raw.Wait() }()
+
+	go r.acceptLoop(listener, client)
+
+	select {
+	case <-r.stop:
+		return nil
+	case err := <-sshDone:
+		if err == nil {
+			err = fmt.Errorf("ssh connection closed")
+		}
+		return err
+	}
+}
+
+func (r *runner) acceptLoop(listener net.Listener, client *ssh.SSHClient) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.handle(conn, client)
+	}
+}
+
+// handle forwards one accepted connection according to the tunnel type
+func (r *runner) handle(conn net.Conn, client *ssh.SSHClient) {
+	defer conn.Close()
+	r.connections.Add(1)
+	defer r.connections.Add(-1)
+
+	var target net.Conn
+	var err error
+	switch r.tunnel.Type {
+	case "local":
+		target, err = client.GetRawClient().Dial("tcp", net.JoinHostPort(r.tunnel.TargetHost, strconv.Itoa(r.tunnel.TargetPort)))
+	case "remote":
+		target, err = net.DialTimeout("tcp", net.JoinHostPort(r.tunnel.TargetHost, strconv.Itoa(r.tunnel.TargetPort)), 10*time.Second)
+	case "dynamic":
+		var addr string
+		addr, err = socks5Handshake(conn)
+		if err == nil {
+			target, err = client.GetRawClient().Dial("tcp", addr)
+			socks5Reply(conn, err)
+		}
+	default:
+		err = fmt.Errorf("unknown tunnel type %q", r.tunnel.Type)
+	}
+	if err != nil {
+		log.Printf("Tunnel %d (%s): forward failed: %v", r.tunnel.ID, r.tunnel.Name, err)
+		return
+	}
+	defer target.Close()
+
+	r.pipe(conn, target)
+}
+
+// pipe copies in both directions until either side closes, counting bytes
+func (r *runner) pipe(conn, target net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		n, _ := io.Copy(target, conn)
+		r.bytesSent.Add(uint64(n))
+		done <- struct{}{}
+	}()
+	go func() {
+		n, _ := io.Copy(conn, target)
+		r.bytesReceived.Add(uint64(n))
+		done <- struct{}{}
+	}()
+	<-done
+}
@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Minimal SOCKS5 server side (RFC 1928): no authentication, CONNECT only
+
+const (
+	socksVersion = 0x05
+
+	socksCmdConnect = 0x01
+
+	socksAtypIPv4   = 0x01
+	socksAtypDomain = 0x03
+	socksAtypIPv6   = 0x04
+
+	socksReplySucceeded       = 0x00
+	socksReplyFailure         = 0x01
+	socksReplyCmdNotSupported = 0x07
+)
+
+// socks5Handshake negotiates with a SOCKS5 client and returns the requested host:port
+func socks5Handshake(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	// Only "no authentication required" is offered; the listener is bound locally
+	if _, err := conn.Write([]byte{socksVersion, 0x00}); err != nil {
+		return "", err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	if request[1] != socksCmdConnect {
+		conn.Write([]byte{socksVersion, socksReplyCmdNotSupported, 0x00, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+		return "", fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socksAtypIPv4:
+		addr := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", err
+		}
+		host = net.IP(addr).String()
+	case socksAtypIPv6:
+		addr := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", err
+		}
+		host = net.IP(addr).String()
+	case socksAtypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socks5Reply tells the client whether the CONNECT succeeded
+func socks5Reply(conn net.Conn, dialErr error) {
+	reply := byte(socksReplySucceeded)
+	if dialErr != nil {
+		reply = socksReplyFailure
+	}
+	// The bound address is not meaningful over SSH, report 0.0.0.0:0
+	conn.Write([]byte{socksVersion, reply, 0x00, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+}
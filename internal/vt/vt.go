@@ -0,0 +1,699 @@
+// Package vt is a small virtual terminal. It interprets terminal output the way xterm
+// would, closely enough to turn a session into the text a reader saw: cursor movement,
+// erasing and redraws are resolved, colors and other attributes are dropped.
+//
+// Lines that scroll off the top of the screen, or are cleared from it, make up the
+// transcript. Full-screen programs on the alternate screen add their last screen when
+// they exit.
+package vt
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// wideTail fills the cell covered by the right half of a wide character
+const wideTail = "\x00"
+
+// Limits on what the terminal accepts from the output it interprets
+const (
+	maxParam = 65535 // Larger CSI parameters are clamped
+	maxSeq   = 256   // Longer CSI sequences are ignored
+	maxSize  = 1000  // Largest number of columns or rows
+)
+
+// Parser states
+const (
+	stateGround = iota
+	stateEscape
+	stateCharset // ESC ( and friends take one more character
+	stateCSI
+	stateString    // OSC, DCS, APC, PM and SOS run until BEL or ST
+	stateStringEsc // ESC inside a string, ST if followed by a backslash
+)
+
+// Terminal is a virtual terminal. It is not safe for concurrent use.
+type Terminal struct {
+	cols, rows int
+	main, alt  *screen
+	scr        *screen // The screen being shown
+	autowrap   bool
+
+	lines   []string // Transcript so far
+	partial string   // Start of a line that wrapped onto rows not yet in the transcript
+
+	state    int
+	seq      []byte // CSI parameters and intermediates
+	overlong bool   // seq outgrew maxSeq, the sequence is ignored
+}
+
+// screen is the main or the alternate screen
+type screen struct {
+	cells    [][]string // One character per cell, "" for blank
+	wrapped  []bool     // The row continues on the next one
+	x, y     int
+	wrapNext bool // The last column was written; the next character wraps
+	top      int  // Scroll region, inclusive
+	bottom   int
+	savedX   int
+	savedY   int
+	emitted  int // Rows at the top already in the transcript
+	isMain   bool
+}
+
+// New returns a terminal of the given size
+func New(cols, rows int) *Terminal {
+	cols, rows = min(max(cols, 1), maxSize), min(max(rows, 1), maxSize)
+	t := &Terminal{cols: cols, rows: rows, autowrap: true}
+	t.main = newScreen(cols, rows)
+	t.main.isMain = true
+	t.alt = newScreen(cols, rows)
+	t.scr = t.main
+	return t
+}
+
+func newScreen(cols, rows int) *screen {
+	s := &screen{bottom: rows - 1}
+	s.cells = make([][]string, rows)
+	s.wrapped = make([]bool, rows)
+	for i := range s.cells {
+		s.cells[i] = make([]string, cols)
+	}
+	return s
+}
+
+// Write interprets terminal output
+func (t *Terminal) Write(data string) {
+	for _, r := range data {
+		t.feed(r)
+	}
+}
+
+// Resize changes the terminal size. Rows that no longer fit above the cursor scroll into
+// the transcript; lines are cut or padded, not reflowed.
+func (t *Terminal) Resize(cols, rows int) {
+	cols, rows = min(max(cols, 1), maxSize), min(max(rows, 1), maxSize)
+	for _, s := range []*screen{t.main, t.alt} {
+		if over := s.y + 1 - rows; over > 0 {
+			for i := 0; i < over; i++ {
+				t.commitTop(s)
+			}
+			s.cells = s.cells[over:]
+			s.wrapped = s.wrapped[over:]
+			s.y -= over
+		}
+		for len(s.cells) < rows {
+			s.cells = append(s.cells, make([]string, cols))
+			s.wrapped = append(s.wrapped, false)
+		}
+		s.cells = s.cells[:rows]
+		s.wrapped = s.wrapped[:rows]
+		for i, row := range s.cells {
+			if len(row) > cols {
+				s.cells[i] = row[:cols]
+			} else if len(row) < cols {
+				s.cells[i] = append(row, make([]string, cols-len(row))...)
+			}
+		}
+		s.top, s.bottom = 0, rows-1
+		s.x, s.y = min(s.x, cols-1), min(s.y, rows-1)
+		s.savedX, s.savedY = min(s.savedX, cols-1), min(s.savedY, rows-1)
+		s.emitted = min(s.emitted, rows)
+		s.wrapNext = false
+	}
+	t.cols, t.rows = cols, rows
+}
+
+// Transcript returns the text so far: the lines that left the screen, then what is on it.
+// Trailing blank lines are dropped.
+func (t *Terminal) Transcript() []string {
+	lines := append([]string(nil), t.lines...)
+	partial := t.partial
+	screenLines := func(s *screen, from int) {
+		for y := from; y <= lastUsedRow(s); y++ {
+			if s.wrapped[y] {
+				partial += t.rowText(s, y)
+				continue
+			}
+			lines = append(lines, partial+strings.TrimRight(t.rowText(s, y), " "))
+			partial = ""
+		}
+		if partial != "" {
+			lines = append(lines, strings.TrimRight(partial, " "))
+			partial = ""
+		}
+	}
+	screenLines(t.main, t.main.emitted)
+	if t.scr == t.alt {
+		// Still in a full-screen program
+		screenLines(t.alt, 0)
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Lines returns how many lines have left the screen for the transcript so far
+func (t *Terminal) Lines() int {
+	return len(t.lines)
+}
+
+func (t *Terminal) feed(r rune) {
+	switch t.state {
+	case stateGround:
+		t.ground(r)
+	case stateEscape:
+		t.escape(r)
+	case stateCharset:
+		t.state = stateGround
+	case stateCSI:
+		switch {
+		case r >= 0x40 && r <= 0x7e:
+			t.csi(r)
+			t.state = stateGround
+		case r >= 0x20 && r < 0x40:
+			if len(t.seq) < maxSeq {
+				t.seq = append(t.seq, byte(r))
+			} else {
+				t.overlong = true
+			}
+		case r == 0x1b:
+			t.state = stateEscape
+		case r < 0x20:
+			t.control(r) // Executed in the middle of a sequence, as xterm does
+		default:
+			t.state = stateGround
+		}
+	case stateString:
+		switch r {
+		case 0x07:
+			t.state = stateGround
+		case 0x1b:
+			t.state = stateStringEsc
+		}
+	case stateStringEsc:
+		t.state = stateGround
+		if r != '\\' {
+			t.feed(r)
+		}
+	}
+}
+
+func (t *Terminal) ground(r rune) {
+	switch {
+	case r == 0x1b:
+		t.state = stateEscape
+	case r < 0x20 || r == 0x7f:
+		t.control(r)
+	case r >= 0x80 && r < 0xa0:
+		// C1 controls: only CSI is worth following
+		if r == 0x9b {
+			t.startCSI()
+		}
+	default:
+		t.put(r)
+	}
+}
+
+func (t *Terminal) control(r rune) {
+	s := t.scr
+	switch r {
+	case '\b':
+		if s.x > 0 {
+			s.x--
+		}
+		s.wrapNext = false
+	case '\t':
+		s.x = min((s.x/8+1)*8, t.cols-1)
+		s.wrapNext = false
+	case '\n', '\v', '\f':
+		t.lineFeed()
+	case '\r':
+		s.x = 0
+		s.wrapNext = false
+	}
+}
+
+func (t *Terminal) escape(r rune) {
+	t.state = stateGround
+	s := t.scr
+	switch r {
+	case '[':
+		t.startCSI()
+	case ']', 'P', '_', '^', 'X':
+		t.state = stateString
+	case '(', ')', '*', '+', '-', '.', '/', '#', '%', ' ':
+		t.state = stateCharset
+	case '7':
+		s.savedX, s.savedY = s.x, s.y
+	case '8':
+		s.x, s.y = s.savedX, s.savedY
+		s.wrapNext = false
+	case 'D':
+		t.lineFeed()
+	case 'E':
+		t.lineFeed()
+		s.x = 0
+	case 'M':
+		t.reverseIndex()
+	case 'c':
+		t.reset()
+	}
+}
+
+func (t *Terminal) startCSI() {
+	t.seq = t.seq[:0]
+	t.overlong = false
+	t.state = stateCSI
+}
+
+func (t *Terminal) csi(final rune) {
+	if t.overlong {
+		return
+	}
+	s := t.scr
+	private := len(t.seq) > 0 && (t.seq[0] == '?' || t.seq[0] == '>' || t.seq[0] == '=' || t.seq[0] == '<')
+	params := t.seq
+	if private {
+		params = params[1:]
+	}
+	// Sequences with intermediates (cursor style, soft reset, ...) do not affect the text
+	if strings.ContainsAny(string(params), " !\"#$%&'()*+,-./") {
+		return
+	}
+	args := parseParams(string(params))
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return def
+	}
+
+	if private {
+		if t.seq[0] == '?' && (final == 'h' || final == 'l') {
+			for _, mode := range args {
+				t.privateMode(mode, final == 'h')
+			}
+		}
+		return
+	}
+
+	switch final {
+	case 'A':
+		s.y = max(s.y-arg(0, 1), 0)
+	case 'B', 'e':
+		s.y = min(s.y+arg(0, 1), t.rows-1)
+	case 'C', 'a':
+		s.x = min(s.x+arg(0, 1), t.cols-1)
+	case 'D':
+		s.x = max(s.x-arg(0, 1), 0)
+	case 'E':
+		s.y = min(s.y+arg(0, 1), t.rows-1)
+		s.x = 0
+	case 'F':
+		s.y = max(s.y-arg(0, 1), 0)
+		s.x = 0
+	case 'G', '`':
+		s.x = min(arg(0, 1), t.cols) - 1
+	case 'H', 'f':
+		s.y = min(arg(0, 1), t.rows) - 1
+		s.x = min(arg(1, 1), t.cols) - 1
+	case 'd':
+		s.y = min(arg(0, 1), t.rows) - 1
+	case 'J':
+		t.eraseDisplay(arg(0, 0))
+	case 'K':
+		t.eraseLine(arg(0, 0))
+	case 'L':
+		t.insertLines(arg(0, 1))
+	case 'M':
+		t.deleteLines(arg(0, 1))
+	case 'P':
+		row := s.cells[s.y]
+		n := min(arg(0, 1), t.cols-s.x)
+		copy(row[s.x:], row[s.x+n:])
+		clearCells(row[t.cols-n:])
+	case '@':
+		row := s.cells[s.y]
+		n := min(arg(0, 1), t.cols-s.x)
+		copy(row[s.x+n:], row[s.x:t.cols-n])
+		clearCells(row[s.x : s.x+n])
+	case 'X':
+		clearCells(s.cells[s.y][s.x:min(s.x+arg(0, 1), t.cols)])
+	case 'S':
+		t.scrollUp(arg(0, 1))
+	case 'T':
+		t.scrollDown(arg(0, 1))
+	case 'r':
+		top, bottom := arg(0, 1)-1, min(arg(1, t.rows), t.rows)-1
+		if top < bottom {
+			s.top, s.bottom = top, bottom
+			s.x, s.y = 0, 0
+		}
+	case 's':
+		s.savedX, s.savedY = s.x, s.y
+	case 'u':
+		s.x, s.y = s.savedX, s.savedY
+	default:
+		return
+	}
+	s.wrapNext = false
+}
+
+func (t *Terminal) privateMode(mode int, set bool) {
+	switch mode {
+	case 7:
+		t.autowrap = set
+	case 47, 1047, 1049:
+		if set == (t.scr == t.alt) {
+			return
+		}
+		if set {
+			if mode == 1049 {
+				t.main.savedX, t.main.savedY = t.main.x, t.main.y
+			}
+			// What was typed before the program started belongs before its screen
+			for t.main.emitted < t.main.y {
+				t.commitRow(t.main, t.main.emitted)
+				t.main.emitted++
+			}
+			t.alt = newScreen(t.cols, t.rows)
+			t.scr = t.alt
+		} else {
+			t.flushPartial()
+			for y := 0; y <= lastUsedRow(t.alt); y++ {
+				t.commitRow(t.alt, y)
+			}
+			t.flushPartial()
+			t.scr = t.main
+			if mode == 1049 {
+				t.main.x, t.main.y = t.main.savedX, t.main.savedY
+			}
+		}
+	}
+}
+
+func (t *Terminal) put(r rune) {
+	s := t.scr
+	w := runeWidth(r)
+	if w == 0 {
+		// Combining characters join the character before them
+		x := s.x
+		if !s.wrapNext {
+			x--
+		}
+		if x >= 0 && s.cells[s.y][x] != "" && s.cells[s.y][x] != wideTail {
+			s.cells[s.y][x] += string(r)
+		}
+		return
+	}
+
+	if s.wrapNext || (w == 2 && s.x == t.cols-1 && t.cols > 1) {
+		if t.autowrap {
+			s.wrapped[s.y] = true
+			t.lineFeed()
+			s.x = 0
+		}
+		s.wrapNext = false
+	}
+
+	row := s.cells[s.y]
+	row[s.x] = string(r)
+	if w == 2 && s.x+1 < t.cols {
+		row[s.x+1] = wideTail
+	}
+	s.x += w
+	if s.x >= t.cols {
+		s.x = t.cols - 1
+		s.wrapNext = t.autowrap
+	}
+}
+
+func (t *Terminal) lineFeed() {
+	s := t.scr
+	s.wrapNext = false
+	switch {
+	case s.y == s.bottom:
+		t.scrollUp(1)
+	case s.y < t.rows-1:
+		s.y++
+	}
+}
+
+func (t *Terminal) reverseIndex() {
+	s := t.scr
+	s.wrapNext = false
+	if s.y == s.top {
+		t.scrollDown(1)
+	} else if s.y > 0 {
+		s.y--
+	}
+}
+
+// scrollUp moves the scroll region up n rows. Rows leaving the top of the main screen go
+// to the transcript.
+func (t *Terminal) scrollUp(n int) {
+	s := t.scr
+	n = min(n, s.bottom-s.top+1)
+	for i := 0; i < n; i++ {
+		if s.top == 0 {
+			t.commitTop(s)
+		}
+		t.removeRow(s, s.top, s.bottom)
+	}
+}
+
+func (t *Terminal) scrollDown(n int) {
+	s := t.scr
+	n = min(n, s.bottom-s.top+1)
+	for i := 0; i < n; i++ {
+		t.insertRow(s, s.top, s.bottom)
+	}
+	if s.top == 0 {
+		s.emitted = 0
+	}
+}
+
+func (t *Terminal) insertLines(n int) {
+	s := t.scr
+	if s.y < s.top || s.y > s.bottom {
+		return
+	}
+	for i := 0; i < min(n, s.bottom-s.y+1); i++ {
+		t.insertRow(s, s.y, s.bottom)
+	}
+	s.emitted = min(s.emitted, s.y)
+	s.x = 0
+}
+
+func (t *Terminal) deleteLines(n int) {
+	s := t.scr
+	if s.y < s.top || s.y > s.bottom {
+		return
+	}
+	for i := 0; i < min(n, s.bottom-s.y+1); i++ {
+		t.removeRow(s, s.y, s.bottom)
+	}
+	s.emitted = min(s.emitted, s.y)
+	s.x = 0
+}
+
+// removeRow deletes row y, moving the rows below it up to bottom and blanking bottom
+func (t *Terminal) removeRow(s *screen, y, bottom int) {
+	row := s.cells[y]
+	copy(s.cells[y:bottom], s.cells[y+1:bottom+1])
+	copy(s.wrapped[y:bottom], s.wrapped[y+1:bottom+1])
+	clearCells(row)
+	s.cells[bottom] = row
+	s.wrapped[bottom] = false
+}
+
+// insertRow inserts a blank row at y, moving the rows below it down and dropping bottom
+func (t *Terminal) insertRow(s *screen, y, bottom int) {
+	row := s.cells[bottom]
+	copy(s.cells[y+1:bottom+1], s.cells[y:bottom])
+	copy(s.wrapped[y+1:bottom+1], s.wrapped[y:bottom])
+	clearCells(row)
+	s.cells[y] = row
+	s.wrapped[y] = false
+}
+
+func (t *Terminal) eraseDisplay(mode int) {
+	s := t.scr
+	switch mode {
+	case 0:
+		if s.x == 0 && s.y == 0 {
+			t.clearScreen()
+			return
+		}
+		clearCells(s.cells[s.y][s.x:])
+		s.wrapped[s.y] = false
+		for y := s.y + 1; y < t.rows; y++ {
+			clearCells(s.cells[y])
+			s.wrapped[y] = false
+		}
+		s.emitted = min(s.emitted, s.y)
+	case 1:
+		for y := 0; y < s.y; y++ {
+			clearCells(s.cells[y])
+			s.wrapped[y] = false
+		}
+		clearCells(s.cells[s.y][:s.x+1])
+		s.emitted = 0
+	case 2:
+		t.clearScreen()
+	}
+}
+
+// clearScreen erases the whole screen; on the main screen what it showed goes to the
+// transcript first, as if it had scrolled away
+func (t *Terminal) clearScreen() {
+	s := t.scr
+	if s.isMain {
+		for y := s.emitted; y <= lastUsedRow(s); y++ {
+			t.commitRow(s, y)
+		}
+		t.flushPartial()
+	}
+	for y := range s.cells {
+		clearCells(s.cells[y])
+		s.wrapped[y] = false
+	}
+	s.emitted = 0
+}
+
+func (t *Terminal) eraseLine(mode int) {
+	s := t.scr
+	row := s.cells[s.y]
+	switch mode {
+	case 0:
+		clearCells(row[s.x:])
+		s.wrapped[s.y] = false
+	case 1:
+		clearCells(row[:s.x+1])
+	case 2:
+		clearCells(row)
+		s.wrapped[s.y] = false
+	}
+}
+
+func (t *Terminal) reset() {
+	t.scr = t.main
+	t.clearScreen()
+	t.autowrap = true
+	t.main = newScreen(t.cols, t.rows)
+	t.main.isMain = true
+	t.alt = newScreen(t.cols, t.rows)
+	t.scr = t.main
+}
+
+// commitTop sends the top row of a main screen to the transcript as it scrolls away,
+// unless it is already there
+func (t *Terminal) commitTop(s *screen) {
+	if !s.isMain {
+		return
+	}
+	if s.emitted > 0 {
+		s.emitted--
+		return
+	}
+	t.commitRow(s, 0)
+}
+
+// commitRow adds row y to the transcript, joining rows that wrapped into one line
+func (t *Terminal) commitRow(s *screen, y int) {
+	text := t.rowText(s, y)
+	if s.wrapped[y] {
+		t.partial += text
+		return
+	}
+	t.lines = append(t.lines, t.partial+strings.TrimRight(text, " "))
+	t.partial = ""
+}
+
+func (t *Terminal) flushPartial() {
+	if t.partial != "" {
+		t.lines = append(t.lines, strings.TrimRight(t.partial, " "))
+		t.partial = ""
+	}
+}
+
+func (t *Terminal) rowText(s *screen, y int) string {
+	var b strings.Builder
+	for _, c := range s.cells[y] {
+		switch c {
+		case "":
+			b.WriteByte(' ')
+		case wideTail:
+		default:
+			b.WriteString(c)
+		}
+	}
+	return b.String()
+}
+
+// lastUsedRow returns the last row with text or the cursor, whichever is lower
+func lastUsedRow(s *screen) int {
+	last := -1
+	for y, row := range s.cells {
+		for _, c := range row {
+			if c != "" {
+				last = y
+				break
+			}
+		}
+	}
+	if s.isMain && s.y > last && s.x > 0 {
+		last = s.y
+	}
+	return last
+}
+
+func clearCells(cells []string) {
+	for i := range cells {
+		cells[i] = ""
+	}
+}
+
+// parseParams splits CSI parameters, clamped to 0..maxParam; sub-parameters after a
+// colon are dropped
+func parseParams(s string) []int {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ";")
+	args := make([]int, len(parts))
+	for i, p := range parts {
+		p, _, _ = strings.Cut(p, ":")
+		n, _ := strconv.Atoi(p) // Out of range gives the largest int, then clamped
+		args[i] = min(max(n, 0), maxParam)
+	}
+	return args
+}
+
+// runeWidth returns how many cells a character takes: 0 for combining characters,
+// 2 for East Asian wide characters and most emoji
+func runeWidth(r rune) int {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case r >= 0x1100 && r <= 0x115f,
+		r >= 0x2e80 && r <= 0x303e,
+		r >= 0x3041 && r <= 0x33ff,
+		r >= 0x3400 && r <= 0x4dbf,
+		r >= 0x4e00 && r <= 0x9fff,
+		r >= 0xa000 && r <= 0xa4cf,
+		r >= 0xac00 && r <= 0xd7a3,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1f64f,
+		r >= 0x1f900 && r <= 0x1f9ff,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,148 @@
+package vt
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTranscript(t *testing.T) {
+	tests := []struct {
+		name       string
+		cols, rows int
+		input      string
+		want       []string
+	}{
+		{
+			name:  "plain lines",
+			cols:  20,
+			rows:  5,
+			input: "hello\r\nworld\r\n",
+			want:  []string{"hello", "world"},
+		},
+		{
+			name:  "scrolled off lines stay in order",
+			cols:  10,
+			rows:  2,
+			input: "1\r\n2\r\n3\r\n4",
+			want:  []string{"1", "2", "3", "4"},
+		},
+		{
+			name:  "soft wrapped line is joined",
+			cols:  5,
+			rows:  3,
+			input: "abcdefgh\r\n",
+			want:  []string{"abcdefgh"},
+		},
+		{
+			name:  "overwrite with carriage return and erase line",
+			cols:  20,
+			rows:  3,
+			input: "progress 10%\rprogress 100%\r\ndone\x1b[K",
+			want:  []string{"progress 100%", "done"},
+		},
+		{
+			name:  "backspace and delete character",
+			cols:  20,
+			rows:  3,
+			input: "abcx\bd\x1b[2D\x1b[P",
+			want:  []string{"abd"},
+		},
+		{
+			name:  "cursor position and erase display",
+			cols:  10,
+			rows:  3,
+			input: "old\r\nscreen\x1b[2J\x1b[Hnew",
+			want:  []string{"old", "screen", "new"},
+		},
+		{
+			name:  "alternate screen is added when it closes",
+			cols:  20,
+			rows:  3,
+			input: "$ vim\r\n\x1b[?1049h\x1b[2J\x1b[Hediting\x1b[?1049l$ ",
+			want:  []string{"$ vim", "editing", "$"},
+		},
+		{
+			name:  "wide characters take two cells",
+			cols:  4,
+			rows:  3,
+			input: "中文字\r\n",
+			want:  []string{"中文字"},
+		},
+		{
+			name:  "combining characters join the previous one",
+			cols:  10,
+			rows:  3,
+			input: "é!",
+			want:  []string{"é!"},
+		},
+		{
+			name:  "colors and titles are dropped",
+			cols:  20,
+			rows:  3,
+			input: "\x1b]0;title\x07\x1b[1;31mred\x1b[0m \x1b[38;2;1;2;3mrgb",
+			want:  []string{"red rgb"},
+		},
+		{
+			name:  "huge parameters are clamped",
+			cols:  10,
+			rows:  3,
+			input: "a\x1b[9223372036854775807Bb\x1b[99999999999999999999Cc\x1b[9223372036854775807X",
+			want:  []string{"a", "", " b"}, // c is erased by X
+		},
+		{
+			name:  "overlong sequence is ignored",
+			cols:  10,
+			rows:  3,
+			input: "a\x1b[" + strings.Repeat("1;", 1000) + "Hb",
+			want:  []string{"ab"},
+		},
+		{
+			name:  "huge size is clamped",
+			cols:  1 << 40,
+			rows:  1 << 40,
+			input: "ok",
+			want:  []string{"ok"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			term := New(tt.cols, tt.rows)
+			term.Write(tt.input)
+			if got := term.Transcript(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Transcript() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestExtremeParameters runs every CSI sequence with extreme parameters from every corner
+// of the screen; none of them may panic
+func TestExtremeParameters(t *testing.T) {
+	params := []string{"", "0", "1", "65535", "65536", "9223372036854775807", "99999999999999999999", "0;0", "65535;65535", "9223372036854775807;9223372036854775807"}
+	positions := []string{"\x1b[H", "\x1b[3;1H", "\x1b[1;10H", "\x1b[3;10H", "\x1b[3;10Hx"}
+
+	for final := rune(0x40); final <= 0x7e; final++ {
+		for _, p := range params {
+			for _, pos := range positions {
+				for _, alt := range []string{"", "\x1b[?1049h"} {
+					input := fmt.Sprintf("%s%s\x1b[%s%c\x1b[?%s%cok", alt, pos, p, final, p, final)
+					func() {
+						defer func() {
+							if r := recover(); r != nil {
+								t.Fatalf("panic on %q: %v", input, r)
+							}
+						}()
+						term := New(10, 3)
+						term.Write(input)
+						term.Resize(5, 2)
+						term.Write(input)
+						term.Transcript()
+					}()
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,478 @@
+// Package zmodem watches a terminal byte stream for ZMODEM transfers (rz/sz).
+// It does not implement the protocol; the browser does. It only recognises the
+// start of a transfer and follows the frames closely enough to report files,
+// progress and the end of the transfer, and to tell ZMODEM from anything else.
+package zmodem
+
+import (
+	"bytes"
+	"hash/crc32"
+	"strconv"
+)
+
+// Transfer directions, from the browser's point of view
+const (
+	Download = "download" // Remote runs sz, the browser receives
+	Upload   = "upload"   // Remote runs rz, the browser sends
+)
+
+// Protocol bytes
+const (
+	zpad = '*'
+	zdle = 0x18 // Also CAN
+)
+
+// Frame types
+const (
+	zrqinit = 0
+	zrinit  = 1
+	zsinit  = 2
+	zfile   = 4
+	zskip   = 5
+	zabort  = 7
+	zfin    = 8
+	zdata   = 10
+	zeof    = 11
+	zferr   = 12
+	zcan    = 16
+)
+
+// CancelSequence aborts a transfer when written to either side: eight CANs then eight backspaces
+var CancelSequence = []byte{
+	zdle, zdle, zdle, zdle, zdle, zdle, zdle, zdle,
+	0x08, 0x08, 0x08, 0x08, 0x08, 0x08, 0x08, 0x08,
+}
+
+// hexPrefix opens a hex header; 14 hex digits follow: type, four flag bytes and a CRC-16
+var hexPrefix = []byte("**\x18B")
+
+// hexHeaderLen is the length of a hex header up to its CRC
+const hexHeaderLen = 4 + 14
+
+// Detect looks for the start of a transfer in data: a ZRQINIT from sz or a ZRINIT from rz,
+// as a hex header with a valid CRC. tail holds the last bytes of the previous chunk so a
+// header split across reads is still found.
+// It returns the direction and the offset in data where the ZMODEM bytes begin.
+func Detect(tail, data []byte) (direction string, offset int, ok bool) {
+	buf := append(append([]byte(nil), tail...), data...)
+	for from := 0; ; {
+		i := bytes.Index(buf[from:], hexPrefix)
+		if i < 0 || from+i+hexHeaderLen > len(buf) {
+			return "", 0, false
+		}
+		i += from
+		from = i + 1
+
+		header, ok := parseHexHeader(buf[i+len(hexPrefix) : i+hexHeaderLen])
+		if !ok {
+			continue
+		}
+		switch header[0] {
+		case zrqinit:
+			direction = Download
+		case zrinit:
+			direction = Upload
+		default:
+			continue
+		}
+		offset = i - len(tail)
+		if offset < 0 {
+			offset = 0
+		}
+		return direction, offset, true
+	}
+}
+
+// Tail returns the bytes worth keeping for the next Detect call, given the tail passed
+// to the last one and the data it was called with
+func Tail(tail, data []byte) []byte {
+	buf := append(append([]byte(nil), tail...), data...)
+	if n := hexHeaderLen - 1; len(buf) > n {
+		buf = buf[len(buf)-n:]
+	}
+	return buf
+}
+
+// parseHexHeader decodes the 14 hex digits of a hex header into its five bytes, checking the CRC
+func parseHexHeader(digits []byte) ([]byte, bool) {
+	header := make([]byte, 0, 7)
+	for i := 0; i < len(digits); i += 2 {
+		v, err := strconv.ParseUint(string(digits[i:i+2]), 16, 8)
+		if err != nil {
+			return nil, false
+		}
+		header = append(header, byte(v))
+	}
+	if crc16(0, header[:5]) != uint16(header[5])<<8|uint16(header[6]) {
+		return nil, false
+	}
+	return header[:5], true
+}
+
+// crc16 continues the CRC-16/XMODEM of ZMODEM headers and subpackets over data
+func crc16(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Event kinds
+const (
+	EventFile    = "file"    // A ZFILE header announced a file
+	EventData    = "data"    // File data passed; Bytes is the new position
+	EventEOF     = "eof"     // The current file is complete
+	EventSkip    = "skip"    // The receiver skipped the current file
+	EventFin     = "fin"     // ZFIN, the sender is done
+	EventAbort   = "abort"   // The transfer was cancelled
+	EventInvalid = "invalid" // Bytes that are not ZMODEM, or a frame with a bad CRC
+)
+
+// Event is something the Parser saw in the stream
+type Event struct {
+	Kind     string
+	Name     string // EventFile
+	Size     int64  // EventFile, -1 if not announced
+	Position int64  // EventData, EventEOF
+	Offset   int    // EventInvalid: index in the fed data of the offending byte
+}
+
+// Parser states
+const (
+	stateIdle    = iota // Looking for ZPAD
+	statePad            // Seen ZPAD
+	stateDle            // Seen ZPAD ZDLE, expecting the header format
+	stateHex            // Reading a hex header
+	stateBinary         // Reading a binary header
+	stateData           // Reading a data subpacket
+	stateDataCRC        // Reading the CRC after a data subpacket
+)
+
+// Subpacket terminators
+const (
+	zcrce = 'h' // End of frame, header follows
+	zcrcg = 'i' // Frame continues nonstop
+	zcrcq = 'j' // Frame continues, ZACK expected
+	zcrcw = 'k' // End of frame, ZACK expected
+)
+
+// Parser follows one direction of a ZMODEM stream
+type Parser struct {
+	state   int
+	escaped bool // Previous byte was ZDLE
+	cans    int  // Consecutive CANs, five abort the transfer
+
+	crc32   bool   // Binary header / data subpackets use CRC-32
+	header  []byte // Decoded header bytes being collected
+	hex     []byte // Raw hex digits being collected
+	lastHdr int    // Type of the last header seen
+
+	data     []byte // Decoded ZFILE subpacket
+	sum      uint32 // Running CRC of the current subpacket
+	crc      []byte // Decoded CRC bytes of the current subpacket
+	one      [1]byte
+	frameEnd byte  // Terminator of the current subpacket
+	position int64 // ZDATA offset of the next byte
+
+	offset int // Index of the byte being stepped in the fed data
+	events []Event
+}
+
+// NewParser returns a parser positioned outside any frame
+func NewParser() *Parser {
+	return &Parser{lastHdr: -1}
+}
+
+// Feed consumes bytes and returns what happened in them
+func (p *Parser) Feed(data []byte) []Event {
+	p.events = p.events[:0]
+	for i, b := range data {
+		p.offset = i
+		if b == zdle {
+			p.cans++
+			if p.cans >= 5 {
+				p.cans = 0
+				p.reset()
+				p.emit(Event{Kind: EventAbort})
+				continue
+			}
+		} else {
+			p.cans = 0
+		}
+		p.step(b)
+	}
+	return p.events
+}
+
+func (p *Parser) emit(e Event) {
+	p.events = append(p.events, e)
+}
+
+func (p *Parser) reset() {
+	p.state = stateIdle
+	p.escaped = false
+	p.header = p.header[:0]
+	p.hex = p.hex[:0]
+}
+
+// invalid reports the current byte as not ZMODEM and starts over
+func (p *Parser) invalid() {
+	p.reset()
+	p.emit(Event{Kind: EventInvalid, Offset: p.offset})
+}
+
+// betweenFrames reports whether b may appear outside a frame: the line end and XON
+// after a hex header, flow control, and the cancel sequence
+func betweenFrames(b byte) bool {
+	switch b {
+	case '\r', '\n', 0x8d, 0x8a, 0x11, 0x13, 0x91, 0x93, 0x08, zdle:
+		return true
+	}
+	return false
+}
+
+func (p *Parser) step(b byte) {
+	switch p.state {
+	case stateIdle:
+		if b == zpad {
+			p.state = statePad
+		} else if !betweenFrames(b) {
+			p.invalid()
+		}
+
+	case statePad:
+		switch b {
+		case zpad:
+		case zdle:
+			p.state = stateDle
+		default:
+			p.invalid()
+		}
+
+	case stateDle:
+		p.header = p.header[:0]
+		p.hex = p.hex[:0]
+		p.escaped = false
+		switch b {
+		case 'A':
+			p.crc32 = false
+			p.state = stateBinary
+		case 'C':
+			p.crc32 = true
+			p.state = stateBinary
+		case 'B':
+			p.state = stateHex
+		default:
+			p.invalid()
+		}
+
+	case stateHex:
+		// Type, four flag bytes and a CRC-16, as 14 hex digits
+		p.hex = append(p.hex, b)
+		if len(p.hex) == 14 {
+			header, ok := parseHexHeader(p.hex)
+			if !ok {
+				p.invalid()
+				return
+			}
+			p.header = append(p.header[:0], header...)
+			p.onHeader(false)
+		}
+
+	case stateBinary:
+		c, ok := p.unescape(b)
+		if !ok {
+			return
+		}
+		p.header = append(p.header, c)
+		crcLen := 2
+		if p.crc32 {
+			crcLen = 4
+		}
+		if len(p.header) == 5+crcLen {
+			if !p.check(p.header[:5], p.header[5:]) {
+				p.invalid()
+				return
+			}
+			p.onHeader(true)
+		}
+
+	case stateData:
+		if p.escaped {
+			p.escaped = false
+			switch b {
+			case zcrce, zcrcg, zcrcq, zcrcw:
+				p.frameEnd = b
+				p.update(b)
+				p.crc = p.crc[:0]
+				p.state = stateDataCRC
+				return
+			}
+			p.dataByte(decode(b))
+			return
+		}
+		if b == zdle {
+			p.escaped = true
+			return
+		}
+		if b == 0x11 || b == 0x13 || b == 0x91 || b == 0x93 {
+			return // XON/XOFF are ignored inside frames
+		}
+		p.dataByte(b)
+
+	case stateDataCRC:
+		c, ok := p.unescape(b)
+		if !ok {
+			return
+		}
+		p.crc = append(p.crc, c)
+		if p.crc32 && len(p.crc) < 4 || !p.crc32 && len(p.crc) < 2 {
+			return
+		}
+		if !p.matches(p.crc) {
+			p.invalid()
+			return
+		}
+		p.onSubpacket()
+	}
+}
+
+// check reports whether crc is the CRC of data, in the frame's CRC format
+func (p *Parser) check(data, crc []byte) bool {
+	if p.crc32 {
+		return crc32.ChecksumIEEE(data) == leUint32(crc)
+	}
+	return crc16(0, data) == uint16(crc[0])<<8|uint16(crc[1])
+}
+
+// startSubpacket starts reading a data subpacket
+func (p *Parser) startSubpacket() {
+	p.sum = 0
+	p.state = stateData
+}
+
+// update adds a subpacket byte to its running CRC
+func (p *Parser) update(b byte) {
+	p.one[0] = b
+	if p.crc32 {
+		p.sum = crc32.Update(p.sum, crc32.IEEETable, p.one[:])
+	} else {
+		p.sum = uint32(crc16(uint16(p.sum), p.one[:]))
+	}
+}
+
+// matches reports whether crc is the running CRC of the subpacket
+func (p *Parser) matches(crc []byte) bool {
+	if p.crc32 {
+		return p.sum == leUint32(crc)
+	}
+	return uint16(p.sum) == uint16(crc[0])<<8|uint16(crc[1])
+}
+
+func leUint32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+// unescape decodes ZDLE-escaped header and CRC bytes
+func (p *Parser) unescape(b byte) (byte, bool) {
+	if p.escaped {
+		p.escaped = false
+		return decode(b), true
+	}
+	if b == zdle {
+		p.escaped = true
+		return 0, false
+	}
+	return b, true
+}
+
+func decode(b byte) byte {
+	switch b {
+	case 'l':
+		return 0x7f
+	case 'm':
+		return 0xff
+	}
+	return b ^ 0x40
+}
+
+func (p *Parser) onHeader(binary bool) {
+	frameType := int(p.header[0])
+	p.lastHdr = frameType
+	p.state = stateIdle
+
+	switch frameType {
+	case zfile:
+		p.data = p.data[:0]
+		if binary {
+			p.startSubpacket()
+		}
+	case zdata:
+		// Flags hold the file offset, least significant byte first
+		p.position = int64(p.header[1]) | int64(p.header[2])<<8 | int64(p.header[3])<<16 | int64(p.header[4])<<24
+		if binary {
+			p.startSubpacket()
+		}
+	case zsinit:
+		if binary {
+			p.startSubpacket()
+		}
+	case zeof:
+		p.emit(Event{Kind: EventEOF, Position: p.position})
+	case zskip:
+		p.emit(Event{Kind: EventSkip})
+	case zfin:
+		p.emit(Event{Kind: EventFin})
+	case zabort, zferr, zcan:
+		p.emit(Event{Kind: EventAbort})
+	}
+}
+
+func (p *Parser) dataByte(b byte) {
+	p.update(b)
+	switch p.lastHdr {
+	case zfile:
+		if len(p.data) < 4096 {
+			p.data = append(p.data, b)
+		}
+	case zdata:
+		p.position++
+	}
+}
+
+// onSubpacket runs after a data subpacket and its CRC
+func (p *Parser) onSubpacket() {
+	switch p.lastHdr {
+	case zfile:
+		p.emit(fileEvent(p.data))
+	case zdata:
+		p.emit(Event{Kind: EventData, Position: p.position})
+	}
+
+	switch p.frameEnd {
+	case zcrcg, zcrcq:
+		p.startSubpacket()
+	default:
+		p.state = stateIdle
+	}
+}
+
+// fileEvent parses the ZFILE subpacket: "name\0size mtime mode ...\0"
+func fileEvent(data []byte) Event {
+	e := Event{Kind: EventFile, Size: -1}
+	name, rest, _ := bytes.Cut(data, []byte{0})
+	e.Name = string(name)
+	if fields := bytes.Fields(bytes.TrimRight(rest, "\x00")); len(fields) > 0 {
+		if size, err := strconv.ParseInt(string(fields[0]), 10, 64); err == nil {
+			e.Size = size
+		}
+	}
+	return e
+}
@@ -0,0 +1,17 @@
+import api from './index'
+
+export const listAgentKeys = async () => {
+    return await api.get('/agent/keys')
+}
+
+export const addAgentKey = async (keyData) => {
+    return await api.post('/agent/keys', keyData)
+}
+
+export const removeAgentKey = async (fingerprint) => {
+    return await api.delete(`/agent/keys?fingerprint=${encodeURIComponent(fingerprint)}`)
+}
+
+export const clearAgentKeys = async () => {
+    return await api.post('/agent/clear')
+}
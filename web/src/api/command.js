@@ -15,3 +15,19 @@ export const updateCommandTemplate = async (id, data) => {
 export const deleteCommandTemplate = async (id) => {
     return await api.delete(`/command-templates/${id}`)
 }
+
+export const listCommandPolicies = async () => {
+    return await api.get('/admin/command-policies')
+}
+
+export const createCommandPolicy = async (data) => {
+    return await api.post('/admin/command-policies', data)
+}
+
+export const updateCommandPolicy = async (id, data) => {
+    return await api.put(`/admin/command-policies/${id}`, data)
+}
+
+export const deleteCommandPolicy = async (id) => {
+    return await api.delete(`/admin/command-policies/${id}`)
+}
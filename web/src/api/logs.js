@@ -3,3 +3,11 @@ import api from './index'
 export const getConnectionLogs = async (filters = {}) => {
     return await api.get('/connection-logs', { params: filters })
 }
+
+export const getAuditLogs = async (filters = {}) => {
+    return await api.get('/audit-logs', { params: filters })
+}
+
+export const getCommandAudits = async (filters = {}) => {
+    return await api.get('/admin/commands', { params: filters })
+}
@@ -1,16 +1,65 @@
 import api from './index'
 
-export const listRecordings = async () => {
-    return await api.get('/recordings')
+export const listRecordings = async (params = {}) => {
+    return await api.get('/recordings', { params })
+}
+
+export const searchRecordings = async (params = {}) => {
+    return await api.get('/recordings/search', { params })
 }
 
 export const deleteRecording = async (id) => {
     return await api.delete(`/recordings/${id}`)
 }
 
+export const verifyRecording = async (id) => {
+    return await api.get(`/recordings/${id}/verify`)
+}
+
+export const getRecordingSigningKey = async () => {
+    return await api.get('/recordings/signing-key')
+}
+
 import { getWSTicket } from './auth'
 
 export const getRecordingStreamUrl = async (id) => {
     const res = await getWSTicket()
     return `/api/recordings/${id}/stream?token=${res.ticket}`
 }
+
+export const getRecordingExportUrl = async (id, format) => {
+    const res = await getWSTicket()
+    return `/api/recordings/${id}/export?format=${format}&token=${res.ticket}`
+}
+
+export const listRecordingPolicies = async () => {
+    return await api.get('/admin/recording-policies')
+}
+
+export const createRecordingPolicy = async (data) => {
+    return await api.post('/admin/recording-policies', data)
+}
+
+export const updateRecordingPolicy = async (id, data) => {
+    return await api.put(`/admin/recording-policies/${id}`, data)
+}
+
+export const deleteRecordingPolicy = async (id) => {
+    return await api.delete(`/admin/recording-policies/${id}`)
+}
+
+export const listAllRecordings = async (params = {}) => {
+    return await api.get('/admin/recordings', { params })
+}
+
+export const getRecordingUsage = async () => {
+    return await api.get('/admin/recordings/usage')
+}
+
+export const adminDeleteRecording = async (id) => {
+    return await api.delete(`/admin/recordings/${id}`)
+}
+
+export const setRecordingLegalHold = async (id, hold, note = '') => {
+    return await api.put(`/admin/recordings/${id}/hold`, { hold, note })
+}
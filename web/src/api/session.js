@@ -0,0 +1,72 @@
+import api from './index'
+
+export const getTerminalSessions = async (params) => {
+    return await api.get('/terminal/sessions', { params })
+}
+
+export const terminateTerminalSession = async (id) => {
+    return await api.delete(`/terminal/sessions/${id}`)
+}
+
+export const getTerminalSession = async (id) => {
+    return await api.get(`/terminal/sessions/${id}`)
+}
+
+export const createSessionShare = async (id, shareData) => {
+    return await api.post(`/terminal/sessions/${id}/shares`, shareData)
+}
+
+export const revokeSessionShare = async (id, token) => {
+    return await api.delete(`/terminal/sessions/${id}/shares/${token}`)
+}
+
+export const kickSessionViewer = async (id, viewerId) => {
+    return await api.delete(`/terminal/sessions/${id}/viewers/${viewerId}`)
+}
+
+// Broadcast groups
+export const getBroadcastGroups = async () => {
+    return await api.get('/terminal/broadcasts')
+}
+
+export const createBroadcastGroup = async (groupData) => {
+    return await api.post('/terminal/broadcasts', groupData)
+}
+
+export const deleteBroadcastGroup = async (id) => {
+    return await api.delete(`/terminal/broadcasts/${id}`)
+}
+
+export const addBroadcastMember = async (id, sessionId) => {
+    return await api.post(`/terminal/broadcasts/${id}/members`, { session_id: sessionId })
+}
+
+export const setBroadcastMemberExcluded = async (id, sessionId, excluded) => {
+    return await api.put(`/terminal/broadcasts/${id}/members/${sessionId}`, { excluded })
+}
+
+export const removeBroadcastMember = async (id, sessionId) => {
+    return await api.delete(`/terminal/broadcasts/${id}/members/${sessionId}`)
+}
+
+export const broadcastInput = async (id, data) => {
+    return await api.post(`/terminal/broadcasts/${id}/input`, { data })
+}
+
+// Admin live session monitor
+export const getLiveSessions = async (params) => {
+    return await api.get('/admin/sessions', { params })
+}
+
+export const killLiveSession = async (id, reason) => {
+    return await api.post(`/admin/sessions/${id}/kill`, { reason })
+}
+
+// Concurrent connection counts and limits
+export const getConnectionCounts = async () => {
+    return await api.get('/connections/counts')
+}
+
+export const getAllConnectionCounts = async () => {
+    return await api.get('/admin/connections/counts')
+}
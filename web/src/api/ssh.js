@@ -25,6 +25,10 @@ export const testConnection = async (id) => {
     return await api.post(`/ssh-hosts/${id}/test`)
 }
 
+export const launchWebProxy = async (id, port) => {
+    return await api.post(`/ssh-hosts/${id}/web-proxy/${port}`)
+}
+
 export const deployMonitor = async (id, insecure = false) => {
     return await api.post(`/ssh-hosts/${id}/monitor/deploy`, { insecure })
 }
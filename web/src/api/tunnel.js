@@ -0,0 +1,21 @@
+import api from './index'
+
+export const getTunnels = async () => {
+    return await api.get('/tunnels')
+}
+
+export const createTunnel = async (tunnelData) => {
+    return await api.post('/tunnels', tunnelData)
+}
+
+export const deleteTunnel = async (id) => {
+    return await api.delete(`/tunnels/${id}`)
+}
+
+export const startTunnel = async (id) => {
+    return await api.post(`/tunnels/${id}/start`)
+}
+
+export const stopTunnel = async (id) => {
+    return await api.post(`/tunnels/${id}/stop`)
+}
@@ -0,0 +1,84 @@
+// Terminal WebSocket protocol (see internal/handlers/ws_protocol.go).
+// termiscope.v1 frames every message as [1 byte type][payload];
+// termiscope.json is the original text protocol, used when binary is not negotiated.
+
+export const SUBPROTOCOLS = ['termiscope.v1', 'termiscope.json']
+export const BINARY_PROTOCOL = 'termiscope.v1'
+
+export const FRAME = {
+    DATA: 0x01,
+    RESIZE: 0x02,
+    PING: 0x03,
+    PONG: 0x04,
+    EXIT: 0x05,
+    ACK: 0x06,
+    CONTROL: 0x07,
+    ZMODEM: 0x08
+}
+
+const encoder = new TextEncoder()
+const decoder = new TextDecoder()
+
+const frame = (type, payload) => {
+    const buf = new Uint8Array(1 + payload.length)
+    buf[0] = type
+    buf.set(payload, 1)
+    return buf
+}
+
+// encodeMessage turns a JSON protocol message into a binary frame
+export const encodeMessage = (msg) => {
+    if (msg.type === 'input') {
+        return frame(FRAME.DATA, encoder.encode(msg.data))
+    }
+    if (msg.type === 'resize') {
+        const payload = new Uint8Array(4)
+        const view = new DataView(payload.buffer)
+        view.setUint16(0, msg.data.cols)
+        view.setUint16(2, msg.data.rows)
+        return frame(FRAME.RESIZE, payload)
+    }
+    return frame(FRAME.CONTROL, encoder.encode(JSON.stringify(msg)))
+}
+
+export const encodeAck = (bytes) => {
+    const payload = new Uint8Array(4)
+    new DataView(payload.buffer).setUint32(0, bytes)
+    return frame(FRAME.ACK, payload)
+}
+
+export const encodePong = (payload) => frame(FRAME.PONG, payload)
+
+export const encodeZmodem = (octets) => frame(FRAME.ZMODEM, Uint8Array.from(octets))
+
+// ZMODEM bytes travel base64 encoded in the JSON protocol
+export const toBase64 = (octets) => {
+    let binary = ''
+    for (const b of octets) binary += String.fromCharCode(b)
+    return btoa(binary)
+}
+
+export const fromBase64 = (data) => Uint8Array.from(atob(data), (c) => c.charCodeAt(0))
+
+// decodeFrame splits a binary frame into { type, payload }, plus the parsed
+// message for control frames and { code, signal, reason } for exit frames
+export const decodeFrame = (data) => {
+    const bytes = new Uint8Array(data)
+    const type = bytes[0]
+    const payload = bytes.subarray(1)
+    if (type === FRAME.CONTROL) {
+        return { type, payload, msg: JSON.parse(decoder.decode(payload)) }
+    }
+    if (type === FRAME.EXIT) {
+        const code = new DataView(payload.buffer, payload.byteOffset, 4).getInt32(0)
+        const signalLength = payload[4]
+        return {
+            type,
+            payload,
+            code: code >= 0 ? code : null,
+            signal: decoder.decode(payload.subarray(5, 5 + signalLength)),
+            reason: decoder.decode(payload.subarray(5 + signalLength))
+        }
+    }
+    return { type, payload }
+}
@@ -12,6 +12,10 @@ export default defineConfig({
         changeOrigin: true,
         ws: true,
       },
+      '/proxy': {
+        target: 'http://localhost:8080',
+        ws: true,
+      },
     },
   },
 })